@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"io"
+	"lido-near-client/internal/application"
+	"lido-near-client/internal/apy"
+	"os"
+	"text/tabwriter"
+)
+
+func apyCommand(ctxCli *cli.Context) error {
+	env, err := newAppEnv(ctxCli, false)
+	if err != nil {
+		return cli.Exit(err.Error(), exitSetupError)
+	}
+	defer env.stop()
+
+	report, err := env.app.APY()
+	if errors.Is(err, application.ErrStorageDisabled) {
+		return cli.Exit(errors.Wrap(err, "apy: set DB_DSN").Error(), exitSetupError)
+	}
+	if err != nil {
+		return cli.Exit(errors.Wrap(err, "apy").Error(), exitJobFailed)
+	}
+	return printAPY(os.Stdout, report, ctxCli.String("output"))
+}
+
+func printAPY(w io.Writer, report apy.Report, output string) error {
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case outputTable:
+		return printAPYTable(w, report)
+	}
+	return errors.Errorf("unknown output format %s", output)
+}
+
+func printAPYTable(w io.Writer, report apy.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pool epoch\t%d\n", report.PoolEpochHeight)
+	fmt.Fprintf(tw, "Exchange rate\t%s NEAR per token\n", report.ExchangeRate.StringFixed(8))
+	fmt.Fprintf(tw, "Reward fee\t%.2f%%\n", report.RewardFee*100)
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "PERIOD\tFROM EPOCH\tDAYS\tGROSS APY\tNET APY")
+	for _, window := range report.Windows {
+		if !window.Available {
+			fmt.Fprintf(tw, "%s\t-\t-\tnot enough history\t\n", window.Period)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%.2f%%\n",
+			window.Period, window.FromEpochHeight, window.Days, window.GrossAPY*100, window.NetAPY*100)
+	}
+	return tw.Flush()
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
-	"github.com/go-co-op/gocron"
+	"context"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"lido-near-client/internal/api"
 	"lido-near-client/internal/application"
+	"lido-near-client/internal/application/stakepool"
 	"lido-near-client/internal/config"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
+)
+
+// exit codes of the one-shot commands
+const (
+	exitJobFailed  = 1
+	exitSetupError = 2
 )
 
 func main() {
@@ -21,8 +28,73 @@ func main() {
 		log.Fatalf("os.Setenv (TZ): %s", err.Error())
 	}
 	app := &cli.App{
-		Action:   mainCommand,
-		Commands: nil,
+		Name:   "lido",
+		Usage:  "operator of the NEAR stake pool contract",
+		Action: runCommand,
+		Commands: []*cli.Command{
+			{
+				Name:   "run",
+				Usage:  "run the daemon with periodic jobs (default)",
+				Action: runCommand,
+			},
+			{
+				Name:   "pool-update",
+				Usage:  "update validators and the pool to the network epoch once",
+				Action: jobCommand(stakepool.PoolUpdateJob, func(app *application.Application, _ *cli.Context) error { return app.StakePool.PoolUpdate() }),
+			},
+			{
+				Name:   "increase-stake",
+				Usage:  "distribute classic unstaked balance across validators once",
+				Action: jobCommand(stakepool.IncreaseStakeJob, func(app *application.Application, _ *cli.Context) error { return app.StakePool.IncreaseStake() }),
+			},
+			{
+				Name:  "take-unstaked",
+				Usage: "take withdrawable unstaked balance from validators once",
+				Action: jobCommand(stakepool.TakeUnstakedBalanceJob, func(app *application.Application, _ *cli.Context) error {
+					return app.StakePool.TakeUnstakedBalance()
+				}),
+			},
+			{
+				Name:  "decrease-stake",
+				Usage: "unstake requested withdrawals from validators once",
+				Action: jobCommand(stakepool.RequestedDecreaseValidatorStakeJob, func(app *application.Application, _ *cli.Context) error {
+					return app.StakePool.RequestedDecreaseValidatorStake()
+				}),
+			},
+			{
+				Name:  "update-validator",
+				Usage: "update a single validator to the network epoch",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "id",
+						Usage:    "validator account id",
+						Required: true,
+					},
+				},
+				Action: jobCommand(stakepool.UpdateValidatorJob, func(app *application.Application, ctxCli *cli.Context) error {
+					return app.StakePool.UpdateValidator(ctxCli.String("id"))
+				}),
+			},
+			{
+				Name:   "status",
+				Usage:  "print a read-only snapshot of the pool",
+				Flags:  []cli.Flag{outputFlag()},
+				Action: statusCommand,
+			},
+			{
+				Name:   "apy",
+				Usage:  "print the exchange rate and the realized APY from the recorded history",
+				Flags:  []cli.Flag{outputFlag()},
+				Action: apyCommand,
+			},
+		},
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "build plans of the jobs once, print them and exit without signing transactions",
+			},
+			outputFlag(),
+		},
 	}
 	err = app.Run(os.Args)
 	if err != nil {
@@ -30,51 +102,140 @@ func main() {
 	}
 }
 
-func mainCommand(ctxCli *cli.Context) error {
+func outputFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "output",
+		Value: outputTable,
+		Usage: "output format: table or json",
+	}
+}
+
+type appEnv struct {
+	ctx    context.Context
+	cfg    config.Config
+	app    *application.Application
+	logger *zap.Logger
+	dryRun *stakepool.DryRunExecutor
+	stop   func()
+}
+
+// newAppEnv builds the application, readOnly leaves out the database migrations and the job journal.
+func newAppEnv(ctxCli *cli.Context, readOnly bool) (env appEnv, err error) {
 	ctx, stop := signal.NotifyContext(ctxCli.Context, syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
 	cfg, err := config.GetConfig()
 	if err != nil {
-		return errors.Wrap(err, "get config")
+		stop()
+		return env, errors.Wrap(err, "get config")
 	}
-	logger := getLogger(cfg.LogLevel)
+	env.logger = getLogger(cfg.LogLevel)
+	env.ctx = ctx
+	env.cfg = cfg
+	env.stop = stop
 
-	app, err := application.New(application.Params{
-		Ctx: ctx,
-		Log: logger,
-		Cfg: cfg,
-	})
+	params := application.Params{
+		Ctx:      ctx,
+		Log:      env.logger,
+		Cfg:      cfg,
+		ReadOnly: readOnly,
+	}
+	if ctxCli.Bool("dry-run") {
+		env.dryRun = stakepool.NewDryRunExecutor()
+		params.Executor = env.dryRun
+	}
+	env.app, err = application.New(params)
 	if err != nil {
-		logger.Fatal("new application", zap.Error(err))
+		stop()
+		return env, errors.Wrap(err, "new application")
+	}
+	env.stop = func() {
+		stop()
+		env.app.Close()
 	}
+	return env, nil
+}
 
+func runCommand(ctxCli *cli.Context) error {
+	env, err := newAppEnv(ctxCli, false)
 	if err != nil {
-		logger.Fatal("new http rest server", zap.Error(err))
+		return cli.Exit(err.Error(), exitSetupError)
 	}
-	startCron(app, logger)
-	<-ctx.Done()
+	defer env.stop()
+
+	// a dry run sends nothing and bypasses the coordinator, IncreaseStake is planned even if the pool lags
+	if env.dryRun != nil {
+		err = env.app.StakePool.PoolUpdate()
+		if err != nil {
+			return cli.Exit(errors.Wrap(err, "PoolUpdate").Error(), exitJobFailed)
+		}
+		err = env.app.StakePool.IncreaseStake()
+		if err != nil {
+			return cli.Exit(errors.Wrap(err, "IncreaseStake").Error(), exitJobFailed)
+		}
+		return printPlans(os.Stdout, env.dryRun.Plans(), ctxCli.String("output"))
+	}
+
+	lock, err := application.LockJobs(env.cfg)
+	if err != nil {
+		return cli.Exit(errors.Wrap(err, "LockJobs").Error(), exitSetupError)
+	}
+	defer lock.Release()
+
+	if env.cfg.MetricsAddr != "" {
+		go func() {
+			err := env.app.Metrics.Serve(env.ctx, env.cfg.MetricsAddr)
+			if err != nil {
+				env.logger.Error("metrics server", zap.Error(err))
+			}
+		}()
+	}
+	if env.cfg.HTTPAddr != "" {
+		go func() {
+			err := api.New(env.app, env.logger).Serve(env.ctx, env.cfg.HTTPAddr)
+			if err != nil {
+				env.logger.Error("new http rest server", zap.Error(err))
+			}
+		}()
+	}
+	application.NewScheduler(env.app, env.logger, env.cfg.Schedule).Run(env.ctx)
 	return nil
 }
 
-func startCron(app *application.Application, logger *zap.Logger) {
-	cron := gocron.NewScheduler(time.UTC)
-	cron.Every(10).Minutes().Do(func() {
-		err := app.StakePool.PoolUpdate()
+// jobCommand runs the job once and exits with exitJobFailed if it fails. The job runs through the
+// coordinator and under the jobs lock, so it fails while the daemon of the pool runs. A dry run sends
+// nothing and runs the job directly.
+func jobCommand(job string, run func(app *application.Application, ctxCli *cli.Context) error) cli.ActionFunc {
+	return func(ctxCli *cli.Context) error {
+		env, err := newAppEnv(ctxCli, false)
 		if err != nil {
-			logger.Error("PoolUpdate", zap.Error(err))
-			return
+			return cli.Exit(err.Error(), exitSetupError)
+		}
+		defer env.stop()
+
+		if env.dryRun != nil {
+			err = run(env.app, ctxCli)
+		} else {
+			err = runJobOnce(env, job, func() error { return run(env.app, ctxCli) })
 		}
-	})
-	cron.Every(10).Minutes().Do(func() {
-		err := app.StakePool.IncreaseStake()
 		if err != nil {
-			logger.Error("IncreaseStake", zap.Error(err))
-			return
+			return cli.Exit(errors.Wrap(err, ctxCli.Command.Name).Error(), exitJobFailed)
+		}
+		if env.dryRun != nil {
+			return printPlans(os.Stdout, env.dryRun.Plans(), ctxCli.String("output"))
 		}
-	})
-	cron.StartAsync()
+		return nil
+	}
+}
+
+func runJobOnce(env appEnv, job string, run func() error) error {
+	lock, err := application.LockJobs(env.cfg)
+	if err != nil {
+		return errors.Wrap(err, "LockJobs")
+	}
+	defer lock.Release()
+	return env.app.RunJob(job, run)
 }
 
+// getLogger writes JSON logs to stderr, stdout is left to the command output.
 func getLogger(lvl string) *zap.Logger {
 	atom := zap.NewAtomicLevel()
 
@@ -94,7 +255,7 @@ func getLogger(lvl string) *zap.Logger {
 
 	return zap.New(zapcore.NewCore(
 		zapcore.NewJSONEncoder(encoderCfg),
-		zapcore.Lock(os.Stdout),
+		zapcore.Lock(os.Stderr),
 		atom,
 	), zap.AddStacktrace(zap.DPanicLevel), zap.AddCallerSkip(0))
 }
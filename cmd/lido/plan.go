@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"lido-near-client/internal/application/stakepool"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printPlans(w io.Writer, plans []stakepool.Plan, output string) error {
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	case outputTable:
+		for _, plan := range plans {
+			err := printPlanTable(w, plan)
+			if err != nil {
+				return errors.Wrap(err, "printPlanTable")
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("unknown output format %s", output)
+}
+
+func printPlanTable(w io.Writer, plan stakepool.Plan) error {
+	if plan.Phase != "" {
+		fmt.Fprintf(w, "%s (%s)\n", plan.Job, plan.Phase)
+	} else {
+		fmt.Fprintf(w, "%s\n", plan.Job)
+	}
+	if plan.CatchUp != nil {
+		fmt.Fprintf(w, "  catch-up: %s\n", plan.CatchUp)
+	}
+	if len(plan.Steps) == 0 {
+		fmt.Fprintf(w, "  nothing to do: %s\n\n", plan.Skip)
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  #\tMETHOD\tVALIDATOR\tAMOUNT (NEAR)\tGAS (TGAS)\tREASON")
+	for i, step := range plan.Steps {
+		amount := "-"
+		if !step.Amount.IsZero() {
+			amount = formatNear(step.Amount)
+		}
+		validator := step.Validator
+		if validator == "" {
+			validator = "-"
+		}
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%d\t%s\n", i+1, step.Method, validator, amount, step.Gas/1e12, step.Reason)
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+	"io"
+	"lido-near-client/internal/application/stakepool"
+	"os"
+	"text/tabwriter"
+)
+
+func statusCommand(ctxCli *cli.Context) error {
+	env, err := newAppEnv(ctxCli, true)
+	if err != nil {
+		return cli.Exit(err.Error(), exitSetupError)
+	}
+	defer env.stop()
+
+	status, err := env.app.Status()
+	if err != nil {
+		return cli.Exit(errors.Wrap(err, "status").Error(), exitJobFailed)
+	}
+	return printStatus(os.Stdout, status, ctxCli.String("output"))
+}
+
+func printStatus(w io.Writer, status stakepool.Status, output string) error {
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	case outputTable:
+		return printStatusTable(w, status)
+	}
+	return errors.Errorf("unknown output format %s", output)
+}
+
+func printStatusTable(w io.Writer, status stakepool.Status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	progress := status.EpochProgress
+	fmt.Fprintf(tw, "Pool epoch\t%d\n", status.Epochs.PoolEpochHeight)
+	fmt.Fprintf(tw, "Network epoch\t%d\n", status.Epochs.NetworkEpochHeight)
+	if status.CatchUp != nil {
+		fmt.Fprintf(tw, "Catch-up\t%s\n", status.CatchUp)
+	}
+	fmt.Fprintf(tw, "Epoch progress\t%.1f%% (%d/%d blocks, block %d)\n",
+		progress.Progress*100, progress.Position, progress.EpochLength, progress.BlockHeight)
+	fmt.Fprintf(tw, "Stake distributed\t%t\n", status.IsStakeDistributed)
+	fmt.Fprintf(tw, "Operator\t%s, %s NEAR\n", status.OperatorAccountID, formatNear(status.OperatorBalance))
+	for _, n := range status.Nodes {
+		if n.Active {
+			fmt.Fprintf(tw, "RPC node\t%s, block %d, healthy %t\n", n.URL, n.BlockHeight, n.Healthy)
+		}
+	}
+	fmt.Fprintln(tw)
+
+	fund := status.Fund
+	fmt.Fprintln(tw, "FUND\tNEAR")
+	fmt.Fprintf(tw, "Classic unstaked\t%s\n", formatNear(fund.ClassicUnstakedBalance))
+	fmt.Fprintf(tw, "Classic staked\t%s\n", formatNear(fund.ClassicStakedBalance))
+	fmt.Fprintf(tw, "Investment staked\t%s\n", formatNear(fund.InvestmentStakedBalance))
+	fmt.Fprintf(tw, "Common staked\t%s\n", formatNear(fund.CommonStakedBalance))
+	fmt.Fprintf(tw, "Common balance\t%s\n", formatNear(fund.CommonBalance))
+	fmt.Fprintln(tw)
+
+	requested := status.RequestedToWithdrawalFund
+	fmt.Fprintln(tw, "REQUESTED TO WITHDRAWAL\tNEAR")
+	fmt.Fprintf(tw, "Classic\t%s\n", formatNear(requested.ClassicNearAmount))
+	fmt.Fprintf(tw, "Investment\t%s\n", formatNear(requested.InvestmentNearAmount))
+	for _, v := range requested.InvestmentWithdrawalRegistry {
+		if len(v) == 2 {
+			fmt.Fprintf(tw, "  %v\t%v\n", v[0], v[1])
+		}
+	}
+	fmt.Fprintln(tw)
+
+	agg := status.AggInfo
+	fmt.Fprintln(tw, "AGGREGATED INFO\t")
+	fmt.Fprintf(tw, "Staked balance\t%s NEAR\n", formatNear(agg.StakedBalance))
+	fmt.Fprintf(tw, "Unstaked balance\t%s NEAR\n", formatNear(agg.UnstakedBalance))
+	fmt.Fprintf(tw, "Token total supply\t%s\n", formatNear(agg.TokenTotalSupply))
+	fmt.Fprintf(tw, "Token accounts\t%d\n", agg.TokenAccountsQuantity)
+	fmt.Fprintf(tw, "Total rewards\t%s NEAR\n", formatNear(agg.TotalRewardsFromValidatorsNearAmount))
+	if agg.RewardFee != nil {
+		fmt.Fprintf(tw, "Reward fee\t%s%%\n", agg.RewardFee.GetValue().Mul(decimal.New(100, 0)).StringFixed(2))
+	}
+	if v := status.Valuation; v != nil {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "VALUATION\tUSD")
+		fmt.Fprintf(tw, "NEAR price\t%s\n", v.NearPriceUSD.String())
+		fmt.Fprintf(tw, "TVL\t%s\n", v.TVL.StringFixed(2))
+		fmt.Fprintf(tw, "Accrued rewards\t%s\n", v.AccruedRewardsUSD.StringFixed(2))
+		if v.OperatorGasSpend.Valid {
+			fmt.Fprintf(tw, "Operator gas spend this epoch\t%s (%s NEAR)\n",
+				v.OperatorGasSpendUSD.Decimal.StringFixed(2), formatNear(v.OperatorGasSpend.Decimal))
+		}
+	}
+	err := tw.Flush()
+	if err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VALIDATOR\tCLASSIC\tINVESTMENT\tUNSTAKED\tONLY INVESTMENT\tUPDATED\tLAST INCREASE")
+	for _, v := range status.Validators {
+		lastIncrease := "-"
+		if v.LastClassicStakeIncreasingEpochHeight != nil {
+			lastIncrease = fmt.Sprintf("%d", *v.LastClassicStakeIncreasingEpochHeight)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%d\t%s\n", v.AccountID,
+			formatNear(v.ClassicStakedBalance), formatNear(v.InvestmentStakedBalance), formatNear(v.UnstakedBalance),
+			v.IsOnlyForInvestment, v.LastUpdateEpochHeight, lastIncrease)
+	}
+	return tw.Flush()
+}
+
+// formatNear renders yoctoNEAR amount in NEAR.
+func formatNear(amount decimal.Decimal) string {
+	return amount.Div(decimal.New(1, 24)).StringFixed(4)
+}
@@ -0,0 +1,165 @@
+// Package api serves a read-only REST API with the stake pool state and the job history.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application"
+	"lido-near-client/internal/apy"
+	"lido-near-client/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultJobsLimit = 20
+
+type (
+	API struct {
+		app *application.Application
+		log *zap.Logger
+		mux *http.ServeMux
+	}
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+func New(app *application.Application, log *zap.Logger) *API {
+	a := &API{
+		app: app,
+		log: log,
+		mux: http.NewServeMux(),
+	}
+	a.handle("/api/v1/epochs", func(r *http.Request) (interface{}, error) {
+		return a.app.StakePool.GetEpochHeightRegistry()
+	})
+	a.handle("/api/v1/validators", func(r *http.Request) (interface{}, error) {
+		return a.app.StakePool.GetValidatorRegistry()
+	})
+	a.handle("/api/v1/fund", func(r *http.Request) (interface{}, error) {
+		return a.app.StakePool.GetFund()
+	})
+	a.handle("/api/v1/requested-withdrawals", func(r *http.Request) (interface{}, error) {
+		return a.app.StakePool.GetRequestedToWithdrawalFund()
+	})
+	a.handle("/api/v1/agg-info", func(r *http.Request) (interface{}, error) {
+		return a.app.StakePool.GetAggInfo()
+	})
+	a.handle("/api/v1/apy", func(r *http.Request) (interface{}, error) {
+		return a.app.APY()
+	})
+	a.handle("/api/v1/validators/performance", a.validatorPerformance)
+	a.handle("/api/v1/validators/fees", a.validatorFees)
+	a.handle("/api/v1/jobs", a.jobs)
+	return a
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Serve listens on the address until ctx is done.
+func (a *API) Serve(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           a,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "ListenAndServe")
+	}
+	return nil
+}
+
+func (a *API) handle(path string, handler func(r *http.Request) (interface{}, error)) {
+	a.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			a.write(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+		resp, err := handler(r)
+		if err != nil {
+			var badRequest badRequestError
+			if errors.As(err, &badRequest) {
+				a.write(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, storage.ErrNotFound) {
+				a.write(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, application.ErrStorageDisabled) || errors.Is(err, apy.ErrNoData) {
+				a.write(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+				return
+			}
+			a.log.Error("api", zap.String("path", path), zap.Error(err))
+			a.write(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+			return
+		}
+		a.write(w, http.StatusOK, resp)
+	})
+}
+
+func (a *API) write(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		a.log.Warn("api: write response", zap.Error(err))
+	}
+}
+
+func (a *API) jobs(r *http.Request) (interface{}, error) {
+	limit := defaultJobsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, badRequestError{msg: "invalid limit"}
+		}
+	}
+	return a.app.History.Runs(limit), nil
+}
+
+func (a *API) validatorPerformance(r *http.Request) (interface{}, error) {
+	epoch, err := epochParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.app.ValidatorPerformance(epoch)
+}
+
+func (a *API) validatorFees(r *http.Request) (interface{}, error) {
+	epoch, err := epochParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.app.ValidatorFees(epoch)
+}
+
+// epochParam parses the optional epoch query parameter, zero if it is not set.
+func epochParam(r *http.Request) (epoch uint64, err error) {
+	if v := r.URL.Query().Get("epoch"); v != "" {
+		epoch, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, badRequestError{msg: "invalid epoch"}
+		}
+	}
+	return epoch, nil
+}
+
+type badRequestError struct {
+	msg string
+}
+
+func (e badRequestError) Error() string {
+	return e.msg
+}
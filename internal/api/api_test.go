@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/api"
+	"lido-near-client/internal/application"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/application/stakepool/simulator"
+	"lido-near-client/internal/config"
+	"lido-near-client/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAPI(t *testing.T, withStorage bool) (*api.API, *application.Application) {
+	t.Helper()
+	sim := simulator.New(simulator.Params{StakePool: "pool.test.near", Operator: "operator.test.near"})
+	t.Cleanup(sim.Close)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AdvanceEpoch(0.5)
+
+	keyPair, err := key.GenerateKeyPair(key.KeyTypeED25519, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{
+		Node:             sim.URL(),
+		StakePool:        "pool.test.near",
+		KeyPair:          keyPair.PrivateEncoded(),
+		KeyPairAccountID: "operator.test.near",
+	}
+	if withStorage {
+		cfg.DBDriver = storage.DriverSQLite
+		cfg.DBDSN = filepath.Join(t.TempDir(), "lido.db")
+	}
+	app, err := application.New(application.Params{Ctx: context.Background(), Log: zap.NewNop(), Cfg: cfg})
+	if err != nil {
+		t.Fatalf("application.New: %s", err)
+	}
+	t.Cleanup(app.Close)
+	return api.New(app, zap.NewNop()), app
+}
+
+func get(t *testing.T, a *api.API, method, target string, resp interface{}) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: content type %q", target, ct)
+	}
+	body := w.Body.String()
+	if resp != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal([]byte(body), resp); err != nil {
+			t.Fatalf("%s: %s: %s", target, err, body)
+		}
+	}
+	return w.Code, body
+}
+
+func TestHandlers(t *testing.T) {
+	a, app := newTestAPI(t, false)
+
+	var epochs stakepool.EpochHeightRegistry
+	if code, body := get(t, a, http.MethodGet, "/api/v1/epochs", &epochs); code != http.StatusOK || epochs.NetworkEpochHeight != 1 {
+		t.Errorf("epochs: %d %s", code, body)
+	}
+	var validators []stakepool.Validator
+	if code, body := get(t, a, http.MethodGet, "/api/v1/validators", &validators); code != http.StatusOK || len(validators) != 1 {
+		t.Errorf("validators: %d %s", code, body)
+	}
+	for i := 0; i < 3; i++ {
+		_ = app.RunJob("test", func() error { return nil })
+	}
+	var runs []application.JobRun
+	if code, body := get(t, a, http.MethodGet, "/api/v1/jobs?limit=2", &runs); code != http.StatusOK || len(runs) != 2 {
+		t.Errorf("jobs: %d %s", code, body)
+	}
+
+	for _, tt := range []struct {
+		method, target string
+		code           int
+		error          string
+	}{
+		{http.MethodPost, "/api/v1/epochs", http.StatusMethodNotAllowed, "method not allowed"},
+		{http.MethodGet, "/api/v1/jobs?limit=0", http.StatusBadRequest, "invalid limit"},
+		{http.MethodGet, "/api/v1/jobs?limit=x", http.StatusBadRequest, "invalid limit"},
+		{http.MethodGet, "/api/v1/validators/fees?epoch=-1", http.StatusBadRequest, "invalid epoch"},
+		{http.MethodGet, "/api/v1/validators/performance?epoch=x", http.StatusBadRequest, "invalid epoch"},
+		// the recorded data requires the database
+		{http.MethodGet, "/api/v1/validators/fees", http.StatusServiceUnavailable, application.ErrStorageDisabled.Error()},
+		{http.MethodGet, "/api/v1/validators/performance?epoch=1", http.StatusServiceUnavailable, application.ErrStorageDisabled.Error()},
+		{http.MethodGet, "/api/v1/apy", http.StatusServiceUnavailable, application.ErrStorageDisabled.Error()},
+	} {
+		code, body := get(t, a, tt.method, tt.target, nil)
+		if code != tt.code || !strings.Contains(body, `"error":"`+tt.error+`"`) {
+			t.Errorf("%s %s: %d %s, want %d %q", tt.method, tt.target, code, strings.TrimSpace(body), tt.code, tt.error)
+		}
+	}
+}
+
+func TestRecordedHandlers(t *testing.T) {
+	a, app := newTestAPI(t, true)
+	if code, body := get(t, a, http.MethodGet, "/api/v1/validators/fees", nil); code != http.StatusNotFound {
+		t.Errorf("fees before recording: %d %s", code, body)
+	}
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		err := app.Storage.SaveValidatorFees([]stakepool.ValidatorFee{{
+			AccountID:   "a.test.near",
+			EpochHeight: epoch,
+			RewardFee:   stakepool.Dividing{Numerator: decimal.NewFromInt(int64(epoch)), Denominator: decimal.NewFromInt(100)},
+		}})
+		if err != nil {
+			t.Fatalf("SaveValidatorFees: %s", err)
+		}
+	}
+	for target, want := range map[string]uint64{
+		"/api/v1/validators/fees":         2,
+		"/api/v1/validators/fees?epoch=1": 1,
+	} {
+		var fees []stakepool.ValidatorFee
+		code, body := get(t, a, http.MethodGet, target, &fees)
+		if code != http.StatusOK || len(fees) != 1 || fees[0].EpochHeight != want {
+			t.Errorf("%s: %d %s, want epoch %d", target, code, body, want)
+		}
+	}
+}
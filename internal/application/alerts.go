@@ -0,0 +1,149 @@
+package application
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/config"
+	"lido-near-client/internal/notify"
+	"sync"
+)
+
+const (
+	alertJobFailures        = "job-failures"
+	alertEpochLag           = "epoch-lag"
+	alertMissedDistribution = "missed-distribution"
+	alertFeeRaised          = "validator-fee-raised"
+)
+
+// alertRules keeps the state the alerts are derived from.
+type alertRules struct {
+	mu       sync.Mutex
+	failures map[string]int
+	// inWindow is the last status observed in the distribution window of its epoch.
+	inWindow *stakepool.Status
+	// fees are the last observed validator fees by account
+	fees map[string]stakepool.ValidatorFee
+}
+
+func newNotifiers(cfg config.AlertConfig) (notifiers []notify.Notifier) {
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, notify.Webhook{URL: cfg.WebhookURL})
+	}
+	if cfg.SlackWebhookURL != "" {
+		notifiers = append(notifiers, notify.Slack{WebhookURL: cfg.SlackWebhookURL})
+	}
+	if cfg.TelegramBotToken != "" && cfg.TelegramChatID != "" {
+		notifiers = append(notifiers, notify.Telegram{URL: cfg.TelegramURL, BotToken: cfg.TelegramBotToken, ChatID: cfg.TelegramChatID})
+	}
+	if cfg.SMTPAddr != "" && len(cfg.SMTPTo) != 0 {
+		notifiers = append(notifiers, notify.SMTP{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			To:       cfg.SMTPTo,
+		})
+	}
+	return notifiers
+}
+
+// checkJob alerts when the job fails several times in a row.
+func (app *Application) checkJob(job string, err error) {
+	key := alertJobFailures + ":" + job
+	app.rules.mu.Lock()
+	if err == nil {
+		delete(app.rules.failures, job)
+		app.rules.mu.Unlock()
+		app.Alerts.Resolve(key)
+		return
+	}
+	app.rules.failures[job]++
+	failures := app.rules.failures[job]
+	app.rules.mu.Unlock()
+
+	if failures < app.alertCfg.JobFailures {
+		return
+	}
+	app.Alerts.Alert(notify.Alert{
+		Key:      key,
+		Severity: notify.SeverityWarning,
+		Title:    fmt.Sprintf("%s keeps failing", job),
+		Message:  fmt.Sprintf("%d failures in a row, last error: %s", failures, err),
+	})
+}
+
+// checkStatus alerts when the pool lags the network or the distribution window passes without distribution.
+func (app *Application) checkStatus(status stakepool.Status) {
+	epochs, progress := status.Epochs, status.EpochProgress
+	lag := epochs.NetworkEpochHeight - epochs.PoolEpochHeight
+	switch {
+	case epochs.PoolEpochHeight+2 <= epochs.NetworkEpochHeight:
+		app.Alerts.Alert(notify.Alert{
+			Key:      alertEpochLag,
+			Severity: notify.SeverityCritical,
+			Title:    "Pool lags the network",
+			Message:  fmt.Sprintf("pool epoch %d, network epoch %d: %d epochs behind", epochs.PoolEpochHeight, epochs.NetworkEpochHeight, lag),
+		})
+	case lag == 1 && progress.Progress >= 0.1:
+		app.Alerts.Alert(notify.Alert{
+			Key:      alertEpochLag,
+			Severity: notify.SeverityWarning,
+			Title:    "Pool is not updated to the network epoch",
+			Message:  fmt.Sprintf("pool epoch %d, network epoch %d at %.0f%% progress", epochs.PoolEpochHeight, epochs.NetworkEpochHeight, progress.Progress*100),
+		})
+	case epochs.PoolEpochHeight >= epochs.NetworkEpochHeight:
+		app.Alerts.Resolve(alertEpochLag)
+	}
+
+	app.rules.mu.Lock()
+	last := app.rules.inWindow
+	if last != nil && last.Epochs.NetworkEpochHeight < epochs.NetworkEpochHeight {
+		app.rules.inWindow = nil
+	}
+	if progress.InDistributionWindow() {
+		app.rules.inWindow = &status
+	}
+	app.rules.mu.Unlock()
+
+	if status.IsStakeDistributed {
+		app.Alerts.Resolve(alertMissedDistribution)
+	}
+	if last == nil || last.Epochs.NetworkEpochHeight >= epochs.NetworkEpochHeight {
+		return
+	}
+	if !last.IsStakeDistributed && last.Fund.ClassicUnstakedBalance.GreaterThanOrEqual(stakepool.MinRebalanceStake) {
+		app.Alerts.Alert(notify.Alert{
+			Key:      alertMissedDistribution,
+			Severity: notify.SeverityWarning,
+			Title:    "Distribution window missed",
+			Message: fmt.Sprintf("stake was not distributed in epoch %d, %s NEAR left unstaked",
+				last.Epochs.NetworkEpochHeight, last.Fund.ClassicUnstakedBalance.Div(decimal.New(1, 24)).StringFixed(4)),
+		})
+	}
+}
+
+// checkFees alerts on every validator which raised its reward fee since the last observation.
+func (app *Application) checkFees(fees []stakepool.ValidatorFee) {
+	app.rules.mu.Lock()
+	previous := app.rules.fees
+	app.rules.fees = make(map[string]stakepool.ValidatorFee, len(fees))
+	for _, f := range fees {
+		app.rules.fees[f.AccountID] = f
+	}
+	app.rules.mu.Unlock()
+
+	for _, f := range fees {
+		last, ok := previous[f.AccountID]
+		if !ok || !f.Fraction().GreaterThan(last.Fraction()) {
+			continue
+		}
+		app.Alerts.Alert(notify.Alert{
+			Key:      fmt.Sprintf("%s:%s:%d", alertFeeRaised, f.AccountID, f.EpochHeight),
+			Severity: notify.SeverityWarning,
+			Title:    fmt.Sprintf("%s raised its reward fee", f.AccountID),
+			Message: fmt.Sprintf("reward fee %s%% in epoch %d, was %s%% in epoch %d", f.Fraction().Shift(2).StringFixed(2),
+				f.EpochHeight, last.Fraction().Shift(2).StringFixed(2), last.EpochHeight),
+		})
+	}
+}
@@ -1,38 +1,302 @@
 package application
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/apy"
 	"lido-near-client/internal/config"
+	"lido-near-client/internal/metrics"
+	"lido-near-client/internal/notify"
+	"lido-near-client/internal/price"
+	"lido-near-client/internal/storage"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrStorageDisabled is returned by features that need the database when it is not configured.
+var ErrStorageDisabled = errors.New("storage is not configured")
+
 type (
 	Application struct {
 		StakePool StakePoolService
+		Metrics   *metrics.Metrics
+		History   *History
+		// Storage is nil if the database is not configured.
+		Storage *storage.Storage
+		Alerts  *notify.Dispatcher
+
+		log      *zap.Logger
+		alertCfg config.AlertConfig
+		rules    alertRules
+		// scoredEpoch is the network epoch the validators were last scored in
+		scoredEpoch uint64
+		// feesEpoch is the network epoch the validator fees were last queried in
+		feesEpoch uint64
+		jobs      coordinator
+
+		snapshotMu sync.Mutex
+		// snapshot is the encoded pool state of the last saved snapshot
+		snapshot []byte
 	}
 	Params struct {
 		Ctx context.Context
 		Log *zap.Logger
 		Cfg config.Config
+		// Executor overrides the executor of the stake pool jobs, e.g. for dry runs. The jobs send no alerts
+		// then, since their plans are not sent by the daemon.
+		Executor stakepool.Executor
+		// ReadOnly opens the database without migrations and leaves out the job journal, for commands which
+		// only read the pool. The database is left out if it can not be opened.
+		ReadOnly bool
 	}
 	StakePoolService interface {
 		PoolUpdate() error
 		IncreaseStake() error
+		TakeUnstakedBalance() error
+		RequestedDecreaseValidatorStake() error
+		UpdateValidator(accountID string) error
+		Status() (stakepool.Status, error)
+		GetEpochProgress() (stakepool.EpochProgress, error)
+		IsStakeDistributed() (bool, error)
+		GetEpochHeightRegistry() (stakepool.EpochHeightRegistry, error)
+		GetValidatorRegistry() ([]stakepool.Validator, error)
+		GetFund() (stakepool.Fund, error)
+		GetRequestedToWithdrawalFund() (stakepool.RequestedToWithdrawalFund, error)
+		GetAggInfo() (stakepool.AggInfo, error)
+		GetValidatorPerformance() ([]stakepool.ValidatorPerformance, error)
+		GetValidatorFees() ([]stakepool.ValidatorFee, error)
 	}
 )
 
 func New(params Params) (app *Application, err error) {
-	p, err := stakepool.New(stakepool.ServiceParam{
+	app = &Application{
+		Metrics:  metrics.New(),
+		History:  NewHistory(),
+		Alerts:   notify.NewDispatcher(params.Log, params.Cfg.Alert.RepeatInterval, newNotifiers(params.Cfg.Alert)...),
+		log:      params.Log,
+		alertCfg: params.Cfg.Alert,
+		rules:    alertRules{failures: map[string]int{}},
+	}
+	observers := []stakepool.Observer{app.Metrics, app.History}
+	switch {
+	case params.Cfg.DBDSN == "":
+	case params.ReadOnly:
+		app.Storage, err = storage.OpenReadOnly(params.Cfg.DBDriver, params.Cfg.DBDSN)
+		if err != nil {
+			params.Log.Warn("storage is not available", zap.Error(err))
+		}
+	default:
+		app.Storage, err = storage.Open(params.Cfg.DBDriver, params.Cfg.DBDSN)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage.Open")
+		}
+		observers = append(observers, transactionRecorder{storage: app.Storage, log: params.Log})
+	}
+	if params.ReadOnly {
+		params.Cfg.JournalDir = ""
+	}
+	var alerter stakepool.Alerter
+	if params.Executor == nil {
+		alerter = app.Alerts
+	}
+	var prices stakepool.PriceProvider
+	if params.Cfg.PriceURL != "" {
+		prices = price.NewCoinGecko(params.Cfg.PriceURL)
+	}
+	app.StakePool, err = stakepool.New(stakepool.ServiceParam{
 		Ctx: params.Ctx,
 		Cfg: params.Cfg,
 		Log: params.Log,
+
+		Executor:  params.Executor,
+		Observers: observers,
+		Prices:    prices,
+		Alerter:   alerter,
+	})
+	if err != nil {
+		app.Close()
+		return nil, errors.Wrap(err, "new pool")
+	}
+	return app, nil
+}
+
+func (app *Application) Close() {
+	app.Alerts.Close()
+	if app.Storage == nil {
+		return
+	}
+	err := app.Storage.Close()
+	if err != nil {
+		app.log.Error("close storage", zap.Error(err))
+	}
+}
+
+// Status returns the pool status with the operator gas spend of the network epoch when it is recorded.
+func (app *Application) Status() (stakepool.Status, error) {
+	status, err := app.StakePool.Status()
+	if err != nil {
+		return status, err
+	}
+	if status.Valuation == nil || app.Storage == nil {
+		return status, nil
+	}
+	txs, err := app.Storage.GetTransactions(status.Epochs.NetworkEpochHeight)
+	if err != nil {
+		app.log.Warn("Status: no operator gas spend", zap.Error(err))
+		return status, nil
+	}
+	spend := decimal.Zero
+	for _, tx := range txs {
+		spend = spend.Add(tx.TokensBurnt)
+	}
+	status.Valuation.OperatorGasSpend = decimal.NewNullDecimal(spend)
+	status.Valuation.OperatorGasSpendUSD = decimal.NewNullDecimal(stakepool.ToUSD(spend, status.Valuation.NearPriceUSD))
+	return status, nil
+}
+
+// ObserveStatus exports the pool status to metrics and saves the snapshot of the pool epoch.
+func (app *Application) ObserveStatus(status stakepool.Status) {
+	app.Metrics.ObserveStatus(status)
+	app.checkStatus(status)
+	if app.Storage == nil {
+		return
+	}
+	app.saveSnapshot(status)
+
+	// the exchange rate only settles once all validators are updated to the network epoch
+	if status.Epochs.PoolEpochHeight != status.Epochs.NetworkEpochHeight {
+		return
+	}
+	rate, ok := apy.ExchangeRate(status.AggInfo)
+	if !ok {
+		return
+	}
+	err := app.Storage.SaveExchangeRate(apy.Point{
+		PoolEpochHeight: status.Epochs.PoolEpochHeight,
+		Rate:            rate,
+		RewardFee:       status.AggInfo.RewardFee,
+		ObservedAt:      time.Now(),
 	})
 	if err != nil {
-		return app, errors.Wrap(err, "new pool")
+		app.log.Error("SaveExchangeRate", zap.Error(err))
+		return
+	}
+	report, err := app.APY()
+	if err != nil {
+		app.log.Error("APY", zap.Error(err))
+		return
+	}
+	app.Metrics.ObserveAPY(report)
+}
+
+// saveSnapshot saves the snapshot of the pool epoch unless the pool state is the same as in the last one.
+func (app *Application) saveSnapshot(status stakepool.Status) {
+	snapshot := storage.Snapshot{
+		Epochs:     status.Epochs,
+		Validators: status.Validators,
+		Fund:       status.Fund,
+		AggInfo:    status.AggInfo,
+	}
+	state, err := json.Marshal(snapshot)
+	if err != nil {
+		app.log.Error("json.Marshal(snapshot)", zap.Error(err))
+		return
+	}
+	app.snapshotMu.Lock()
+	defer app.snapshotMu.Unlock()
+	if bytes.Equal(state, app.snapshot) {
+		return
+	}
+	snapshot.CreatedAt = time.Now()
+	err = app.Storage.SaveSnapshot(snapshot)
+	if err != nil {
+		app.log.Error("SaveSnapshot", zap.Error(err))
+		return
+	}
+	app.snapshot = state
+}
+
+// ScoreValidators scores the validators in the last completed epoch once per network epoch.
+func (app *Application) ScoreValidators(networkEpochHeight uint64) {
+	if atomic.LoadUint64(&app.scoredEpoch) == networkEpochHeight {
+		return
+	}
+	performance, err := app.StakePool.GetValidatorPerformance()
+	if err != nil {
+		app.log.Error("GetValidatorPerformance", zap.Error(err))
+		return
+	}
+	app.Metrics.ObserveValidatorPerformance(performance)
+	if app.Storage != nil {
+		err = app.Storage.SaveValidatorPerformance(performance)
+		if err != nil {
+			app.log.Error("SaveValidatorPerformance", zap.Error(err))
+			return
+		}
+	}
+	atomic.StoreUint64(&app.scoredEpoch, networkEpochHeight)
+}
+
+// CheckValidatorFees records the validator reward fees once per network epoch and alerts on raised ones.
+func (app *Application) CheckValidatorFees(networkEpochHeight uint64) {
+	if atomic.LoadUint64(&app.feesEpoch) == networkEpochHeight {
+		return
+	}
+	fees, err := app.StakePool.GetValidatorFees()
+	if err != nil {
+		app.log.Error("GetValidatorFees", zap.Error(err))
+		return
+	}
+	app.Metrics.ObserveValidatorFees(fees)
+	if app.Storage != nil {
+		app.rules.mu.Lock()
+		known := app.rules.fees != nil
+		app.rules.mu.Unlock()
+		if !known {
+			// compare with the fees recorded before the restart
+			recorded, err := app.Storage.GetValidatorFees(0)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				app.log.Error("GetValidatorFees", zap.Error(err))
+				return
+			}
+			app.checkFees(recorded)
+		}
+		err = app.Storage.SaveValidatorFees(fees)
+		if err != nil {
+			app.log.Error("SaveValidatorFees", zap.Error(err))
+			return
+		}
+	}
+	app.checkFees(fees)
+	atomic.StoreUint64(&app.feesEpoch, networkEpochHeight)
+}
+
+// ValidatorFees returns the recorded fees of the epoch, the latest one if epochHeight is zero.
+func (app *Application) ValidatorFees(epochHeight uint64) ([]stakepool.ValidatorFee, error) {
+	if app.Storage == nil {
+		return nil, ErrStorageDisabled
+	}
+	return app.Storage.GetValidatorFees(epochHeight)
+}
+
+// ValidatorPerformance returns the recorded scores of the epoch, the latest one if epochHeight is zero.
+func (app *Application) ValidatorPerformance(epochHeight uint64) ([]stakepool.ValidatorPerformance, error) {
+	if app.Storage == nil {
+		return nil, ErrStorageDisabled
+	}
+	return app.Storage.GetValidatorPerformance(epochHeight)
+}
+
+// APY calculates the realized APY from the recorded exchange rates.
+func (app *Application) APY() (apy.Report, error) {
+	if app.Storage == nil {
+		return apy.Report{}, ErrStorageDisabled
 	}
-	return &Application{
-		StakePool: p,
-	}, nil
+	return apy.Calculate(app.Storage)
 }
@@ -0,0 +1,113 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/application/stakepool/simulator"
+	"lido-near-client/internal/config"
+	"lido-near-client/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDryRunSendsNoAlerts(t *testing.T) {
+	var alerts int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&alerts, 1)
+	}))
+	defer hook.Close()
+	sim := simulator.New(simulator.Params{StakePool: "pool.test.near", Operator: "operator.test.near"})
+	defer sim.Close()
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AdvanceEpoch(0.01)
+
+	keyPair, err := key.GenerateKeyPair(key.KeyTypeED25519, rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %s", err)
+	}
+	cfg := config.Config{
+		Node:             sim.URL(),
+		StakePool:        "pool.test.near",
+		KeyPair:          keyPair.PrivateEncoded(),
+		KeyPairAccountID: "operator.test.near",
+		Alert:            config.AlertConfig{WebhookURL: hook.URL},
+	}
+	for _, dryRun := range []bool{true, false} {
+		params := Params{Ctx: context.Background(), Log: zap.NewNop(), Cfg: cfg}
+		if dryRun {
+			params.Executor = stakepool.NewDryRunExecutor()
+		}
+		app, err := New(params)
+		if err != nil {
+			t.Fatalf("New: %s", err)
+		}
+		// the operator has no balance for gas
+		if err = app.StakePool.PoolUpdate(); err != nil {
+			t.Fatalf("PoolUpdate (dry run %t): %s", dryRun, err)
+		}
+		app.Close()
+		want := int32(0)
+		if !dryRun {
+			want = 1
+		}
+		if n := atomic.LoadInt32(&alerts); n != want {
+			t.Errorf("dry run %t: %d alerts sent, want %d", dryRun, n, want)
+		}
+	}
+}
+
+func TestLockJobs(t *testing.T) {
+	cfg := config.Config{JournalDir: t.TempDir(), StakePool: "pool.test.near"}
+	lock, err := LockJobs(cfg)
+	if err != nil {
+		t.Fatalf("LockJobs: %s", err)
+	}
+	if _, err = LockJobs(cfg); !errors.Is(err, ErrJobsLocked) {
+		t.Fatalf("LockJobs (held): %v, want ErrJobsLocked", err)
+	}
+	lock.Release()
+	lock, err = LockJobs(cfg)
+	if err != nil {
+		t.Fatalf("LockJobs (released): %s", err)
+	}
+	lock.Release()
+}
+
+func TestSnapshotSavedOnChange(t *testing.T) {
+	db, err := storage.Open(storage.DriverSQLite, filepath.Join(t.TempDir(), "lido.db"))
+	if err != nil {
+		t.Fatalf("storage.Open: %s", err)
+	}
+	defer db.Close()
+	app := newTestApp(nil)
+	app.Storage = db
+
+	status := stakepool.Status{Epochs: stakepool.EpochHeightRegistry{PoolEpochHeight: 3, NetworkEpochHeight: 4}}
+	created := func() time.Time {
+		snapshot, err := db.GetSnapshot(3)
+		if err != nil {
+			t.Fatalf("GetSnapshot: %s", err)
+		}
+		return snapshot.CreatedAt
+	}
+	app.saveSnapshot(status)
+	first := created()
+	app.saveSnapshot(status)
+	if !created().Equal(first) {
+		t.Error("unchanged snapshot saved again")
+	}
+	status.Fund.ClassicUnstakedBalance = decimal.New(1, 24)
+	app.saveSnapshot(status)
+	if created().Equal(first) {
+		t.Error("changed snapshot not saved")
+	}
+}
@@ -0,0 +1,85 @@
+package application
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"sync"
+)
+
+var (
+	// ErrJobBusy is returned for a job requested while another job is running.
+	ErrJobBusy = errors.New("another job is running")
+	// ErrPoolLagging is returned for a job which needs the pool updated to the network epoch first.
+	ErrPoolLagging = errors.New("pool epoch lags the network epoch")
+)
+
+// jobDependencies are the checks a job has to pass before it runs.
+var jobDependencies = map[string]func(app *Application) error{
+	// the stake is distributed among validators updated to the network epoch
+	stakepool.IncreaseStakeJob: (*Application).poolUpToDate,
+}
+
+// coordinator lets a single job run at a time, so concurrent jobs do not share nonces of the operator keys
+// or interleave their epoch logic.
+type coordinator struct {
+	mu      sync.Mutex
+	running string
+}
+
+// acquire marks the job running, it returns the running job if there is one.
+func (c *coordinator) acquire(job string) (running string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running != "" {
+		return c.running, false
+	}
+	c.running = job
+	return "", true
+}
+
+func (c *coordinator) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = ""
+}
+
+// RunJob runs the job and records it in the history and metrics. The run is skipped with ErrJobBusy if
+// another job is running and with the error of the failed check if a dependency of the job is not met.
+func (app *Application) RunJob(job string, run func() error) error {
+	running, ok := app.jobs.acquire(job)
+	if !ok {
+		return app.skipJob(job, errors.Wrap(ErrJobBusy, running))
+	}
+	defer app.jobs.release()
+	if ready := jobDependencies[job]; ready != nil {
+		if err := ready(app); err != nil {
+			return app.skipJob(job, err)
+		}
+	}
+
+	record := app.History.start(job)
+	err := app.Metrics.ObserveJob(job, run)
+	app.History.finish(record, err)
+	app.checkJob(job, err)
+	return err
+}
+
+func (app *Application) skipJob(job string, reason error) error {
+	app.log.Warn(job+": skipped", zap.Error(reason))
+	app.History.skip(job, reason.Error())
+	app.Metrics.ObserveJobSkip(job)
+	return reason
+}
+
+// poolUpToDate fails with ErrPoolLagging until the pool epoch reaches the network epoch.
+func (app *Application) poolUpToDate() error {
+	epochs, err := app.StakePool.GetEpochHeightRegistry()
+	if err != nil {
+		return errors.Wrap(err, "GetEpochHeightRegistry")
+	}
+	if epochs.PoolEpochHeight < epochs.NetworkEpochHeight {
+		return errors.Wrapf(ErrPoolLagging, "pool epoch %d, network epoch %d", epochs.PoolEpochHeight, epochs.NetworkEpochHeight)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package application
+
+import (
+	"github.com/pkg/errors"
+	"lido-near-client/internal/application/stakepool"
+	"testing"
+)
+
+func TestRunJob(t *testing.T) {
+	pool := &epochPool{height: 5100, poolEpoch: 4}
+	app := newTestApp(pool)
+
+	// the pool is an epoch behind
+	err := app.RunJob(stakepool.IncreaseStakeJob, pool.IncreaseStake)
+	if !errors.Is(err, ErrPoolLagging) {
+		t.Fatalf("IncreaseStake: %v, want %v", err, ErrPoolLagging)
+	}
+	// a job started while another one runs is skipped
+	var nested error
+	err = app.RunJob(stakepool.PoolUpdateJob, func() error {
+		nested = app.RunJob(stakepool.IncreaseStakeJob, pool.IncreaseStake)
+		return pool.PoolUpdate()
+	})
+	if err != nil {
+		t.Fatalf("PoolUpdate: %v", err)
+	}
+	if !errors.Is(nested, ErrJobBusy) {
+		t.Fatalf("IncreaseStake during PoolUpdate: %v, want %v", nested, ErrJobBusy)
+	}
+	err = app.RunJob(stakepool.IncreaseStakeJob, pool.IncreaseStake)
+	if err != nil {
+		t.Fatalf("IncreaseStake: %v", err)
+	}
+	if len(pool.jobs) != 2 || pool.jobs[1] != stakepool.IncreaseStakeJob {
+		t.Errorf("jobs %v, want PoolUpdate and IncreaseStake", pool.jobs)
+	}
+
+	want := []struct {
+		job    string
+		status JobStatus
+	}{
+		{stakepool.IncreaseStakeJob, JobSucceeded},
+		{stakepool.IncreaseStakeJob, JobSkipped},
+		{stakepool.PoolUpdateJob, JobSucceeded},
+		{stakepool.IncreaseStakeJob, JobSkipped},
+	}
+	runs := app.History.Runs(0)
+	if len(runs) != len(want) {
+		t.Fatalf("%d runs, want %d", len(runs), len(want))
+	}
+	for i, run := range runs {
+		if run.Job != want[i].job || run.Status != want[i].status || run.FinishedAt == nil {
+			t.Errorf("run %d: %s %s, want %s %s", i, run.Job, run.Status, want[i].job, want[i].status)
+		}
+		if (run.Status == JobSkipped) != (run.Reason != "") {
+			t.Errorf("run %d: %s with reason %q", i, run.Status, run.Reason)
+		}
+	}
+}
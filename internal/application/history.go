@@ -0,0 +1,122 @@
+package application
+
+import (
+	"lido-near-client/internal/application/stakepool"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	// JobSkipped is a run which did not start because another job was running or a dependency was not met.
+	JobSkipped JobStatus = "skipped"
+
+	historySize = 100
+)
+
+type (
+	JobStatus string
+	// JobRun is a record of a single job run.
+	JobRun struct {
+		Job        string     `json:"job"`
+		Status     JobStatus  `json:"status"`
+		StartedAt  time.Time  `json:"started_at"`
+		FinishedAt *time.Time `json:"finished_at,omitempty"`
+		Error      string     `json:"error,omitempty"`
+		// Reason explains why the run was skipped.
+		Reason       string        `json:"reason,omitempty"`
+		Transactions []Transaction `json:"transactions"`
+	}
+	Transaction struct {
+		Method    string `json:"method"`
+		Validator string `json:"validator,omitempty"`
+		Hash      string `json:"hash"`
+		Error     string `json:"error,omitempty"`
+	}
+	// History keeps recent job runs in memory and attributes sent transactions to them.
+	History struct {
+		mu      sync.Mutex
+		runs    []*JobRun
+		running map[string]*JobRun
+	}
+)
+
+func NewHistory() *History {
+	return &History{running: map[string]*JobRun{}}
+}
+
+func (h *History) start(job string) *JobRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	run := &JobRun{Job: job, Status: JobRunning, StartedAt: time.Now().UTC(), Transactions: []Transaction{}}
+	h.add(run)
+	h.running[job] = run
+	return run
+}
+
+// skip records a run which did not start.
+func (h *History) skip(job, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := time.Now().UTC()
+	h.add(&JobRun{Job: job, Status: JobSkipped, StartedAt: t, FinishedAt: &t, Reason: reason, Transactions: []Transaction{}})
+}
+
+// add appends the run and drops the oldest runs, the caller holds the lock.
+func (h *History) add(run *JobRun) {
+	h.runs = append(h.runs, run)
+	if len(h.runs) > historySize {
+		h.runs = h.runs[len(h.runs)-historySize:]
+	}
+}
+
+func (h *History) finish(run *JobRun, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := time.Now().UTC()
+	run.FinishedAt = &t
+	run.Status = JobSucceeded
+	if err != nil {
+		run.Status, run.Error = JobFailed, err.Error()
+	}
+	if h.running[run.Job] == run {
+		delete(h.running, run.Job)
+	}
+}
+
+// ObserveTransaction implements stakepool.Observer.
+func (h *History) ObserveTransaction(result stakepool.StepResult, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	run, ok := h.running[result.Job]
+	if !ok {
+		return
+	}
+	tx := Transaction{
+		Method:    result.Step.Method,
+		Validator: result.Step.Validator,
+		Hash:      result.TxHash,
+	}
+	if err != nil {
+		tx.Error = err.Error()
+	}
+	run.Transactions = append(run.Transactions, tx)
+}
+
+// Runs returns up to limit most recent runs, newest first.
+func (h *History) Runs(limit int) []JobRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if limit <= 0 || limit > len(h.runs) {
+		limit = len(h.runs)
+	}
+	runs := make([]JobRun, 0, limit)
+	for i := len(h.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		run := *h.runs[i]
+		run.Transactions = append(make([]Transaction, 0, len(run.Transactions)), run.Transactions...)
+		runs = append(runs, run)
+	}
+	return runs
+}
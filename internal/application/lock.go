@@ -0,0 +1,45 @@
+package application
+
+import (
+	"github.com/pkg/errors"
+	"lido-near-client/internal/config"
+	"os"
+	"path/filepath"
+)
+
+// ErrJobsLocked is returned when another process on the host runs the jobs of the pool.
+var ErrJobsLocked = errors.New("jobs are run by another process")
+
+// JobsLock keeps processes of the same pool from running jobs at once, like the coordinator does within
+// a process: the daemon holds it while it runs and a one-shot command while its job runs.
+type JobsLock struct {
+	file *os.File
+}
+
+// LockJobs takes the lock file of the pool in the journal directory, in the temp directory without one.
+func LockJobs(cfg config.Config) (*JobsLock, error) {
+	dir := cfg.JournalDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	path := filepath.Join(dir, "lido-"+cfg.StakePool+".lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile")
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, path)
+	}
+	return &JobsLock{file: f}, nil
+}
+
+// Release unlocks the file, the lock is also released when the process exits.
+func (l *JobsLock) Release() {
+	l.file.Close()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package application
+
+import "os"
+
+// lockFile does not lock on Windows, the daemon has to be stopped before running a one-shot command.
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+package application
+
+import (
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/storage"
+	"time"
+)
+
+// transactionRecorder saves every sent transaction to the storage.
+type transactionRecorder struct {
+	storage *storage.Storage
+	log     *zap.Logger
+}
+
+func (r transactionRecorder) ObserveTransaction(result stakepool.StepResult, err error) {
+	tx := storage.Transaction{
+		NetworkEpochHeight: result.NetworkEpochHeight,
+		Job:                result.Job,
+		Method:             result.Step.Method,
+		Validator:          result.Step.Validator,
+		Amount:             result.Step.Amount,
+		TxHash:             result.TxHash,
+		GasBurnt:           result.GasBurnt,
+		TokensBurnt:        result.TokensBurnt,
+		CreatedAt:          time.Now(),
+	}
+	if err != nil {
+		tx.Error = err.Error()
+	}
+	saveErr := r.storage.SaveTransaction(tx)
+	if saveErr != nil {
+		r.log.Error("SaveTransaction", zap.String("tx", result.TxHash), zap.Error(saveErr))
+	}
+}
@@ -0,0 +1,191 @@
+package application
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/config"
+	"time"
+)
+
+const (
+	defaultBlockTime = 1200 * time.Millisecond
+	// minSchedulerDelay keeps the scheduler from spinning on rounding errors near the epoch points
+	minSchedulerDelay = time.Second
+	// blockTimeSample is how many blocks the block time is measured over
+	blockTimeSample = 100
+)
+
+// Scheduler runs PoolUpdate right after an epoch boundary and IncreaseStake once the distribution window
+// opens, then every WindowInterval until the stake is distributed. It sleeps until the next estimated point,
+// at most MaxSleep. Jobs missed while the service was down run at once.
+type Scheduler struct {
+	app *Application
+	log *zap.Logger
+	cfg config.ScheduleConfig
+	now func() time.Time
+
+	// updatedEpoch and increasedEpoch are the first blocks of the epochs the jobs last finished in
+	updatedEpoch   uint64
+	increasedEpoch uint64
+	failures       int
+	blockTime      time.Duration
+	// sampleHeight and sampleAt start the block time measurement
+	sampleHeight uint64
+	sampleAt     time.Time
+}
+
+func NewScheduler(app *Application, log *zap.Logger, cfg config.ScheduleConfig) *Scheduler {
+	s := &Scheduler{app: app, log: log, cfg: cfg, now: time.Now, blockTime: cfg.BlockTime}
+	if s.blockTime <= 0 {
+		s.blockTime = defaultBlockTime
+	}
+	return s
+}
+
+// Run schedules the jobs and monitors the pool until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	go s.monitor(ctx)
+	for {
+		delay := s.tick()
+		s.log.Debug("scheduler: sleep", zap.Duration("delay", delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick runs the jobs due at the current epoch position and returns the delay until the next check.
+func (s *Scheduler) tick() time.Duration {
+	progress, err := s.app.StakePool.GetEpochProgress()
+	if err != nil {
+		s.log.Error("scheduler: GetEpochProgress", zap.Error(err))
+		return s.retryDelay()
+	}
+	s.measureBlockTime(progress.BlockHeight)
+	epoch := progress.EpochStartHeight
+
+	if s.updatedEpoch != epoch {
+		err = s.app.RunJob(stakepool.PoolUpdateJob, s.app.StakePool.PoolUpdate)
+		if err != nil {
+			s.log.Error("PoolUpdate", zap.Error(err))
+			return s.retryDelay()
+		}
+		// the contract may not see the new epoch yet, the update is done once the pool reaches it
+		epochs, err := s.app.StakePool.GetEpochHeightRegistry()
+		if err != nil {
+			s.log.Error("scheduler: GetEpochHeightRegistry", zap.Error(err))
+			return s.retryDelay()
+		}
+		if epochs.PoolEpochHeight < epochs.NetworkEpochHeight {
+			s.log.Warn("scheduler: pool is not updated yet", zap.Uint64("pool_epoch", epochs.PoolEpochHeight),
+				zap.Uint64("network_epoch", epochs.NetworkEpochHeight))
+			return s.retryDelay()
+		}
+		s.updatedEpoch, s.failures = epoch, 0
+	}
+	inWindow := progress.InDistributionWindow()
+	if s.increasedEpoch != epoch && inWindow {
+		err = s.app.RunJob(stakepool.IncreaseStakeJob, s.app.StakePool.IncreaseStake)
+		if errors.Is(err, ErrPoolLagging) {
+			// the pool update of the epoch did not finish, it runs again first
+			s.updatedEpoch = 0
+		}
+		if err != nil {
+			s.log.Error("IncreaseStake", zap.Error(err))
+			return s.retryDelay()
+		}
+		distributed, err := s.app.StakePool.IsStakeDistributed()
+		if err != nil {
+			s.log.Error("scheduler: IsStakeDistributed", zap.Error(err))
+			return s.retryDelay()
+		}
+		s.failures = 0
+		if !distributed {
+			// nothing to distribute yet, deposits made later in the window are distributed by the next run
+			return s.capped(minDuration(s.cfg.WindowInterval, s.untilNextEpoch(progress)))
+		}
+		s.increasedEpoch = epoch
+	}
+
+	if !inWindow {
+		return s.capped(s.blocks(progress.EpochLength - progress.DistributionWindow - progress.Position))
+	}
+	return s.capped(s.untilNextEpoch(progress))
+}
+
+// untilNextEpoch is the delay until PoolUpdate is due in the next epoch.
+func (s *Scheduler) untilNextEpoch(progress stakepool.EpochProgress) time.Duration {
+	return s.blocks(progress.EpochLength-progress.Position) + s.cfg.BoundaryDelay
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a > 0 && a < b {
+		return a
+	}
+	return b
+}
+
+func (s *Scheduler) blocks(n uint64) time.Duration {
+	return time.Duration(n) * s.blockTime
+}
+
+// measureBlockTime estimates the block time from the blocks produced since the sample started.
+func (s *Scheduler) measureBlockTime(height uint64) {
+	now := s.now()
+	switch {
+	case s.sampleHeight == 0 || height < s.sampleHeight:
+		s.sampleHeight, s.sampleAt = height, now
+	case height-s.sampleHeight >= blockTimeSample:
+		s.blockTime = now.Sub(s.sampleAt) / time.Duration(height-s.sampleHeight)
+		s.sampleHeight, s.sampleAt = height, now
+	}
+}
+
+// retryDelay doubles the delay with every failure in a row.
+func (s *Scheduler) retryDelay() time.Duration {
+	delay := s.cfg.RetryDelay
+	for i := 0; i < s.failures && (s.cfg.MaxSleep <= 0 || delay < s.cfg.MaxSleep); i++ {
+		delay *= 2
+	}
+	s.failures++
+	return s.capped(delay)
+}
+
+func (s *Scheduler) capped(delay time.Duration) time.Duration {
+	if s.cfg.MaxSleep > 0 && delay > s.cfg.MaxSleep {
+		delay = s.cfg.MaxSleep
+	}
+	if delay < minSchedulerDelay {
+		delay = minSchedulerDelay
+	}
+	return delay
+}
+
+// monitor exports the status to metrics and checks alerts, validator performance and fees every interval.
+func (s *Scheduler) monitor(ctx context.Context) {
+	interval := s.cfg.MonitorInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := s.app.Status()
+		if err != nil {
+			s.log.Error("Status", zap.Error(err))
+		} else {
+			s.app.ObserveStatus(status)
+			s.app.ScoreValidators(status.Epochs.NetworkEpochHeight)
+			s.app.CheckValidatorFees(status.Epochs.NetworkEpochHeight)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
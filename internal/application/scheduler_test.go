@@ -0,0 +1,141 @@
+package application
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/config"
+	"lido-near-client/internal/metrics"
+	"lido-near-client/internal/notify"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEpochLength = 1000
+	// testEpochOffset makes the epochs start off the multiples of the epoch length, as they do on chain
+	testEpochOffset = 37
+)
+
+// epochPool reports a scripted epoch position and records the jobs.
+type epochPool struct {
+	StakePoolService
+	height      uint64
+	poolEpoch   uint64
+	distributed uint64
+	fail        bool
+	// stale makes PoolUpdate skip as if the contract did not see the new epoch yet
+	stale bool
+	// empty makes IncreaseStake skip as if there was nothing to distribute
+	empty bool
+	jobs  []string
+}
+
+func (p *epochPool) epoch() uint64 {
+	return (p.height - testEpochOffset) / testEpochLength
+}
+
+func (p *epochPool) GetEpochProgress() (stakepool.EpochProgress, error) {
+	start := testEpochOffset + p.epoch()*testEpochLength
+	return stakepool.EpochProgress{
+		BlockHeight:        p.height,
+		EpochStartHeight:   start,
+		EpochLength:        testEpochLength,
+		Position:           p.height - start,
+		DistributionWindow: 150,
+	}, nil
+}
+
+func (p *epochPool) GetEpochHeightRegistry() (stakepool.EpochHeightRegistry, error) {
+	return stakepool.EpochHeightRegistry{PoolEpochHeight: p.poolEpoch, NetworkEpochHeight: p.epoch()}, nil
+}
+
+func (p *epochPool) IsStakeDistributed() (bool, error) {
+	return p.distributed == p.epoch(), nil
+}
+
+func (p *epochPool) PoolUpdate() error {
+	p.jobs = append(p.jobs, stakepool.PoolUpdateJob)
+	if p.fail {
+		return errors.New("node unavailable")
+	}
+	if !p.stale {
+		p.poolEpoch = p.epoch()
+	}
+	return nil
+}
+
+func (p *epochPool) IncreaseStake() error {
+	p.jobs = append(p.jobs, stakepool.IncreaseStakeJob)
+	if !p.empty {
+		p.distributed = p.epoch()
+	}
+	return nil
+}
+
+func newTestApp(pool StakePoolService) *Application {
+	return &Application{
+		StakePool: pool,
+		Metrics:   metrics.New(),
+		History:   NewHistory(),
+		Alerts:    notify.NewDispatcher(zap.NewNop(), time.Hour),
+		log:       zap.NewNop(),
+		rules:     alertRules{failures: map[string]int{}},
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	pool := &epochPool{height: 5137}
+	app := newTestApp(pool)
+	s := NewScheduler(app, zap.NewNop(), config.ScheduleConfig{
+		BlockTime:      time.Second,
+		BoundaryDelay:  30 * time.Second,
+		MaxSleep:       10 * time.Minute,
+		RetryDelay:     30 * time.Second,
+		WindowInterval: time.Minute,
+	})
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	tick := func(height uint64, wantDelay time.Duration, wantJobs ...string) {
+		t.Helper()
+		now = now.Add(time.Duration(height-pool.height) * time.Second)
+		pool.height, pool.jobs = height, nil
+		if delay := s.tick(); delay != wantDelay {
+			t.Errorf("block %d: delay %s, want %s", height, delay, wantDelay)
+		}
+		if got, want := strings.Join(pool.jobs, ","), strings.Join(wantJobs, ","); got != want {
+			t.Errorf("block %d: jobs %s, want %s", height, got, want)
+		}
+	}
+
+	// 750 blocks until the window, capped
+	tick(5137, 10*time.Minute, stakepool.PoolUpdateJob)
+	tick(5737, 150*time.Second)
+	// 150 blocks and the boundary delay until the next epoch
+	tick(5887, 180*time.Second, stakepool.IncreaseStakeJob)
+	tick(5937, 130*time.Second)
+
+	// the update is repeated until the pool reaches the network epoch
+	pool.stale = true
+	tick(6047, 30*time.Second, stakepool.PoolUpdateJob)
+	pool.stale = false
+	tick(6077, 10*time.Minute, stakepool.PoolUpdateJob)
+
+	// a deposit made later in the window is distributed by the next run
+	pool.empty = true
+	tick(6887, time.Minute, stakepool.IncreaseStakeJob)
+	pool.empty = false
+	tick(6947, 120*time.Second, stakepool.IncreaseStakeJob)
+	tick(6967, 100*time.Second)
+
+	// failures are retried with a growing delay
+	pool.fail = true
+	tick(7039, 30*time.Second, stakepool.PoolUpdateJob)
+	tick(7069, time.Minute, stakepool.PoolUpdateJob)
+	pool.fail = false
+	tick(7137, 10*time.Minute, stakepool.PoolUpdateJob)
+
+	// the jobs missed during a downtime run at once
+	tick(9977, 90*time.Second, stakepool.PoolUpdateJob, stakepool.IncreaseStakeJob)
+}
@@ -0,0 +1,302 @@
+package stakepool
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/config"
+	"sort"
+)
+
+const (
+	FillLowestStrategy    = "fill-lowest"
+	EqualStrategy         = "equal"
+	TargetWeightsStrategy = "target-weights"
+)
+
+var (
+	// ErrBelowMinAllocation is returned when the stake is too small to allocate.
+	ErrBelowMinAllocation = errors.New("stake is below the min allocation")
+	// ErrNoValidatorWithinLimits is returned when no validator can take any stake within the allocation limits.
+	ErrNoValidatorWithinLimits = errors.New("no validator can take the stake within the allocation limits")
+)
+
+type (
+	// AllocationStrategy splits classic unstaked balance among validators in IncreaseStake.
+	AllocationStrategy interface {
+		Name() string
+		// NeedsRewardFees reports whether Allocate reads the reward fees of the request.
+		NeedsRewardFees() bool
+		// Allocate returns non-zero allocations summing to the request stake, or less if the max share of the
+		// validators can not take all of it.
+		Allocate(req AllocationRequest) ([]Allocation, error)
+	}
+	AllocationRequest struct {
+		Stake decimal.Decimal
+		// TotalClassicStake is the classic staked balance of the pool before the allocation.
+		TotalClassicStake decimal.Decimal
+		// Validators are the ones which can take classic stake in the epoch.
+		Validators []Validator
+		// RewardFees are validator reward fee fractions, required by the fee limits only.
+		RewardFees map[string]decimal.Decimal
+	}
+	Allocation struct {
+		Validator Validator
+		Stake     decimal.Decimal
+	}
+	// AllocationLimits apply to every built-in strategy.
+	AllocationLimits struct {
+		// MaxShare caps a validator share of the total classic stake after the allocation, no cap if zero.
+		MaxShare decimal.Decimal
+		// MinAllocation is the smallest stake increase, never below MinRebalanceStake.
+		MinAllocation decimal.Decimal
+		// StakeFloor excludes validators with a lower classic staked balance.
+		StakeFloor decimal.Decimal
+		// MaxRewardFee excludes validators with a higher or unknown reward fee, disabled if zero.
+		MaxRewardFee decimal.Decimal
+		// RewardFeePenalty multiplies validator weights by one minus the reward fee.
+		RewardFeePenalty bool
+	}
+	// fillStrategy raises validator balances relative to their weights, the lowest ones first.
+	fillStrategy struct {
+		name string
+		// weights are equal if nil, validators without a weight are excluded otherwise
+		weights map[string]decimal.Decimal
+		// ignoreBalances splits the stake by weights regardless of current balances
+		ignoreBalances bool
+		limits         AllocationLimits
+	}
+	candidate struct {
+		validator Validator
+		weight    decimal.Decimal
+		// base is the balance the fill starts from
+		base decimal.Decimal
+		// limit is the max allocation, negative if unlimited
+		limit decimal.Decimal
+	}
+)
+
+// NewAllocationStrategy builds the strategy selected in the config.
+func NewAllocationStrategy(cfg config.AllocationConfig) (AllocationStrategy, error) {
+	limits := AllocationLimits{
+		MaxShare:         decimal.NewFromFloat(cfg.MaxShare),
+		MinAllocation:    decimal.NewFromFloat(cfg.MinStake).Shift(24),
+		StakeFloor:       decimal.NewFromFloat(cfg.StakeFloor).Shift(24),
+		MaxRewardFee:     decimal.NewFromFloat(cfg.MaxRewardFee),
+		RewardFeePenalty: cfg.RewardFeePenalty,
+	}
+	if limits.MaxShare.IsNegative() || limits.MaxShare.GreaterThan(decimal.New(1, 0)) {
+		return nil, errors.Errorf("max share %s is out of [0, 1]", limits.MaxShare)
+	}
+	if limits.MaxRewardFee.IsNegative() || limits.MaxRewardFee.GreaterThan(decimal.New(1, 0)) {
+		return nil, errors.Errorf("max reward fee %s is out of [0, 1]", limits.MaxRewardFee)
+	}
+	switch cfg.Strategy {
+	case FillLowestStrategy, "":
+		return NewFillLowestStrategy(limits), nil
+	case EqualStrategy:
+		return NewEqualStrategy(limits), nil
+	case TargetWeightsStrategy:
+		if len(cfg.Weights) == 0 {
+			return nil, errors.New("target weights are not set")
+		}
+		weights := make(map[string]decimal.Decimal, len(cfg.Weights))
+		for id, w := range cfg.Weights {
+			if w < 0 {
+				return nil, errors.Errorf("negative weight of %s", id)
+			}
+			weights[id] = decimal.NewFromFloat(w)
+		}
+		return NewTargetWeightsStrategy(weights, limits), nil
+	}
+	return nil, errors.Errorf("unknown allocation strategy %s", cfg.Strategy)
+}
+
+// NewFillLowestStrategy fills the lowest classic staked validators first, so their balances converge to equal.
+func NewFillLowestStrategy(limits AllocationLimits) AllocationStrategy {
+	return &fillStrategy{name: FillLowestStrategy, limits: limits}
+}
+
+// NewEqualStrategy splits the stake equally regardless of the balances.
+func NewEqualStrategy(limits AllocationLimits) AllocationStrategy {
+	return &fillStrategy{name: EqualStrategy, ignoreBalances: true, limits: limits}
+}
+
+// NewTargetWeightsStrategy moves validator balances towards the shares of the total given by the weights.
+func NewTargetWeightsStrategy(weights map[string]decimal.Decimal, limits AllocationLimits) AllocationStrategy {
+	return &fillStrategy{name: TargetWeightsStrategy, weights: weights, limits: limits}
+}
+
+func (s *fillStrategy) Name() string {
+	return s.name
+}
+
+func (s *fillStrategy) NeedsRewardFees() bool {
+	return s.limits.needsRewardFees()
+}
+
+// needsRewardFees reports whether a fee limit is set.
+func (l AllocationLimits) needsRewardFees() bool {
+	return l.MaxRewardFee.IsPositive() || l.RewardFeePenalty
+}
+
+// Allocate fills validators up to a common level of balance per weight. A validator reaching its max share is
+// fixed at the cap and the rest goes to the others, the stake above the cap of every validator is left. A
+// validator which would get less than the min allocation is left out and the allocation is repeated without it.
+func (s *fillStrategy) Allocate(req AllocationRequest) ([]Allocation, error) {
+	minAllocation := decimal.Max(s.limits.MinAllocation, MinRebalanceStake)
+	if req.Stake.LessThan(minAllocation) {
+		return nil, errors.Wrapf(ErrBelowMinAllocation, "%s NEAR < %s NEAR", toNear(req.Stake).StringFixed(4), toNear(minAllocation).StringFixed(4))
+	}
+	var candidates []candidate
+	for _, v := range req.Validators {
+		if v.ClassicStakedBalance.LessThan(s.limits.StakeFloor) {
+			continue
+		}
+		c := candidate{validator: v, weight: decimal.New(1, 0), base: v.ClassicStakedBalance, limit: decimal.New(-1, 0)}
+		if s.weights != nil {
+			c.weight = s.weights[v.AccountID]
+			if !c.weight.IsPositive() {
+				continue
+			}
+		}
+		if s.limits.needsRewardFees() {
+			fee, ok := req.RewardFees[v.AccountID]
+			if !ok && s.limits.RewardFeePenalty {
+				return nil, errors.Errorf("reward fee of %s is unknown", v.AccountID)
+			}
+			if s.limits.MaxRewardFee.IsPositive() && (!ok || fee.GreaterThan(s.limits.MaxRewardFee)) {
+				continue
+			}
+			if s.limits.RewardFeePenalty {
+				c.weight = c.weight.Mul(decimal.New(1, 0).Sub(fee))
+				if !c.weight.IsPositive() {
+					continue
+				}
+			}
+		}
+		if s.ignoreBalances {
+			c.base = decimal.Zero
+		}
+		if s.limits.MaxShare.IsPositive() {
+			total := req.TotalClassicStake.Add(req.Stake)
+			c.limit = decimal.Max(total.Mul(s.limits.MaxShare).Truncate(0).Sub(v.ClassicStakedBalance), decimal.Zero)
+		}
+		candidates = append(candidates, c)
+	}
+	// sorting (asc) by balance per weight
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].base.Div(candidates[i].weight).LessThan(candidates[j].base.Div(candidates[j].weight))
+	})
+
+	for {
+		if len(candidates) == 0 {
+			return nil, ErrNoValidatorWithinLimits
+		}
+		shares := fillCapped(req.Stake, candidates)
+		smallest := -1
+		for i, share := range shares {
+			if share.LessThan(minAllocation) && (smallest == -1 || share.LessThanOrEqual(shares[smallest])) {
+				smallest = i
+			}
+		}
+		if smallest == -1 {
+			var allocations []Allocation
+			for i, share := range shares {
+				if share.IsPositive() {
+					allocations = append(allocations, Allocation{Validator: candidates[i].validator, Stake: share})
+				}
+			}
+			return allocations, nil
+		}
+		if shares[smallest].IsZero() {
+			// leave out all validators above the level at once
+			var rest []candidate
+			for i, c := range candidates {
+				if shares[i].IsPositive() {
+					rest = append(rest, c)
+				}
+			}
+			candidates = rest
+			continue
+		}
+		candidates = append(candidates[:smallest:smallest], candidates[smallest+1:]...)
+	}
+}
+
+// fillCapped returns integer shares of the sorted candidates summing to stake, or less if every candidate
+// reaches its limit.
+func fillCapped(stake decimal.Decimal, candidates []candidate) []decimal.Decimal {
+	shares := make([]decimal.Decimal, len(candidates))
+	capped := make([]bool, len(candidates))
+	remains := stake
+	for {
+		var free []int
+		for i := range candidates {
+			if !capped[i] {
+				free = append(free, i)
+			}
+		}
+		if len(free) == 0 {
+			break
+		}
+		level := fillLevel(remains, candidates, free)
+		overflow := false
+		for _, i := range free {
+			c := candidates[i]
+			shares[i] = decimal.Max(level.Mul(c.weight).Sub(c.base), decimal.Zero).Truncate(0)
+			if !c.limit.IsNegative() && shares[i].GreaterThan(c.limit) {
+				shares[i] = c.limit
+				capped[i] = true
+				remains = remains.Sub(c.limit)
+				overflow = true
+			}
+		}
+		if !overflow {
+			break
+		}
+	}
+
+	// the rest of the integer division goes to the lowest receivers with room for it
+	distributed := decimal.Zero
+	for _, share := range shares {
+		distributed = distributed.Add(share)
+	}
+	rest := stake.Sub(distributed)
+	for i := range shares {
+		if rest.IsZero() {
+			break
+		}
+		if capped[i] || !shares[i].IsPositive() {
+			continue
+		}
+		add := rest
+		if limit := candidates[i].limit; !limit.IsNegative() {
+			add = decimal.Min(add, limit.Sub(shares[i]))
+		}
+		shares[i] = shares[i].Add(add)
+		rest = rest.Sub(add)
+	}
+	return shares
+}
+
+// fillLevel returns the balance per weight the free candidates are filled to with the stake.
+func fillLevel(stake decimal.Decimal, candidates []candidate, free []int) decimal.Decimal {
+	var (
+		bases   = decimal.Zero
+		weights = decimal.Zero
+		level   decimal.Decimal
+	)
+	for k, i := range free {
+		bases = bases.Add(candidates[i].base)
+		weights = weights.Add(candidates[i].weight)
+		level = stake.Add(bases).Div(weights)
+		if k == len(free)-1 {
+			break
+		}
+		next := candidates[free[k+1]]
+		if level.LessThanOrEqual(next.base.Div(next.weight)) {
+			break
+		}
+	}
+	return level
+}
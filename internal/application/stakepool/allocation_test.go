@@ -0,0 +1,206 @@
+package stakepool
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+func TestAllocationStrategies(t *testing.T) {
+	near := func(amount float64) decimal.Decimal {
+		return decimal.NewFromFloat(amount).Mul(decimal.New(1, 24)).Truncate(0)
+	}
+	validators := func(balances ...float64) (vs []Validator) {
+		for i, b := range balances {
+			vs = append(vs, Validator{AccountID: string(rune('a' + i)), ClassicStakedBalance: near(b)})
+		}
+		return vs
+	}
+	fillLowest := NewFillLowestStrategy(AllocationLimits{})
+	tests := []struct {
+		name       string
+		strategy   AllocationStrategy
+		stake      decimal.Decimal
+		validators []Validator
+		fees       map[string]decimal.Decimal
+		want       map[string]decimal.Decimal
+		wantErr    error
+	}{
+		{
+			name:       "equal balances split equally",
+			strategy:   fillLowest,
+			stake:      near(9),
+			validators: validators(10, 10, 10),
+			want:       map[string]decimal.Decimal{"a": near(3), "b": near(3), "c": near(3)},
+		},
+		{
+			name:       "lowest filled first",
+			strategy:   fillLowest,
+			stake:      near(15),
+			validators: validators(100, 80, 90),
+			want:       map[string]decimal.Decimal{"b": near(12.5), "c": near(2.5)},
+		},
+		{
+			name:       "stake above the max raises everyone",
+			strategy:   fillLowest,
+			stake:      near(60),
+			validators: validators(100, 80, 90),
+			want:       map[string]decimal.Decimal{"a": near(10), "b": near(30), "c": near(20)},
+		},
+		{
+			name:       "shares below the minimum are left out",
+			strategy:   fillLowest,
+			stake:      near(2.5),
+			validators: validators(10, 10, 10, 10, 10),
+			want:       map[string]decimal.Decimal{"a": near(1.25), "b": near(1.25)},
+		},
+		{
+			name:       "dust share goes to the lower validators",
+			strategy:   fillLowest,
+			stake:      near(20.5),
+			validators: validators(0, 20, 20.1),
+			want:       map[string]decimal.Decimal{"a": near(20.5)},
+		},
+		{
+			name:       "indivisible remainder",
+			strategy:   fillLowest,
+			stake:      near(10),
+			validators: validators(5, 5, 5),
+			want: map[string]decimal.Decimal{
+				"a": decimal.RequireFromString("3333333333333333333333334"),
+				"b": decimal.RequireFromString("3333333333333333333333333"),
+				"c": decimal.RequireFromString("3333333333333333333333333"),
+			},
+		},
+		{
+			name:       "below the minimum",
+			strategy:   fillLowest,
+			stake:      near(0.5),
+			validators: validators(10, 20),
+			wantErr:    ErrBelowMinAllocation,
+		},
+		{
+			name:       "equal ignores balances",
+			strategy:   NewEqualStrategy(AllocationLimits{}),
+			stake:      near(30),
+			validators: validators(100, 0, 50),
+			want:       map[string]decimal.Decimal{"a": near(10), "b": near(10), "c": near(10)},
+		},
+		{
+			name: "target weights",
+			strategy: NewTargetWeightsStrategy(map[string]decimal.Decimal{
+				"a": decimal.NewFromInt(2), "b": decimal.NewFromInt(1),
+			}, AllocationLimits{}),
+			stake:      near(60),
+			validators: validators(50, 40, 0),
+			want:       map[string]decimal.Decimal{"a": near(50), "b": near(10)},
+		},
+		{
+			name: "max share caps concentration",
+			strategy: NewTargetWeightsStrategy(map[string]decimal.Decimal{
+				"a": decimal.NewFromInt(3), "b": decimal.NewFromInt(1), "c": decimal.NewFromInt(1),
+			}, AllocationLimits{MaxShare: decimal.NewFromFloat(0.4)}),
+			stake:      near(100),
+			validators: validators(0, 0, 0),
+			want:       map[string]decimal.Decimal{"a": near(40), "b": near(30), "c": near(30)},
+		},
+		{
+			name:       "max share leaves stake undistributed",
+			strategy:   NewFillLowestStrategy(AllocationLimits{MaxShare: decimal.NewFromFloat(0.3)}),
+			stake:      near(100),
+			validators: validators(0, 0),
+			want:       map[string]decimal.Decimal{"a": near(30), "b": near(30)},
+		},
+		{
+			name:       "max share reached by every validator",
+			strategy:   NewFillLowestStrategy(AllocationLimits{MaxShare: decimal.NewFromFloat(0.3)}),
+			stake:      near(10),
+			validators: validators(30, 30),
+			wantErr:    ErrNoValidatorWithinLimits,
+		},
+		{
+			name:       "min allocation",
+			strategy:   NewFillLowestStrategy(AllocationLimits{MinAllocation: near(10)}),
+			stake:      near(30),
+			validators: validators(0, 5, 15),
+			want:       map[string]decimal.Decimal{"a": near(17.5), "b": near(12.5)},
+		},
+		{
+			name:       "stake floor excludes small validators",
+			strategy:   NewFillLowestStrategy(AllocationLimits{StakeFloor: near(10)}),
+			stake:      near(10),
+			validators: validators(0, 20, 30),
+			want:       map[string]decimal.Decimal{"b": near(10)},
+		},
+		{
+			name:       "max reward fee excludes expensive validators",
+			strategy:   NewFillLowestStrategy(AllocationLimits{MaxRewardFee: decimal.NewFromFloat(0.1)}),
+			stake:      near(10),
+			validators: validators(0, 0, 0, 0),
+			fees: map[string]decimal.Decimal{
+				"a": decimal.NewFromFloat(0.05), "b": decimal.NewFromFloat(0.2), "c": decimal.NewFromFloat(0.1),
+			},
+			want: map[string]decimal.Decimal{"a": near(5), "c": near(5)},
+		},
+		{
+			name:       "reward fee penalty",
+			strategy:   NewEqualStrategy(AllocationLimits{RewardFeePenalty: true}),
+			stake:      near(30),
+			validators: validators(100, 0),
+			fees:       map[string]decimal.Decimal{"a": decimal.Zero, "b": decimal.NewFromFloat(0.5)},
+			want:       map[string]decimal.Decimal{"a": near(20), "b": near(10)},
+		},
+		{
+			name:       "reward fee penalty needs the fees",
+			strategy:   NewFillLowestStrategy(AllocationLimits{RewardFeePenalty: true}),
+			stake:      near(30),
+			validators: validators(0, 0),
+			fees:       map[string]decimal.Decimal{"a": decimal.Zero},
+			wantErr:    errors.New("reward fee of b is unknown"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total := decimal.Zero
+			for _, v := range tt.validators {
+				total = total.Add(v.ClassicStakedBalance)
+			}
+			allocations, err := tt.strategy.Allocate(AllocationRequest{
+				Stake:             tt.stake,
+				TotalClassicStake: total,
+				Validators:        tt.validators,
+				RewardFees:        tt.fees,
+			})
+			if tt.wantErr != nil {
+				if err == nil || !(errors.Is(err, tt.wantErr) || strings.Contains(err.Error(), tt.wantErr.Error())) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := decimal.Zero
+			got := map[string]decimal.Decimal{}
+			for _, a := range allocations {
+				if a.Stake.LessThan(MinRebalanceStake) {
+					t.Errorf("%s: allocation %s below the minimum", a.Validator.AccountID, a.Stake)
+				}
+				got[a.Validator.AccountID] = a.Stake
+				sum = sum.Add(a.Stake)
+			}
+			if sum.GreaterThan(tt.stake) {
+				t.Errorf("allocations sum %s, more than the stake %s", sum, tt.stake)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for id, stake := range tt.want {
+				if !got[id].Equal(stake) {
+					t.Errorf("%s: got %s, want %s", id, got[id], stake)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package stakepool
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"lido-near-client/internal/config"
+)
+
+const tgas = types.Gas(1_000_000_000_000)
+
+// batchMethods are the calls which can share a transaction with calls of the same method.
+var batchMethods = map[string]bool{
+	"update_validator":         true,
+	"take_unstaked_balance":    true,
+	"increase_validator_stake": true,
+}
+
+// batchSize is the max number of actions in a transaction, one if batching is disabled.
+func batchSize(cfg config.BatchConfig) int {
+	if cfg.Gas == 0 || cfg.ActionGas == 0 || cfg.Gas < 2*cfg.ActionGas {
+		return 1
+	}
+	return int(cfg.Gas / cfg.ActionGas)
+}
+
+// batchSteps groups indexes of consecutive steps of the same batchable method into transactions of up to
+// size actions. Other steps stay alone, so they keep their order relative to the batches.
+func batchSteps(steps []Step, size int) (batches [][]int) {
+	for i, step := range steps {
+		if n := len(batches); n != 0 && batchMethods[step.Method] {
+			last := batches[n-1]
+			if len(last) < size && steps[last[0]].Method == step.Method {
+				batches[n-1] = append(last, i)
+				continue
+			}
+		}
+		batches = append(batches, []int{i})
+	}
+	return batches
+}
+
+// actionErrorIndex returns the index of the failed action of a transaction, -1 if it is unknown.
+func actionErrorIndex(failure json.RawMessage) int {
+	var f struct {
+		ActionError *struct {
+			Index *int `json:"index"`
+		} `json:"ActionError"`
+	}
+	if err := json.Unmarshal(failure, &f); err != nil || f.ActionError == nil || f.ActionError.Index == nil {
+		return -1
+	}
+	return *f.ActionError.Index
+}
+
+// actionValues returns the value of every of the n actions of a successful transaction, nil if it is
+// unknown. NEAR returns the value of the last action only, so the value of a batched call is read from
+// the callback it scheduled on the pool: the action receipt spawns one callback on the pool per action, in
+// the order of the actions.
+func actionValues(res client.FinalExecutionOutcomeView, pool types.AccountID, n int) [][]byte {
+	values := make([][]byte, n)
+	values[n-1], _ = base64.StdEncoding.DecodeString(res.Status.SuccessValue)
+	if n == 1 || len(res.TransactionOutcome.Outcome.ReceiptIDs) == 0 {
+		return values
+	}
+	receipts := make(map[string]client.ExecutionOutcomeView, len(res.ReceiptsOutcome))
+	for _, r := range res.ReceiptsOutcome {
+		receipts[r.ID.String()] = r.Outcome
+	}
+	actions, ok := receipts[res.TransactionOutcome.Outcome.ReceiptIDs[0].String()]
+	if !ok {
+		return values
+	}
+	var callbacks []client.ExecutionOutcomeView
+	for _, id := range actions.ReceiptIDs {
+		if r, ok := receipts[id.String()]; ok && r.ExecutorID == pool {
+			callbacks = append(callbacks, r)
+		}
+	}
+	if len(callbacks) != n {
+		return values
+	}
+	for i, callback := range callbacks {
+		// a callback returning a promise resolves to the value of its receipt
+		status := callback.Status
+		for hops := 0; status.SuccessReceiptID != "" && hops < len(receipts); hops++ {
+			status = receipts[status.SuccessReceiptID].Status
+		}
+		if status.Failure != nil || status.SuccessReceiptID != "" {
+			continue
+		}
+		values[i], _ = base64.StdEncoding.DecodeString(status.SuccessValue)
+	}
+	return values
+}
+
+// waves splits the batches into waves which can be sent concurrently. A wave ends before a batch touching
+// a validator already in the wave and around a step without a validator, such as update and
+// confirm_stake_distribution, so the calls before it are applied first.
+func waves(steps []Step, batches [][]int) (waves [][][]int) {
+	var (
+		wave       [][]int
+		validators = map[string]bool{}
+	)
+	flush := func() {
+		if len(wave) != 0 {
+			waves = append(waves, wave)
+		}
+		wave, validators = nil, map[string]bool{}
+	}
+	for _, batch := range batches {
+		barrier := false
+		for _, i := range batch {
+			if steps[i].Validator == "" || validators[steps[i].Validator] {
+				barrier = true
+			}
+		}
+		if barrier {
+			flush()
+		}
+		wave = append(wave, batch)
+		for _, i := range batch {
+			validators[steps[i].Validator] = true
+		}
+		if steps[batch[0]].Validator == "" {
+			flush()
+		}
+	}
+	flush()
+	return waves
+}
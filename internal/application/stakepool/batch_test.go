@@ -0,0 +1,47 @@
+package stakepool
+
+import (
+	"fmt"
+	"lido-near-client/internal/config"
+	"testing"
+)
+
+func TestBatchSteps(t *testing.T) {
+	methods := []string{
+		"take_unstaked_balance", "requested_decrease_validator_stake", "update_validator", "update_validator",
+		"update_validator", "update_validator", "update", "increase_validator_stake", "confirm_stake_distribution",
+	}
+	steps := make([]Step, len(methods))
+	for i, m := range methods {
+		steps[i] = Step{Method: m}
+	}
+	size := batchSize(config.BatchConfig{Gas: 300, ActionGas: 100})
+	got := fmt.Sprint(batchSteps(steps, size))
+	want := "[[0] [1] [2 3 4] [5] [6] [7] [8]]"
+	if got != want {
+		t.Errorf("batches %s, want %s", got, want)
+	}
+	if n := batchSize(config.BatchConfig{ActionGas: 50}); n != 1 {
+		t.Errorf("disabled batch size %d, want 1", n)
+	}
+	if i := actionErrorIndex([]byte(`{"ActionError":{"index":2,"kind":{}}}`)); i != 2 {
+		t.Errorf("action error index %d, want 2", i)
+	}
+}
+
+func TestWaves(t *testing.T) {
+	steps := []Step{
+		{Method: "update_validator", Validator: "a"},
+		{Method: "update_validator", Validator: "b"},
+		{Method: "update"},
+		{Method: "take_unstaked_balance", Validator: "a"},
+		{Method: "requested_decrease_validator_stake", Validator: "a"},
+		{Method: "requested_decrease_validator_stake", Validator: "b"},
+		{Method: "confirm_stake_distribution"},
+	}
+	got := fmt.Sprint(waves(steps, batchSteps(steps, 1)))
+	want := "[[[0] [1]] [[2]] [[3]] [[4] [5]] [[6]]]"
+	if got != want {
+		t.Errorf("waves %s, want %s", got, want)
+	}
+}
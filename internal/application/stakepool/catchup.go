@@ -0,0 +1,109 @@
+package stakepool
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"strings"
+)
+
+const (
+	// UnstakeCycle is the period of the unstake windows in network epochs, unstaked balance is withdrawable
+	// from validators after as many epochs.
+	UnstakeCycle = 4
+
+	// maxCatchUpPlans limits how many times PoolUpdate is planned again when the network epoch moves on
+	// during a catch-up.
+	maxCatchUpPlans = 3
+)
+
+// CatchUp describes the network epochs the pool missed since its last update.
+type CatchUp struct {
+	PoolEpochHeight    uint64 `json:"pool_epoch_height"`
+	NetworkEpochHeight uint64 `json:"network_epoch_height"`
+	// MissedEpochs is how many epochs between the pool epoch and the network epoch passed without an update,
+	// their stake distribution was skipped.
+	MissedEpochs uint64 `json:"missed_epochs"`
+	// MissedUnstakeEpochs are the unstake windows among the missed epochs.
+	MissedUnstakeEpochs []uint64 `json:"missed_unstake_epochs,omitempty"`
+}
+
+// newCatchUp returns nil if the pool is at most one epoch behind the network.
+func newCatchUp(epochs EpochHeightRegistry) *CatchUp {
+	if epochs.NetworkEpochHeight <= epochs.PoolEpochHeight+1 {
+		return nil
+	}
+	c := &CatchUp{
+		PoolEpochHeight:    epochs.PoolEpochHeight,
+		NetworkEpochHeight: epochs.NetworkEpochHeight,
+		MissedEpochs:       epochs.NetworkEpochHeight - epochs.PoolEpochHeight - 1,
+	}
+	for e := epochs.PoolEpochHeight + 1; e < epochs.NetworkEpochHeight; e++ {
+		if e%UnstakeCycle == 0 {
+			c.MissedUnstakeEpochs = append(c.MissedUnstakeEpochs, e)
+		}
+	}
+	return c
+}
+
+func (c *CatchUp) String() string {
+	s := fmt.Sprintf("pool epoch %d is %d epochs behind network epoch %d, stake distribution of %d epochs skipped",
+		c.PoolEpochHeight, c.MissedEpochs+1, c.NetworkEpochHeight, c.MissedEpochs)
+	if len(c.MissedUnstakeEpochs) != 0 {
+		epochs := make([]string, len(c.MissedUnstakeEpochs))
+		for i, e := range c.MissedUnstakeEpochs {
+			epochs[i] = fmt.Sprint(e)
+		}
+		s += fmt.Sprintf(", unstake window of epoch %s runs late", strings.Join(epochs, ", "))
+	}
+	return s
+}
+
+// unstakeDue reports whether the pool update to the network epoch takes unstaked balance and unstakes the
+// requested withdrawals: the network epoch is an unstake window or the pool missed one.
+func unstakeDue(epochs EpochHeightRegistry) bool {
+	if epochs.PoolEpochHeight >= epochs.NetworkEpochHeight {
+		return false
+	}
+	return epochs.NetworkEpochHeight/UnstakeCycle*UnstakeCycle > epochs.PoolEpochHeight
+}
+
+// unstakeReason explains why the pool unstakes at the network epoch.
+func unstakeReason(epochs EpochHeightRegistry) string {
+	if epochs.NetworkEpochHeight%UnstakeCycle == 0 {
+		return fmt.Sprintf("unstake window at epoch %d", epochs.NetworkEpochHeight)
+	}
+	return fmt.Sprintf("missed unstake window at epoch %d", epochs.NetworkEpochHeight/UnstakeCycle*UnstakeCycle)
+}
+
+// isUnstakedBalanceAvailable asks the validator staking pool whether the unstaked balance of the stake pool
+// is withdrawable. Balance unstaked late after a missed window stays locked past the next window.
+func (s *Service) isUnstakedBalanceAvailable(validator string) (bool, error) {
+	args, err := json.Marshal(map[string]string{"account_id": s.cfg.StakePool})
+	if err != nil {
+		return false, errors.Wrap(err, "json.Marshal")
+	}
+	result, err := s.callAccountContract(validator, "is_account_unstaked_balance_available", base64.StdEncoding.EncodeToString(args))
+	if err != nil {
+		return false, errors.Wrap(err, "callAccountContract")
+	}
+	var available bool
+	err = json.Unmarshal(result, &available)
+	if err != nil {
+		return false, errors.Wrap(err, "json.Unmarshal")
+	}
+	return available, nil
+}
+
+// networkEpochMoved reports whether the network epoch is past the epoch the plan was built for.
+func (s *Service) networkEpochMoved(plan Plan) bool {
+	var epochs EpochHeightRegistry
+	err := s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
+	if err != nil {
+		s.log.Warn(plan.Job+": get_current_epoch_height", zap.Error(err))
+		return false
+	}
+	return epochs.NetworkEpochHeight > plan.Epochs.NetworkEpochHeight
+}
@@ -0,0 +1,39 @@
+package stakepool
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/jsonrpc"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/pkg/errors"
+)
+
+type (
+	// ChainClient is the subset of the NEAR RPC API used by the stake pool jobs.
+	// *client.Client from near-api-go implements it directly.
+	ChainClient interface {
+		ContractViewCallFunction(ctx context.Context, accountID, methodName, argsBase64 string, block block.BlockCharacteristic) (jsonrpc.Response, error)
+		RPCTransactionSendAwait(ctx context.Context, signedTxnBase64 string) (client.FinalExecutionOutcomeView, error)
+		TransactionStatus(ctx context.Context, tx hash.CryptoHash, sender types.AccountID) (client.FinalExecutionOutcomeView, error)
+		AccessKeyView(ctx context.Context, accountID types.AccountID, publicKey key.Base58PublicKey, block block.BlockCharacteristic) (client.AccessKeyView, error)
+		AccountView(ctx context.Context, accountID types.AccountID, block block.BlockCharacteristic) (jsonrpc.Response, error)
+		BlockDetails(ctx context.Context, block block.BlockCharacteristic) (client.BlockView, error)
+		GenesisConfig(ctx context.Context) (jsonrpc.Response, error)
+		NetworkStatusValidators(ctx context.Context) (jsonrpc.Response, error)
+		NetworkStatusValidatorsDetailed(ctx context.Context, block block.BlockCharacteristic) (jsonrpc.Response, error)
+	}
+)
+
+var _ ChainClient = (*client.Client)(nil)
+
+// NewNearClient returns near-api-go client connected to the node.
+func NewNearClient(node string) (ChainClient, error) {
+	cli, err := client.NewClient(node)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.NewClient")
+	}
+	return &cli, nil
+}
@@ -0,0 +1,255 @@
+package stakepool
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/action"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/config"
+	"sync"
+)
+
+type (
+	// Executor applies plans built by the jobs. The observers are notified about the transactions of the
+	// plan besides the observers of the executor.
+	Executor interface {
+		Execute(plan Plan, observers ...Observer) ([]StepResult, error)
+	}
+	// Observer is notified about every transaction sent by the executor.
+	Observer interface {
+		ObserveTransaction(result StepResult, err error)
+	}
+	// SignedObserver is an Observer notified once a transaction is signed, before it is sent. The steps are
+	// the indexes of the plan steps called by the transaction, an error cancels the transaction.
+	SignedObserver interface {
+		ObserveSigned(steps []int, txHash string) error
+	}
+	chainExecutor struct {
+		ctx       context.Context
+		log       *zap.Logger
+		cli       ChainClient
+		keys      *keyPool
+		signer    types.AccountID
+		pool      types.AccountID
+		observers []Observer
+		batch     config.BatchConfig
+		// concurrency limits the transactions sent at once
+		concurrency int
+		retry       *retrier
+		// validityPeriod is the transaction validity period in blocks, zero until it is queried
+		validityPeriod uint64
+		genesisMu      sync.Mutex
+	}
+	// DryRunExecutor records plans without sending transactions.
+	DryRunExecutor struct {
+		mu    sync.Mutex
+		plans []Plan
+	}
+)
+
+// Execute sends the plan wave by wave, the batches of a wave concurrently up to the limit and the number
+// of access keys.
+func (e *chainExecutor) Execute(plan Plan, observers ...Observer) (results []StepResult, err error) {
+	observers = append(append([]Observer(nil), e.observers...), observers...)
+	for _, wave := range waves(plan.Steps, batchSteps(plan.Steps, batchSize(e.batch))) {
+		var (
+			wg          sync.WaitGroup
+			limit       = make(chan struct{}, e.concurrency)
+			waveResults = make([][]StepResult, len(wave))
+			waveErrs    = make([][]error, len(wave))
+		)
+		for w, batch := range wave {
+			steps := make([]Step, len(batch))
+			for k, i := range batch {
+				steps[k] = plan.Steps[i]
+			}
+			wg.Add(1)
+			limit <- struct{}{}
+			go func(w int, batch []int) {
+				defer wg.Done()
+				waveResults[w], waveErrs[w] = e.send(steps, func(txHash string) error {
+					return observeSigned(observers, batch, txHash)
+				})
+				<-limit
+			}(w, batch)
+		}
+		wg.Wait()
+
+		for w := range wave {
+			for k, result := range waveResults[w] {
+				result.Job = plan.Job
+				result.NetworkEpochHeight = plan.Epochs.NetworkEpochHeight
+				result.Index = wave[w][k]
+				for _, o := range observers {
+					o.ObserveTransaction(result, waveErrs[w][k])
+				}
+				if waveErrs[w][k] != nil {
+					if err == nil {
+						err = errors.Wrapf(waveErrs[w][k], "%s[validator:%s]", result.Step.Method, result.Step.Validator)
+					}
+					continue
+				}
+				results = append(results, result)
+				e.log.Info(
+					plan.Job+": call "+result.Step.Method,
+					zap.String("validator", result.Step.Validator),
+					zap.String("amount", result.Step.Amount.String()),
+					zap.String("tx_hash", result.TxHash),
+					zap.Int("batch", len(wave[w])),
+				)
+			}
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func observeSigned(observers []Observer, steps []int, txHash string) error {
+	for _, o := range observers {
+		if so, ok := o.(SignedObserver); ok {
+			if err := so.ObserveSigned(steps, txHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send sends the steps as actions of one transaction and returns a result and an error for every step.
+// onSigned is called with the hash of every signed transaction before it is sent.
+// The actions of a transaction are applied atomically, so a failed action reverts the others. The value of
+// every action is checked, a batched call with an unknown value fails.
+func (e *chainExecutor) send(steps []Step, onSigned func(txHash string) error) (results []StepResult, errs []error) {
+	results = make([]StepResult, len(steps))
+	errs = make([]error, len(steps))
+	actions := make([]action.Action, len(steps))
+	for i, step := range steps {
+		results[i].Step = step
+		gas := step.Gas
+		if len(steps) > 1 {
+			gas = types.Gas(e.batch.ActionGas) * tgas
+		}
+		actions[i] = action.NewFunctionCall(step.Method, step.args(), gas, types.BalanceFromFloat(0))
+	}
+	fail := func(i int, err error) ([]StepResult, []error) {
+		for k := range errs {
+			errs[k] = err
+			if i >= 0 && k != i {
+				errs[k] = errors.Wrapf(err, "reverted with %s[validator:%s]", steps[i].Method, steps[i].Validator)
+			}
+		}
+		return results, errs
+	}
+	k, err := e.keys.acquire(e.ctx)
+	if err != nil {
+		return fail(-1, errors.Wrap(err, "acquire key"))
+	}
+	defer e.keys.release(k)
+	var (
+		res    client.FinalExecutionOutcomeView
+		txHash string
+	)
+	err = e.retry.do(e.ctx, "transaction", isResendable, func() (err error) {
+		res, txHash, err = e.sendTransaction(k, actions, onSigned)
+		return err
+	})
+	if err != nil {
+		// the hash of a transaction with an unknown outcome lets the journal reconcile it
+		for i := range results {
+			results[i].TxHash = txHash
+		}
+		return fail(-1, errors.Wrap(err, "sendTransaction"))
+	}
+
+	// the gas of a batch is split evenly among its actions
+	gasBurnt := res.TransactionOutcome.Outcome.GasBurnt
+	tokensBurnt := balanceToDecimal(res.TransactionOutcome.Outcome.TokensBurnt)
+	for _, r := range res.ReceiptsOutcome {
+		gasBurnt += r.Outcome.GasBurnt
+		tokensBurnt = tokensBurnt.Add(balanceToDecimal(r.Outcome.TokensBurnt))
+	}
+	n := len(steps)
+	for i := range results {
+		results[i].TxHash = res.Transaction.Hash.String()
+		results[i].GasBurnt = gasBurnt / types.Gas(n)
+		results[i].TokensBurnt = tokensBurnt.Div(decimal.NewFromInt(int64(n))).Truncate(0)
+	}
+	results[0].GasBurnt += gasBurnt % types.Gas(n)
+	results[0].TokensBurnt = results[0].TokensBurnt.Add(tokensBurnt.Sub(results[0].TokensBurnt.Mul(decimal.NewFromInt(int64(n)))))
+
+	if res.Status.Failure != nil {
+		i := actionErrorIndex(res.Status.Failure)
+		if i >= n {
+			i = -1
+		}
+		return fail(i, errors.Errorf("failure: %s", string(res.Status.Failure)))
+	}
+	for i, value := range actionValues(res, e.pool, n) {
+		if value == nil && steps[i].Expect != ResultNone {
+			errs[i] = errors.New("result of the batched call is unknown")
+			continue
+		}
+		results[i].Value = value
+		errs[i] = checkResult(steps[i], value)
+	}
+	return results, errs
+}
+
+func balanceToDecimal(balance types.Balance) decimal.Decimal {
+	d, err := decimal.NewFromString(balance.String())
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+func checkResult(step Step, value []byte) error {
+	switch step.Expect {
+	case ResultBool:
+		var resp bool
+		err := json.Unmarshal(value, &resp)
+		if err != nil {
+			return errors.Wrap(err, "json.Unmarshal(resp)")
+		}
+		if !resp {
+			return errors.New("false result")
+		}
+	case ResultCallback:
+		var resp CallbackResult
+		err := json.Unmarshal(value, &resp)
+		if err != nil {
+			return errors.Wrap(err, "json.Unmarshal(resp)")
+		}
+		if !resp.IsSuccess {
+			return errors.Errorf("fail result from validator %s", step.Validator)
+		}
+		if resp.NetworkEpochHeight != step.EpochHeight {
+			return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, step.EpochHeight)
+		}
+	}
+	return nil
+}
+
+func NewDryRunExecutor() *DryRunExecutor {
+	return &DryRunExecutor{}
+}
+
+func (e *DryRunExecutor) Execute(plan Plan, _ ...Observer) ([]StepResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.plans = append(e.plans, plan)
+	return nil, nil
+}
+
+// Plans returns recorded plans in execution order.
+func (e *DryRunExecutor) Plans() []Plan {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Plan(nil), e.plans...)
+}
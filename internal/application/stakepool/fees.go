@@ -0,0 +1,82 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type (
+	// ValidatorFee is the reward fee a validator staking pool charged in a network epoch.
+	ValidatorFee struct {
+		AccountID   string   `json:"account_id"`
+		EpochHeight uint64   `json:"epoch_height"`
+		RewardFee   Dividing `json:"reward_fee"`
+	}
+)
+
+// Fraction returns the fee as a fraction of rewards.
+func (f ValidatorFee) Fraction() decimal.Decimal {
+	return f.RewardFee.GetValue()
+}
+
+// GetValidatorFees queries get_reward_fee_fraction of every registry validator. A validator whose fee can
+// not be queried is logged and left out, the call fails only if no fee is known.
+func (s *Service) GetValidatorFees() ([]ValidatorFee, error) {
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return nil, errors.Wrap(err, "getEpochsAndValidators")
+	}
+	var (
+		fees    = make([]ValidatorFee, 0, len(validators))
+		lastErr error
+	)
+	for _, v := range validators {
+		fee, err := s.getRewardFee(v.AccountID)
+		if err != nil {
+			s.log.Warn("GetValidatorFees: skip validator", zap.String("validator", v.AccountID), zap.Error(err))
+			lastErr = errors.Wrapf(err, "getRewardFee(%s)", v.AccountID)
+			continue
+		}
+		fees = append(fees, ValidatorFee{AccountID: v.AccountID, EpochHeight: epochs.NetworkEpochHeight, RewardFee: fee})
+	}
+	if len(fees) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return fees, nil
+}
+
+func (s *Service) getRewardFee(accountID string) (fee Dividing, err error) {
+	result, err := s.callAccountContract(accountID, "get_reward_fee_fraction", "")
+	if err != nil {
+		return fee, errors.Wrap(err, "callAccountContract")
+	}
+	err = json.Unmarshal(result, &fee)
+	if err != nil {
+		return fee, errors.Wrap(err, "json.Unmarshal")
+	}
+	if fee.Denominator.IsZero() {
+		return fee, errors.New("zero denominator")
+	}
+	return fee, nil
+}
+
+// filterByRewardFee returns the fee fractions of the validators for the allocation strategy and leaves out
+// validators whose fee can not be queried.
+func (s *Service) filterByRewardFee(validators []Validator) ([]Validator, map[string]decimal.Decimal) {
+	var (
+		filtered []Validator
+		fees     = make(map[string]decimal.Decimal, len(validators))
+	)
+	for _, v := range validators {
+		fee, err := s.getRewardFee(v.AccountID)
+		if err != nil {
+			s.log.Warn("IncreaseStake: skip validator with unknown reward fee", zap.String("validator", v.AccountID), zap.Error(err))
+			continue
+		}
+		fees[v.AccountID] = fee.GetValue()
+		filtered = append(filtered, v)
+	}
+	return filtered, fees
+}
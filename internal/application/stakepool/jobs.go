@@ -0,0 +1,88 @@
+package stakepool
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+const (
+	TakeUnstakedBalanceJob             = "TakeUnstakedBalance"
+	RequestedDecreaseValidatorStakeJob = "RequestedDecreaseValidatorStake"
+	UpdateValidatorJob                 = "UpdateValidator"
+)
+
+// TakeUnstakedBalance runs only the take_unstaked_balance step of PoolUpdate.
+func (s *Service) TakeUnstakedBalance() error {
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return errors.Wrap(err, "getEpochsAndValidators")
+	}
+	plan := newPlan(TakeUnstakedBalanceJob)
+	plan.Epochs = epochs
+	steps, err := s.planTakeUnstakedBalance(epochs, validators)
+	if err != nil {
+		return errors.Wrap(err, "planTakeUnstakedBalance")
+	}
+	plan.add(steps...)
+	if len(plan.Steps) == 0 {
+		plan.skip(fmt.Sprintf("no withdrawable unstaked balance at network epoch %d (pool epoch %d)", epochs.NetworkEpochHeight, epochs.PoolEpochHeight))
+	}
+	_, err = s.execute(plan)
+	if err != nil {
+		return errors.Wrap(err, "Execute")
+	}
+	return nil
+}
+
+// RequestedDecreaseValidatorStake runs only the requested_decrease_validator_stake step of PoolUpdate.
+func (s *Service) RequestedDecreaseValidatorStake() error {
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return errors.Wrap(err, "getEpochsAndValidators")
+	}
+	plan := newPlan(RequestedDecreaseValidatorStakeJob)
+	plan.Epochs = epochs
+	steps, err := s.planRequestedDecreaseValidatorStake(epochs, validators)
+	if err != nil {
+		return errors.Wrap(err, "planRequestedDecreaseValidatorStake")
+	}
+	plan.add(steps...)
+	if len(plan.Steps) == 0 {
+		plan.skip(fmt.Sprintf("no requested withdrawals to unstake at network epoch %d (pool epoch %d)", epochs.NetworkEpochHeight, epochs.PoolEpochHeight))
+	}
+	_, err = s.execute(plan)
+	if err != nil {
+		return errors.Wrap(err, "Execute")
+	}
+	return nil
+}
+
+// UpdateValidator runs update_validator for a single registry validator.
+func (s *Service) UpdateValidator(accountID string) error {
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return errors.Wrap(err, "getEpochsAndValidators")
+	}
+	plan := newPlan(UpdateValidatorJob)
+	plan.Epochs = epochs
+	var found bool
+	for _, v := range validators {
+		if v.AccountID != accountID {
+			continue
+		}
+		found = true
+		if v.LastUpdateEpochHeight == epochs.NetworkEpochHeight {
+			plan.skip(fmt.Sprintf("validator is already updated to epoch %d", epochs.NetworkEpochHeight))
+			break
+		}
+		plan.add(updateValidatorStep(v, epochs))
+	}
+	if !found {
+		return errors.Errorf("validator %s is not in the registry", accountID)
+	}
+	_, err = s.execute(plan)
+	if err != nil {
+		return errors.Wrap(err, "Execute")
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StepPlanned JournalStepState = "planned"
+	// StepSent is a step handed to the executor without a recorded outcome.
+	StepSent   JournalStepState = "sent"
+	StepDone   JournalStepState = "done"
+	StepFailed JournalStepState = "failed"
+
+	// journalEpochs is how many network epochs of entries are kept.
+	journalEpochs = 8
+)
+
+type (
+	JournalStepState string
+	// Journal keeps the progress of plans on disk, one file per network epoch and job.
+	Journal struct {
+		mu  sync.Mutex
+		dir string
+	}
+	JournalEntry struct {
+		Job    string              `json:"job"`
+		Phase  string              `json:"phase,omitempty"`
+		Epochs EpochHeightRegistry `json:"epochs"`
+		Steps  []JournalStep       `json:"steps"`
+		// ClassicStaked are the classic staked balances of the validators the plan unstakes classic stake from,
+		// when the plan was built.
+		ClassicStaked map[string]decimal.Decimal `json:"classic_staked,omitempty"`
+		// Resumed is set once the entry is continued after an interruption.
+		Resumed bool `json:"resumed"`
+		Done    bool `json:"done"`
+		// Note explains how the entry was finished if not all of its steps are done.
+		Note      string    `json:"note,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	JournalStep struct {
+		Step   Step             `json:"step"`
+		State  JournalStepState `json:"state"`
+		TxHash string           `json:"tx_hash,omitempty"`
+		Error  string           `json:"error,omitempty"`
+	}
+)
+
+// NewJournal creates the journal directory if it does not exist.
+func NewJournal(dir string) (*Journal, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	return &Journal{dir: dir}, nil
+}
+
+// Load returns the entry of the epoch, job and phase, nil if there is none.
+func (j *Journal) Load(networkEpochHeight uint64, job, phase string) (*JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	data, err := os.ReadFile(j.path(networkEpochHeight, job, phase))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var entry JournalEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return &entry, nil
+}
+
+// Save atomically replaces the entry file and removes entries of old epochs.
+func (j *Journal) Save(entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	tmp, err := os.CreateTemp(j.dir, ".entry-*")
+	if err != nil {
+		return errors.Wrap(err, "os.CreateTemp")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write")
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "close")
+	}
+	err = os.Rename(tmp.Name(), j.path(entry.Epochs.NetworkEpochHeight, entry.Job, entry.Phase))
+	if err != nil {
+		return errors.Wrap(err, "os.Rename")
+	}
+	if dir, err := os.Open(j.dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	j.prune(entry.Epochs.NetworkEpochHeight)
+	return nil
+}
+
+func (j *Journal) path(networkEpochHeight uint64, job, phase string) string {
+	if phase != "" {
+		job += "-" + phase
+	}
+	return filepath.Join(j.dir, fmt.Sprintf("%d-%s.json", networkEpochHeight, job))
+}
+
+func (j *Journal) prune(networkEpochHeight uint64) {
+	if networkEpochHeight < journalEpochs {
+		return
+	}
+	files, err := os.ReadDir(j.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		epoch, err := strconv.ParseUint(strings.SplitN(f.Name(), "-", 2)[0], 10, 64)
+		if err == nil && epoch < networkEpochHeight-journalEpochs {
+			_ = os.Remove(filepath.Join(j.dir, f.Name()))
+		}
+	}
+}
+
+func (e *JournalEntry) finish(note string) {
+	e.Done = true
+	e.Note = note
+}
+
+// execute runs the plan through the journal. An unfinished entry of the same epoch and job is
+// reconciled with the chain and resumed from its first unfinished step instead of the new plan.
+func (s *Service) execute(plan Plan) (results []StepResult, err error) {
+	if s.journal == nil {
+		return s.executor.Execute(plan)
+	}
+	entry, err := s.journal.Load(plan.Epochs.NetworkEpochHeight, plan.Job, plan.Phase)
+	if err != nil {
+		return nil, errors.Wrap(err, "journal.Load")
+	}
+	switch {
+	case entry != nil && !entry.Done:
+		err = s.reconcile(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, "reconcile")
+		}
+		entry.Resumed = true
+		s.log.Info(plan.Job+": resume journaled plan", zap.Uint64("network_epoch", entry.Epochs.NetworkEpochHeight))
+	case len(plan.Steps) == 0:
+		return s.executor.Execute(plan)
+	default:
+		entry, err = s.newJournalEntry(plan)
+		if err != nil {
+			return nil, errors.Wrap(err, "newJournalEntry")
+		}
+	}
+	// the unfinished steps are sent as one plan, the executor splits it into waves
+	var (
+		pending []int
+		rest    = Plan{Job: entry.Job, Phase: entry.Phase, Epochs: entry.Epochs}
+	)
+	for i := range entry.Steps {
+		step := &entry.Steps[i]
+		if step.State != StepDone {
+			pending = append(pending, i)
+			rest.Steps = append(rest.Steps, step.Step)
+			step.State, step.Error = StepSent, ""
+		}
+	}
+	if err = s.journal.Save(entry); err != nil {
+		return nil, errors.Wrap(err, "journal.Save")
+	}
+	if len(pending) != 0 {
+		results, err = s.executor.Execute(rest, &journalObserver{s: s, entry: entry, pending: pending})
+	}
+	if err != nil {
+		for _, i := range pending {
+			// the steps after a failed wave are not sent
+			if entry.Steps[i].State == StepSent {
+				entry.Steps[i].State = StepPlanned
+			}
+		}
+		if entry.Resumed {
+			// the journaled plan may not fit the chain anymore, the next run plans from scratch
+			entry.finish("abandoned after a failed resume")
+		}
+		if saveErr := s.journal.Save(entry); saveErr != nil {
+			s.log.Error("journal.Save", zap.Error(saveErr))
+		}
+		return results, err
+	}
+	entry.finish("")
+	if err = s.journal.Save(entry); err != nil {
+		return results, errors.Wrap(err, "journal.Save")
+	}
+	return results, nil
+}
+
+// journalObserver records the transactions of a plan in its journal entry: the hash before a transaction is
+// sent, so an interrupted send is reconciled by its outcome, and the outcome once it is known.
+type journalObserver struct {
+	mu    sync.Mutex
+	s     *Service
+	entry *JournalEntry
+	// pending maps the steps of the sent plan to the steps of the entry
+	pending []int
+}
+
+// ObserveSigned implements SignedObserver.
+func (o *journalObserver) ObserveSigned(steps []int, txHash string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, i := range steps {
+		o.entry.Steps[o.pending[i]].TxHash = txHash
+	}
+	return errors.Wrap(o.s.journal.Save(o.entry), "journal.Save")
+}
+
+// ObserveTransaction implements Observer.
+func (o *journalObserver) ObserveTransaction(result StepResult, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	step := &o.entry.Steps[o.pending[result.Index]]
+	step.State, step.TxHash = StepDone, result.TxHash
+	if err != nil {
+		step.State, step.Error = StepFailed, err.Error()
+	}
+	if saveErr := o.s.journal.Save(o.entry); saveErr != nil {
+		o.s.log.Error("journal.Save", zap.Error(saveErr))
+	}
+}
+
+func (s *Service) newJournalEntry(plan Plan) (*JournalEntry, error) {
+	entry := &JournalEntry{Job: plan.Job, Phase: plan.Phase, Epochs: plan.Epochs, CreatedAt: time.Now().UTC()}
+	for _, step := range plan.Steps {
+		entry.Steps = append(entry.Steps, JournalStep{Step: step, State: StepPlanned})
+		if isClassicDecrease(step) && entry.ClassicStaked == nil {
+			validators, err := s.GetValidatorRegistry()
+			if err != nil {
+				return nil, errors.Wrap(err, "GetValidatorRegistry")
+			}
+			entry.ClassicStaked = make(map[string]decimal.Decimal, len(validators))
+			for _, v := range validators {
+				entry.ClassicStaked[v.AccountID] = v.ClassicStakedBalance
+			}
+		}
+	}
+	return entry, nil
+}
+
+// reconcile marks unfinished steps done if they were applied: by the outcome of the journaled transaction
+// if it is known, otherwise by the chain state.
+func (s *Service) reconcile(entry *JournalEntry) error {
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return errors.Wrap(err, "getEpochsAndValidators")
+	}
+	byID := make(map[string]Validator, len(validators))
+	for _, v := range validators {
+		byID[v.AccountID] = v
+	}
+	var (
+		requested        *RequestedToWithdrawalFund
+		decreasedClassic = map[string]decimal.Decimal{}
+		outcomes         = map[string]*client.FinalExecutionOutcomeView{}
+	)
+	for i := range entry.Steps {
+		step := &entry.Steps[i]
+		if isClassicDecrease(step.Step) {
+			decreasedClassic[step.Step.Validator] = decreasedClassic[step.Step.Validator].Add(step.Step.Amount)
+		}
+		if step.State == StepDone {
+			continue
+		}
+		landed, known := s.landedByTxHash(entry, i, outcomes)
+		v := byID[step.Step.Validator]
+		if !known {
+			switch step.Step.Method {
+			case "update_validator":
+				landed = v.LastUpdateEpochHeight == step.Step.EpochHeight
+			case "update":
+				landed = epochs.PoolEpochHeight >= entry.Epochs.NetworkEpochHeight
+			case "increase_validator_stake":
+				landed = v.LastClassicStakeIncreasingEpochHeight != nil && *v.LastClassicStakeIncreasingEpochHeight == entry.Epochs.NetworkEpochHeight
+			case "confirm_stake_distribution":
+				landed, err = s.IsStakeDistributed()
+				if err != nil {
+					return errors.Wrap(err, "IsStakeDistributed")
+				}
+			case "take_unstaked_balance":
+				// a later unstake may have added balance, so it is not checked for zero
+				landed = v.UnstakedBalance.LessThan(step.Step.Amount)
+			case "requested_decrease_validator_stake":
+				if isClassicDecrease(step.Step) {
+					// the classic staked balance of the validator shrinks by its classic unstakes, including
+					// this one, and does not grow before the pool is updated
+					staked, ok := entry.ClassicStaked[step.Step.Validator]
+					landed = ok && v.ClassicStakedBalance.LessThanOrEqual(staked.Sub(decreasedClassic[step.Step.Validator]))
+					break
+				}
+				if requested == nil {
+					fund, err := s.GetRequestedToWithdrawalFund()
+					if err != nil {
+						return errors.Wrap(err, "GetRequestedToWithdrawalFund")
+					}
+					requested = &fund
+				}
+				landed = !hasInvestmentRequest(*requested, step.Step.Validator)
+			}
+		}
+		if landed {
+			step.State = StepDone
+		} else {
+			step.State = StepPlanned
+		}
+		s.log.Info(entry.Job+": reconciled journaled step", zap.String("method", step.Step.Method),
+			zap.String("validator", step.Step.Validator), zap.Bool("landed", landed), zap.Bool("by_tx_hash", known))
+	}
+	return nil
+}
+
+// landedByTxHash reports whether the journaled transaction of the step was applied, known is false if the
+// step has no transaction or its outcome can not be queried. Outcomes are cached by hash in outcomes.
+func (s *Service) landedByTxHash(entry *JournalEntry, i int, outcomes map[string]*client.FinalExecutionOutcomeView) (landed, known bool) {
+	step := entry.Steps[i]
+	if step.TxHash == "" {
+		return false, false
+	}
+	res, ok := outcomes[step.TxHash]
+	if !ok {
+		txHash, err := hash.NewCryptoHashFromBase58(step.TxHash)
+		if err == nil {
+			var outcome client.FinalExecutionOutcomeView
+			outcome, err = s.cli.TransactionStatus(s.ctx, txHash, types.AccountID(s.cfg.KeyPairAccountID))
+			if err == nil {
+				res = &outcome
+			}
+		}
+		if err != nil {
+			s.log.Warn(entry.Job+": journaled transaction outcome is unknown", zap.String("tx_hash", step.TxHash), zap.Error(err))
+		}
+		outcomes[step.TxHash] = res
+	}
+	if res == nil {
+		return false, false
+	}
+	// a failed action reverts the whole transaction
+	if res.Status.Failure != nil {
+		return false, true
+	}
+	// the steps of a batch share the transaction in the order of their actions
+	action, n := 0, 0
+	for k, other := range entry.Steps {
+		if other.TxHash == step.TxHash {
+			if k < i {
+				action++
+			}
+			n++
+		}
+	}
+	value := actionValues(*res, types.AccountID(s.cfg.StakePool), n)[action]
+	if value == nil {
+		return false, false
+	}
+	return checkResult(step.Step, value) == nil, true
+}
+
+func isClassicDecrease(step Step) bool {
+	// args loaded from the journal hold plain strings
+	return step.Method == "requested_decrease_validator_stake" &&
+		fmt.Sprint(step.Args["stake_decreasing_type"]) == string(ClassicStakeDecreasingType)
+}
+
+func hasInvestmentRequest(fund RequestedToWithdrawalFund, validator string) bool {
+	for _, v := range fund.InvestmentWithdrawalRegistry {
+		if len(v) == 2 && v[0] == validator {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package stakepool_test
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/application/stakepool/simulator"
+	"lido-near-client/internal/config"
+	"testing"
+)
+
+// crashingClient loses the outcome of a transaction after it is applied and can not query it, like a crash.
+type crashingClient struct {
+	stakepool.ChainClient
+	// skip is how many transactions are sent before the lost one, none is lost if it is negative
+	skip int
+	sent int
+}
+
+func (c *crashingClient) RPCTransactionSendAwait(ctx context.Context, signedTxnBase64 string) (client.FinalExecutionOutcomeView, error) {
+	res, err := c.ChainClient.RPCTransactionSendAwait(ctx, signedTxnBase64)
+	c.sent++
+	if c.sent == c.skip+1 {
+		return client.FinalExecutionOutcomeView{}, errors.New("timeout")
+	}
+	return res, err
+}
+
+func (c *crashingClient) TransactionStatus(context.Context, hash.CryptoHash, types.AccountID) (client.FinalExecutionOutcomeView, error) {
+	return client.FinalExecutionOutcomeView{}, errors.New("crashed")
+}
+
+func TestJournalResumesInterruptedPlan(t *testing.T) {
+	sim := newTestSimulator(t)
+	journalDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.JournalDir = journalDir
+	}
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AddValidator("b.test.near", decimal.Zero, false)
+	sim.Deposit(near(30))
+	sim.AdvanceEpoch(0.01)
+	if err := newTestService(t, sim, configure).PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+	sim.SetEpochProgress(0.9)
+
+	cli, err := stakepool.NewNearClient(sim.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashing, err := stakepool.New(stakepool.ServiceParam{
+		Ctx:    context.Background(),
+		Log:    zap.NewNop(),
+		Cfg:    newTestConfig(t, sim, configure),
+		Client: &crashingClient{ChainClient: cli},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crashing.IncreaseStake(); err == nil {
+		t.Fatal("IncreaseStake: want the lost outcome error")
+	}
+
+	if err := newTestService(t, sim, configure).IncreaseStake(); err != nil {
+		t.Fatalf("IncreaseStake (resumed): %s", err)
+	}
+	if n := countCalls(sim, "increase_validator_stake"); n != 2 {
+		t.Errorf("increase_validator_stake called %d times, want 2", n)
+	}
+	if n := countCalls(sim, "confirm_stake_distribution"); n != 1 {
+		t.Errorf("confirm_stake_distribution called %d times, want 1", n)
+	}
+	for _, v := range sim.Contract().Validators {
+		if !v.ClassicStakedBalance.Equal(near(15)) {
+			t.Errorf("%s: classic staked %s, want 15 NEAR", v.AccountID, v.ClassicStakedBalance)
+		}
+	}
+
+	journal, err := stakepool.NewJournal(journalDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := journal.Load(sim.Contract().NetworkEpochHeight, stakepool.IncreaseStakeJob, "")
+	if err != nil || entry == nil {
+		t.Fatalf("journal.Load: %v %v", entry, err)
+	}
+	if !entry.Done || !entry.Resumed || len(entry.Steps) != 3 {
+		t.Fatalf("entry: done %t, resumed %t, %d steps", entry.Done, entry.Resumed, len(entry.Steps))
+	}
+	for i, step := range entry.Steps {
+		if step.State != stakepool.StepDone {
+			t.Errorf("step %d %s: state %s", i, step.Step.Method, step.State)
+		}
+		// the outcome of the first step was lost, it is reconciled by its transaction hash
+		if step.TxHash == "" {
+			t.Errorf("step %d %s: tx hash %q", i, step.Step.Method, step.TxHash)
+		}
+	}
+}
+
+func TestReconcileByTxHash(t *testing.T) {
+	sim := newTestSimulator(t)
+	journalDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.JournalDir = journalDir
+	}
+	s := newTestService(t, sim, configure)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Deposit(near(30))
+	for epoch := 1; epoch <= 3; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	sim.RequestWithdrawal(near(30))
+	for epoch := 4; epoch <= 7; epoch++ {
+		runEpoch(t, sim, s)
+	}
+
+	// take_unstaked_balance of the unstake window of epoch 8 lands, its outcome is lost
+	sim.AdvanceEpoch(0.01)
+	cli, err := stakepool.NewNearClient(sim.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashing, err := stakepool.New(stakepool.ServiceParam{
+		Ctx:    context.Background(),
+		Log:    zap.NewNop(),
+		Cfg:    newTestConfig(t, sim, configure),
+		Client: &crashingClient{ChainClient: cli},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crashing.PoolUpdate(); err == nil {
+		t.Fatal("PoolUpdate: want the lost outcome error")
+	}
+	// balance unstaked since then does not make the taken balance look untaken
+	sim.Update(func(c *simulator.Contract) {
+		c.Validators[0].UnstakedBalance = near(40)
+	})
+
+	if err := newTestService(t, sim, configure).PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate (resumed): %s", err)
+	}
+	if n := countCalls(sim, "take_unstaked_balance"); n != 1 {
+		t.Errorf("take_unstaked_balance called %d times, want 1", n)
+	}
+	if state := sim.Contract(); !state.Withdrawn.Equal(near(30)) || state.PoolEpochHeight != 8 {
+		t.Errorf("withdrawn %s, pool epoch %d, want %s and 8", state.Withdrawn, state.PoolEpochHeight, near(30))
+	}
+}
+
+func TestReconcileClassicUnstake(t *testing.T) {
+	sim := newTestSimulator(t)
+	journalDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.JournalDir = journalDir
+	}
+	s := newTestService(t, sim, configure)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Deposit(near(30))
+	for epoch := 1; epoch <= 3; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	sim.RequestWithdrawal(near(10))
+
+	// requested_decrease_validator_stake of the unstake window of epoch 4 lands after update_validator,
+	// its outcome is lost
+	sim.AdvanceEpoch(0.01)
+	cli, err := stakepool.NewNearClient(sim.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashing, err := stakepool.New(stakepool.ServiceParam{
+		Ctx:    context.Background(),
+		Log:    zap.NewNop(),
+		Cfg:    newTestConfig(t, sim, configure),
+		Client: &crashingClient{ChainClient: cli, skip: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crashing.PoolUpdate(); err == nil {
+		t.Fatal("PoolUpdate: want the lost outcome error")
+	}
+	// a withdrawal requested since then does not make the unstake look unsent
+	sim.RequestWithdrawal(near(5))
+
+	// the outcome stays unknown, the step is reconciled by the chain state
+	resumed, err := stakepool.New(stakepool.ServiceParam{
+		Ctx:    context.Background(),
+		Log:    zap.NewNop(),
+		Cfg:    newTestConfig(t, sim, configure),
+		Client: &crashingClient{ChainClient: cli, skip: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate (resumed): %s", err)
+	}
+	if n := countCalls(sim, "requested_decrease_validator_stake"); n != 1 {
+		t.Errorf("requested_decrease_validator_stake called %d times, want 1", n)
+	}
+	state := sim.Contract()
+	if !state.Validators[0].ClassicStakedBalance.Equal(near(20)) || !state.RequestedClassic.Equal(near(5)) || state.PoolEpochHeight != 4 {
+		t.Errorf("classic staked %s, requested %s, pool epoch %d, want %s, %s and 4",
+			state.Validators[0].ClassicStakedBalance, state.RequestedClassic, state.PoolEpochHeight, near(20), near(5))
+	}
+}
+
+// inspectingClient calls inspect before sending every transaction.
+type inspectingClient struct {
+	stakepool.ChainClient
+	inspect func()
+}
+
+func (c *inspectingClient) RPCTransactionSendAwait(ctx context.Context, signedTxnBase64 string) (client.FinalExecutionOutcomeView, error) {
+	c.inspect()
+	return c.ChainClient.RPCTransactionSendAwait(ctx, signedTxnBase64)
+}
+
+func TestJournalRecordsTxHashBeforeSending(t *testing.T) {
+	sim := newTestSimulator(t)
+	journalDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.JournalDir = journalDir
+	}
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AddValidator("b.test.near", decimal.Zero, false)
+	sim.Deposit(near(30))
+	sim.AdvanceEpoch(0.01)
+	if err := newTestService(t, sim, configure).PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+	sim.SetEpochProgress(0.9)
+
+	journal, err := stakepool.NewJournal(journalDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := stakepool.NewNearClient(sim.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := 0
+	inspecting := &inspectingClient{ChainClient: cli, inspect: func() {
+		// a crash now leaves the journal with the hash of the transaction
+		entry, err := journal.Load(sim.Contract().NetworkEpochHeight, stakepool.IncreaseStakeJob, "")
+		if err != nil || entry == nil {
+			t.Fatalf("journal.Load: %v %v", entry, err)
+		}
+		sent++
+		hashes := 0
+		for _, step := range entry.Steps {
+			if step.State == stakepool.StepSent && step.TxHash != "" {
+				hashes++
+			}
+		}
+		if hashes == 0 {
+			t.Errorf("transaction %d: no sent step with a tx hash in the journal", sent)
+		}
+	}}
+	s, err := stakepool.New(stakepool.ServiceParam{
+		Ctx:    context.Background(),
+		Log:    zap.NewNop(),
+		Cfg:    newTestConfig(t, sim, configure),
+		Client: inspecting,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.IncreaseStake(); err != nil {
+		t.Fatalf("IncreaseStake: %s", err)
+	}
+	if sent != 3 {
+		t.Errorf("%d transactions sent, want 3", sent)
+	}
+}
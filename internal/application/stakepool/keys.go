@@ -0,0 +1,72 @@
+package stakepool
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/pkg/errors"
+)
+
+type (
+	// accessKey is an operator access key with its own nonce.
+	accessKey struct {
+		keyPair key.KeyPair
+		// nonce is the last nonce used by the key, zero if it has to be queried
+		nonce types.Nonce
+	}
+	// keyPool hands out operator access keys, so every key signs one transaction at a time.
+	keyPool struct {
+		cli    ChainClient
+		signer types.AccountID
+		free   chan *accessKey
+		size   int
+	}
+)
+
+func newKeyPool(cli ChainClient, signer types.AccountID, keyPairs []key.KeyPair) *keyPool {
+	p := &keyPool{cli: cli, signer: signer, free: make(chan *accessKey, len(keyPairs)), size: len(keyPairs)}
+	for _, kp := range keyPairs {
+		p.free <- &accessKey{keyPair: kp}
+	}
+	return p
+}
+
+// acquire waits for a free key.
+func (p *keyPool) acquire(ctx context.Context) (*accessKey, error) {
+	select {
+	case k := <-p.free:
+		return k, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *keyPool) release(k *accessKey) {
+	p.free <- k
+}
+
+// nextNonce returns the nonce of the next transaction signed by the key.
+func (p *keyPool) nextNonce(ctx context.Context, k *accessKey) (types.Nonce, error) {
+	if k.nonce == 0 {
+		view, err := p.cli.AccessKeyView(ctx, p.signer, k.keyPair.PublicKey, block.FinalityFinal())
+		if err != nil {
+			return 0, errors.Wrap(err, "AccessKeyView")
+		}
+		k.nonce = view.Nonce
+	}
+	return k.nonce + 1, nil
+}
+
+// parseKeyPairs parses the main operator key followed by the extra ones.
+func parseKeyPairs(main string, extra []string) ([]key.KeyPair, error) {
+	var keyPairs []key.KeyPair
+	for _, encoded := range append([]string{main}, extra...) {
+		keyPair, err := key.NewBase58KeyPair(encoded)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewBase58KeyPair")
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	return keyPairs, nil
+}
@@ -0,0 +1,320 @@
+package stakepool
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/jsonrpc"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/config"
+	"sync"
+	"time"
+)
+
+type (
+	// NodeHealth is the result of the latest health probe of an RPC endpoint.
+	NodeHealth struct {
+		URL         string        `json:"url"`
+		Active      bool          `json:"active"`
+		Healthy     bool          `json:"healthy"`
+		Syncing     bool          `json:"syncing"`
+		BlockHeight uint64        `json:"block_height"`
+		Latency     time.Duration `json:"latency"`
+		Error       string        `json:"error,omitempty"`
+		CheckedAt   time.Time     `json:"checked_at"`
+	}
+	// nodePool sends calls to the active RPC endpoint and fails over to another healthy one if it is
+	// unreachable. Endpoints are healthy until a probe or a call shows otherwise.
+	nodePool struct {
+		log     *zap.Logger
+		clients []ChainClient
+		cfg     config.NodeProbeConfig
+
+		mu     sync.RWMutex
+		health []NodeHealth
+		active int
+	}
+	nodeStatus struct {
+		SyncInfo struct {
+			LatestBlockHeight uint64 `json:"latest_block_height"`
+			Syncing           bool   `json:"syncing"`
+		} `json:"sync_info"`
+	}
+)
+
+var _ ChainClient = (*nodePool)(nil)
+
+// newNodePool connects to the endpoints, the first one is active until it fails or a probe rejects it.
+func newNodePool(log *zap.Logger, urls []string, cfg config.NodeProbeConfig) (*nodePool, error) {
+	p := &nodePool{log: log, cfg: cfg}
+	for _, url := range urls {
+		cli, err := NewNearClient(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewNearClient(%s)", url)
+		}
+		p.clients = append(p.clients, cli)
+		p.health = append(p.health, NodeHealth{URL: url, Healthy: true})
+	}
+	if len(p.clients) == 0 {
+		return nil, errors.New("no RPC endpoints")
+	}
+	p.health[0].Active = true
+	return p, nil
+}
+
+// nodeURLs returns the preferred node followed by the other endpoints without duplicates.
+func nodeURLs(node string, nodes []string) (urls []string) {
+	seen := map[string]bool{}
+	for _, url := range append([]string{node}, nodes...) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// run probes the endpoints every probe interval until ctx is done.
+func (p *nodePool) run(ctx context.Context) {
+	if p.cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.probe(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probe checks the status, sync state and latest block of every endpoint. An endpoint is healthy if it
+// responds, is not syncing and lags the highest endpoint by at most MaxLag blocks. The active endpoint is
+// kept while it is healthy, otherwise the healthy endpoint with the highest block becomes active.
+func (p *nodePool) probe(ctx context.Context) {
+	health := make([]NodeHealth, len(p.clients))
+	var wg sync.WaitGroup
+	for i, cli := range p.clients {
+		wg.Add(1)
+		go func(i int, cli ChainClient) {
+			defer wg.Done()
+			health[i] = probeNode(ctx, cli, p.cfg.Timeout)
+		}(i, cli)
+	}
+	wg.Wait()
+
+	var best uint64
+	for _, h := range health {
+		if h.Error == "" && !h.Syncing && h.BlockHeight > best {
+			best = h.BlockHeight
+		}
+	}
+	for i := range health {
+		h := &health[i]
+		switch {
+		case h.Error != "":
+		case h.Syncing:
+			h.Error = "syncing"
+		case best-h.BlockHeight > p.cfg.MaxLag:
+			h.Error = "lagging"
+		default:
+			h.Healthy = true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range health {
+		health[i].URL = p.health[i].URL
+		if h := health[i]; h.Healthy != p.health[i].Healthy {
+			p.log.Warn("rpc: node health changed", zap.String("node", h.URL), zap.Bool("healthy", h.Healthy),
+				zap.String("reason", h.Error), zap.Uint64("block_height", h.BlockHeight), zap.Uint64("best_block_height", best))
+		}
+	}
+	p.health = health
+	if health[p.active].Healthy {
+		health[p.active].Active = true
+		return
+	}
+	next := -1
+	for i, h := range health {
+		if h.Healthy && (next < 0 || h.BlockHeight > health[next].BlockHeight) {
+			next = i
+		}
+	}
+	if next < 0 {
+		health[p.active].Active = true
+		p.log.Error("rpc: no healthy node", zap.String("node", health[p.active].URL))
+		return
+	}
+	p.switchTo(next, health[p.active].Error)
+}
+
+func probeNode(ctx context.Context, cli ChainClient, timeout time.Duration) (h NodeHealth) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	t := time.Now()
+	h.CheckedAt = t.UTC()
+	resp, err := cli.NetworkStatusValidators(ctx)
+	h.Latency = time.Since(t)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	var status nodeStatus
+	if err = json.Unmarshal(resp.Result, &status); err != nil {
+		h.Error = errors.Wrap(err, "json.Unmarshal").Error()
+		return h
+	}
+	h.BlockHeight, h.Syncing = status.SyncInfo.LatestBlockHeight, status.SyncInfo.Syncing
+	return h
+}
+
+// switchTo makes the endpoint active, the caller holds the lock.
+func (p *nodePool) switchTo(i int, reason string) {
+	p.log.Warn("rpc: switch node", zap.String("from", p.health[p.active].URL), zap.String("to", p.health[i].URL),
+		zap.String("reason", reason))
+	p.health[p.active].Active = false
+	p.health[i].Active = true
+	p.active = i
+}
+
+// Nodes returns the health of the endpoints.
+func (p *nodePool) Nodes() []NodeHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]NodeHealth(nil), p.health...)
+}
+
+// do calls the active endpoint and, if it is unreachable, the other healthy ones in turn. An endpoint which
+// failed is unhealthy until the next probe.
+func (p *nodePool) do(failover func(ErrorKind) bool, call func(cli ChainClient) error) error {
+	p.mu.RLock()
+	first := p.active
+	p.mu.RUnlock()
+	var err error
+	for n := 0; n < len(p.clients); n++ {
+		i := (first + n) % len(p.clients)
+		p.mu.RLock()
+		healthy := p.health[i].Healthy
+		p.mu.RUnlock()
+		if n != 0 && !healthy {
+			continue
+		}
+		err = call(p.clients[i])
+		if err == nil || !failover(ClassifyError(err)) {
+			if err == nil && i != first {
+				p.mu.Lock()
+				if p.active != i {
+					p.switchTo(i, "previous node failed")
+				}
+				p.mu.Unlock()
+			}
+			return err
+		}
+		p.mu.Lock()
+		p.health[i].Healthy, p.health[i].Error = false, err.Error()
+		url := p.health[i].URL
+		p.mu.Unlock()
+		p.log.Warn("rpc: node failed", zap.String("node", url), zap.Error(err))
+	}
+	return err
+}
+
+// isNodeFailure fails over calls on errors of the endpoint rather than of the call.
+func isNodeFailure(kind ErrorKind) bool {
+	switch kind {
+	case ErrUnavailable, ErrRateLimited, ErrUnknownBlock, ErrTimeout:
+		return true
+	}
+	return false
+}
+
+// isSendFailure fails over transactions which were surely not processed. After a timeout the outcome is
+// polled by the executor, which may fail over itself.
+func isSendFailure(kind ErrorKind) bool {
+	return kind == ErrUnavailable || kind == ErrRateLimited
+}
+
+func (p *nodePool) ContractViewCallFunction(ctx context.Context, accountID, methodName, argsBase64 string, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.ContractViewCallFunction(ctx, accountID, methodName, argsBase64, block)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) RPCTransactionSendAwait(ctx context.Context, signedTxnBase64 string) (res client.FinalExecutionOutcomeView, err error) {
+	err = p.do(isSendFailure, func(cli ChainClient) (err error) {
+		res, err = cli.RPCTransactionSendAwait(ctx, signedTxnBase64)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) TransactionStatus(ctx context.Context, tx hash.CryptoHash, sender types.AccountID) (res client.FinalExecutionOutcomeView, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.TransactionStatus(ctx, tx, sender)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) AccessKeyView(ctx context.Context, accountID types.AccountID, publicKey key.Base58PublicKey, block block.BlockCharacteristic) (res client.AccessKeyView, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.AccessKeyView(ctx, accountID, publicKey, block)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) AccountView(ctx context.Context, accountID types.AccountID, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.AccountView(ctx, accountID, block)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) BlockDetails(ctx context.Context, block block.BlockCharacteristic) (res client.BlockView, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.BlockDetails(ctx, block)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) GenesisConfig(ctx context.Context) (res jsonrpc.Response, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.GenesisConfig(ctx)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) NetworkStatusValidators(ctx context.Context) (res jsonrpc.Response, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.NetworkStatusValidators(ctx)
+		return err
+	})
+	return res, err
+}
+
+func (p *nodePool) NetworkStatusValidatorsDetailed(ctx context.Context, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = p.do(isNodeFailure, func(cli ChainClient) (err error) {
+		res, err = cli.NetworkStatusValidatorsDetailed(ctx, block)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,87 @@
+package stakepool
+
+import (
+	"context"
+	"fmt"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/jsonrpc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/config"
+	"testing"
+)
+
+type stubNode struct {
+	ChainClient
+	height  uint64
+	syncing bool
+	down    bool
+	calls   int
+}
+
+func (n *stubNode) NetworkStatusValidators(context.Context) (jsonrpc.Response, error) {
+	if n.down {
+		return jsonrpc.Response{}, errors.New("dial tcp: connect: connection refused")
+	}
+	result := fmt.Sprintf(`{"sync_info":{"latest_block_height":%d,"syncing":%t}}`, n.height, n.syncing)
+	return jsonrpc.Response{Result: []byte(result)}, nil
+}
+
+func (n *stubNode) BlockDetails(context.Context, block.BlockCharacteristic) (client.BlockView, error) {
+	n.calls++
+	if n.down {
+		return client.BlockView{}, errors.New("dial tcp: connect: connection refused")
+	}
+	return client.BlockView{}, nil
+}
+
+func TestNodePool(t *testing.T) {
+	lagging, behind, best, syncing := &stubNode{height: 100}, &stubNode{height: 115}, &stubNode{height: 120}, &stubNode{height: 130, syncing: true}
+	p := &nodePool{log: zap.NewNop(), cfg: config.NodeProbeConfig{MaxLag: 10}}
+	for i, n := range []*stubNode{lagging, behind, best, syncing} {
+		p.clients = append(p.clients, n)
+		p.health = append(p.health, NodeHealth{URL: fmt.Sprint(i), Healthy: true, Active: i == 0})
+	}
+	active := func() (urls string) {
+		for _, h := range p.Nodes() {
+			if h.Active {
+				urls += h.URL
+			}
+		}
+		return urls
+	}
+
+	p.probe(context.Background())
+	if got := active(); got != "2" {
+		t.Fatalf("active node %s after the probe, want the highest healthy 2", got)
+	}
+	for i, want := range []string{"lagging", "", "", "syncing"} {
+		if h := p.Nodes()[i]; h.Error != want || h.Healthy != (want == "") {
+			t.Errorf("node %d: healthy %t, error %q, want %q", i, h.Healthy, h.Error, want)
+		}
+	}
+
+	best.down = true
+	if _, err := p.BlockDetails(context.Background(), block.FinalityFinal()); err != nil {
+		t.Fatalf("BlockDetails: %s", err)
+	}
+	if got := active(); got != "1" || lagging.calls != 0 || syncing.calls != 0 {
+		t.Errorf("active node %s after the failure, calls to rejected nodes %d %d", got, lagging.calls, syncing.calls)
+	}
+
+	// the active node is kept while it is healthy
+	best.down, best.height = false, 125
+	p.probe(context.Background())
+	if got := active(); got != "1" {
+		t.Errorf("active node %s, want 1", got)
+	}
+
+	behind.down = true
+	if _, err := p.BlockDetails(context.Background(), block.FinalityFinal()); err != nil {
+		t.Fatalf("BlockDetails: %s", err)
+	}
+	if got := active(); got != "2" {
+		t.Errorf("active node %s, want 2", got)
+	}
+}
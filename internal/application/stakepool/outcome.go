@@ -0,0 +1,159 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/types/action"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/eteu-technologies/near-api-go/pkg/types/transaction"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// outcomeBlockTime is the slowest expected block time, the wait for an outcome is bounded by the validity
+	// period of the transaction at this block time.
+	outcomeBlockTime = 2 * time.Second
+	// maxUnreadHeights is how many times in a row the chain height may fail to be read while the transaction
+	// is unknown.
+	maxUnreadHeights = 10
+)
+
+// sendTransaction signs the actions with the key and sends them. The hash of the signed transaction is kept,
+// so if the response is lost the outcome is recovered by the hash instead of signing the calls again. The hash
+// is returned with the error as well once the transaction is signed and passed to onSigned before it is sent.
+func (e *chainExecutor) sendTransaction(k *accessKey, actions []action.Action, onSigned func(txHash string) error) (res client.FinalExecutionOutcomeView, txHash string, err error) {
+	nonce, err := e.keys.nextNonce(e.ctx, k)
+	if err != nil {
+		return res, "", notSent(errors.Wrap(err, "nextNonce"))
+	}
+	latest, err := e.cli.BlockDetails(e.ctx, block.FinalityFinal())
+	if err != nil {
+		return res, "", notSent(errors.Wrap(err, "BlockDetails"))
+	}
+	signed, err := transaction.NewSignedTransaction(k.keyPair, transaction.Transaction{
+		SignerID:   e.signer,
+		PublicKey:  k.keyPair.PublicKey.ToPublicKey(),
+		Nonce:      nonce,
+		ReceiverID: e.pool,
+		BlockHash:  latest.Header.Hash,
+		Actions:    actions,
+	})
+	if err != nil {
+		return res, "", errors.Wrap(err, "NewSignedTransaction")
+	}
+	blob, err := signed.Serialize()
+	if err != nil {
+		return res, "", errors.Wrap(err, "Serialize")
+	}
+	signedHash := signed.Hash()
+	txHash = signedHash.String()
+	if err = onSigned(txHash); err != nil {
+		return res, "", errors.Wrap(err, "onSigned")
+	}
+	e.log.Debug("send transaction", zap.String("tx_hash", txHash), zap.Uint64("nonce", nonce))
+
+	res, err = e.cli.RPCTransactionSendAwait(e.ctx, blob)
+	if err != nil && ClassifyError(err) == ErrTimeout {
+		res, err = e.awaitOutcome(signedHash, latest.Header.Height, blob)
+	}
+	if err != nil {
+		// the nonce may or may not be used, it is queried again
+		k.nonce = 0
+		return res, txHash, err
+	}
+	k.nonce = nonce
+	return res, txHash, nil
+}
+
+// awaitOutcome polls the status of a sent transaction until it is final or expired. A transaction unknown to
+// the node is sent again as is: the same signed transaction is never applied twice. The transaction expires
+// once the chain is past the validity period of its reference block, then it can be signed again safely.
+// If the expiry can not be confirmed in time, the outcome is reported unknown with ErrTimeout, so the calls
+// are not signed again.
+func (e *chainExecutor) awaitOutcome(txHash hash.CryptoHash, height uint64, blob string) (res client.FinalExecutionOutcomeView, err error) {
+	validityPeriod, err := e.getValidityPeriod()
+	if err != nil {
+		return res, errors.Wrapf(err, "transaction %s outcome is unknown: getValidityPeriod", txHash)
+	}
+	var (
+		deadline      = time.Now().Add(time.Duration(validityPeriod) * outcomeBlockTime)
+		unreadHeights int
+	)
+	for attempt := 1; ; attempt++ {
+		res, err = e.cli.TransactionStatus(e.ctx, txHash, e.signer)
+		if err == nil {
+			e.log.Info("recovered transaction outcome", zap.String("tx_hash", txHash.String()), zap.Int("attempt", attempt))
+			return res, nil
+		}
+		kind := ClassifyError(err)
+		switch {
+		case kind == ErrUnknownTx:
+			latest, err := e.cli.BlockDetails(e.ctx, block.FinalityFinal())
+			if err == nil && latest.Header.Height > height+validityPeriod {
+				return res, &ChainError{Kind: ErrExpiredTx, Err: errors.Errorf("transaction %s expired unapplied", txHash)}
+			}
+			if err != nil {
+				unreadHeights++
+				if unreadHeights >= maxUnreadHeights {
+					return res, &ChainError{Kind: ErrTimeout, Err: errors.Wrapf(err, "transaction %s outcome is unknown: BlockDetails", txHash)}
+				}
+			} else {
+				unreadHeights = 0
+			}
+			res, err = e.cli.RPCTransactionSendAwait(e.ctx, blob)
+			if err == nil {
+				return res, nil
+			}
+			if k := ClassifyError(err); k == ErrInvalidNonce || k == ErrExpiredTx {
+				// the transaction was not applied and can not be anymore
+				return res, err
+			}
+		case !kind.Transient():
+			return res, errors.Wrapf(err, "transaction %s outcome is unknown", txHash)
+		}
+		if time.Now().After(deadline) {
+			return res, &ChainError{Kind: ErrTimeout, Err: errors.Wrapf(err, "transaction %s outcome is unknown after %d blocks", txHash, validityPeriod)}
+		}
+		delay := e.retry.backoff(attempt)
+		e.log.Warn("await transaction outcome", zap.String("tx_hash", txHash.String()), zap.String("kind", string(kind)),
+			zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-e.ctx.Done():
+			return res, errors.Wrapf(e.ctx.Err(), "transaction %s outcome is unknown", txHash)
+		}
+	}
+}
+
+// notSent marks a timeout before the transaction is sent as safe to retry.
+func notSent(err error) error {
+	if ClassifyError(err) == ErrTimeout {
+		return &ChainError{Kind: ErrUnavailable, Err: err}
+	}
+	return err
+}
+
+func (e *chainExecutor) getValidityPeriod() (uint64, error) {
+	e.genesisMu.Lock()
+	defer e.genesisMu.Unlock()
+	if e.validityPeriod != 0 {
+		return e.validityPeriod, nil
+	}
+	resp, err := e.cli.GenesisConfig(e.ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "GenesisConfig")
+	}
+	var cfg GenesisConfig
+	err = json.Unmarshal(resp.Result, &cfg)
+	if err != nil {
+		return 0, errors.Wrap(err, "json.Unmarshal")
+	}
+	if cfg.TransactionValidityPeriod == 0 {
+		return 0, errors.New("no transaction validity period in the genesis config")
+	}
+	e.validityPeriod = cfg.TransactionValidityPeriod
+	return e.validityPeriod, nil
+}
@@ -0,0 +1,143 @@
+package stakepool_test
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/config"
+	"testing"
+	"time"
+)
+
+// lossyClient times out the first transaction, after it is applied or instead of sending it.
+type lossyClient struct {
+	stakepool.ChainClient
+	drop bool
+	sent int
+}
+
+func (c *lossyClient) RPCTransactionSendAwait(ctx context.Context, signedTxnBase64 string) (client.FinalExecutionOutcomeView, error) {
+	c.sent++
+	if c.sent == 1 && c.drop {
+		return client.FinalExecutionOutcomeView{}, errors.New("Post: context deadline exceeded")
+	}
+	res, err := c.ChainClient.RPCTransactionSendAwait(ctx, signedTxnBase64)
+	if c.sent == 1 {
+		return client.FinalExecutionOutcomeView{}, errors.New("JSON-RPC error 'Server error' (-32000) \"TIMEOUT_ERROR\"")
+	}
+	return res, err
+}
+
+func TestRecoversTransactionOutcome(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		drop bool
+	}{
+		{name: "applied", drop: false},
+		{name: "dropped", drop: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestSimulator(t)
+			sim.AddValidator("a.test.near", decimal.Zero, false)
+			sim.AddValidator("b.test.near", decimal.Zero, false)
+			sim.Deposit(near(30))
+			sim.AdvanceEpoch(0.01)
+			if err := newTestService(t, sim).PoolUpdate(); err != nil {
+				t.Fatalf("PoolUpdate: %s", err)
+			}
+			sim.SetEpochProgress(0.9)
+
+			cli, err := stakepool.NewNearClient(sim.URL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := stakepool.New(stakepool.ServiceParam{
+				Ctx: context.Background(),
+				Log: zap.NewNop(),
+				Cfg: newTestConfig(t, sim, func(cfg *config.Config) {
+					cfg.Retry = config.RetryConfig{Attempts: 3, Delay: time.Millisecond}
+				}),
+				Client: &lossyClient{ChainClient: cli, drop: tt.drop},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.IncreaseStake(); err != nil {
+				t.Fatalf("IncreaseStake: %s", err)
+			}
+			// every call is applied once, the lost one is not signed again
+			if n := countCalls(sim, "increase_validator_stake"); n != 2 {
+				t.Errorf("increase_validator_stake called %d times, want 2", n)
+			}
+			if n := countCalls(sim, "confirm_stake_distribution"); n != 1 {
+				t.Errorf("confirm_stake_distribution called %d times, want 1", n)
+			}
+			for _, c := range sim.Calls() {
+				if c.Error != nil {
+					t.Errorf("%s failed: %s", c.Method, c.Error)
+				}
+			}
+		})
+	}
+}
+
+// stalledClient times out every transaction, does not know it and can not read blocks after the first one.
+type stalledClient struct {
+	stakepool.ChainClient
+	blocks int
+	sent   int
+}
+
+func (c *stalledClient) RPCTransactionSendAwait(context.Context, string) (client.FinalExecutionOutcomeView, error) {
+	c.sent++
+	return client.FinalExecutionOutcomeView{}, errors.New("JSON-RPC error 'Server error' (-32000) \"TIMEOUT_ERROR\"")
+}
+
+func (c *stalledClient) TransactionStatus(context.Context, hash.CryptoHash, types.AccountID) (client.FinalExecutionOutcomeView, error) {
+	return client.FinalExecutionOutcomeView{}, errors.New("UNKNOWN_TRANSACTION")
+}
+
+func (c *stalledClient) BlockDetails(ctx context.Context, b block.BlockCharacteristic) (client.BlockView, error) {
+	c.blocks++
+	if c.blocks > 1 {
+		return client.BlockView{}, errors.New("connection refused")
+	}
+	return c.ChainClient.BlockDetails(ctx, b)
+}
+
+func TestAwaitOutcomeGivesUp(t *testing.T) {
+	sim := newTestSimulator(t)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AdvanceEpoch(0.01)
+
+	cli, err := stakepool.NewNearClient(sim.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled := &stalledClient{ChainClient: cli}
+	s, err := stakepool.New(stakepool.ServiceParam{
+		Ctx: context.Background(),
+		Log: zap.NewNop(),
+		Cfg: newTestConfig(t, sim, func(cfg *config.Config) {
+			cfg.Retry = config.RetryConfig{Attempts: 3, Delay: time.Millisecond}
+		}),
+		Client: stalled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateValidator("a.test.near")
+	if err == nil || stakepool.ClassifyError(err) != stakepool.ErrTimeout {
+		t.Fatalf("UpdateValidator: %v, want an unknown outcome", err)
+	}
+	// the transaction is sent again as is, never signed again
+	if stalled.blocks != 1+10 {
+		t.Errorf("%d blocks read, want the reference block and 10 attempts", stalled.blocks)
+	}
+}
@@ -0,0 +1,150 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type (
+	// ValidatorPerformance is how a registry validator did on chain in a network epoch.
+	ValidatorPerformance struct {
+		AccountID   string `json:"account_id"`
+		EpochHeight uint64 `json:"epoch_height"`
+		// Active is false if the validator was not in the validator set of the epoch.
+		Active         bool    `json:"active"`
+		ProducedBlocks uint64  `json:"produced_blocks"`
+		ExpectedBlocks uint64  `json:"expected_blocks"`
+		ProducedChunks uint64  `json:"produced_chunks"`
+		ExpectedChunks uint64  `json:"expected_chunks"`
+		Uptime         float64 `json:"uptime"`
+	}
+	networkValidators struct {
+		CurrentValidators []struct {
+			AccountID         string `json:"account_id"`
+			NumProducedBlocks uint64 `json:"num_produced_blocks"`
+			NumExpectedBlocks uint64 `json:"num_expected_blocks"`
+			NumProducedChunks uint64 `json:"num_produced_chunks"`
+			NumExpectedChunks uint64 `json:"num_expected_chunks"`
+		} `json:"current_validators"`
+		EpochHeight      uint64 `json:"epoch_height"`
+		EpochStartHeight uint64 `json:"epoch_start_height"`
+	}
+)
+
+// GetValidatorPerformance scores the registry validators in the last completed network epoch.
+func (s *Service) GetValidatorPerformance() ([]ValidatorPerformance, error) {
+	epochs, registry, err := s.getEpochsAndValidators()
+	if err != nil {
+		return nil, errors.Wrap(err, "getEpochsAndValidators")
+	}
+	previous, err := s.getPreviousEpochStats(epochs.NetworkEpochHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "getPreviousEpochStats")
+	}
+	return scoreValidators(previous, registry), nil
+}
+
+// getPreviousEpochStats returns the validator stats of the last block of the epoch before the network epoch,
+// they are final and cached until the network epoch changes.
+func (s *Service) getPreviousEpochStats(networkEpochHeight uint64) (networkValidators, error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.stats != nil && s.statsEpoch == networkEpochHeight {
+		return *s.stats, nil
+	}
+	current, err := s.getNetworkValidators(block.FinalityFinal())
+	if err != nil {
+		return networkValidators{}, errors.Wrap(err, "getNetworkValidators(current)")
+	}
+	// heights may be skipped, so the last block of the previous epoch is found by the hash in the first block
+	// of the current one
+	start, err := s.cli.BlockDetails(s.ctx, block.BlockID(uint(current.EpochStartHeight)))
+	if err != nil {
+		return networkValidators{}, errors.Wrap(err, "BlockDetails(epoch start)")
+	}
+	previous, err := s.getNetworkValidators(block.BlockHash(start.Header.PrevHash))
+	if err != nil {
+		return networkValidators{}, errors.Wrap(err, "getNetworkValidators(previous)")
+	}
+	if current.EpochHeight == networkEpochHeight {
+		s.stats, s.statsEpoch = &previous, networkEpochHeight
+	}
+	return previous, nil
+}
+
+// filterByUptime leaves out validators below the min uptime in the last completed epoch.
+func (s *Service) filterByUptime(validators []Validator) ([]Validator, error) {
+	performance, err := s.GetValidatorPerformance()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetValidatorPerformance")
+	}
+	uptimes := make(map[string]float64, len(performance))
+	for _, p := range performance {
+		uptimes[p.AccountID] = p.Uptime
+	}
+	var filtered []Validator
+	for _, v := range validators {
+		if uptimes[v.AccountID] < s.cfg.Allocation.MinUptime {
+			s.log.Info("IncreaseStake: skip validator with low uptime",
+				zap.String("validator", v.AccountID), zap.Float64("uptime", uptimes[v.AccountID]))
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+	return filtered, nil
+}
+
+func (s *Service) getNetworkValidators(b block.BlockCharacteristic) (resp networkValidators, err error) {
+	res, err := s.cli.NetworkStatusValidatorsDetailed(s.ctx, b)
+	if err != nil {
+		return resp, errors.Wrap(err, "NetworkStatusValidatorsDetailed")
+	}
+	if res.Error != nil {
+		return resp, errors.Errorf("NetworkStatusValidatorsDetailed: %s", string(res.Error.Data))
+	}
+	err = json.Unmarshal(res.Result, &resp)
+	if err != nil {
+		return resp, errors.Wrap(err, "json.Unmarshal")
+	}
+	return resp, nil
+}
+
+func scoreValidators(network networkValidators, registry []Validator) []ValidatorPerformance {
+	performance := make([]ValidatorPerformance, 0, len(registry))
+	for _, v := range registry {
+		p := ValidatorPerformance{AccountID: v.AccountID, EpochHeight: network.EpochHeight}
+		for _, n := range network.CurrentValidators {
+			if n.AccountID != v.AccountID {
+				continue
+			}
+			p.Active = true
+			p.ProducedBlocks, p.ExpectedBlocks = n.NumProducedBlocks, n.NumExpectedBlocks
+			p.ProducedChunks, p.ExpectedChunks = n.NumProducedChunks, n.NumExpectedChunks
+			p.Uptime = uptime(p)
+		}
+		performance = append(performance, p)
+	}
+	return performance
+}
+
+// uptime averages produced to expected ratios of blocks and chunks, as the NEAR explorer does.
+func uptime(p ValidatorPerformance) float64 {
+	var ratios []float64
+	if p.ExpectedBlocks != 0 {
+		ratios = append(ratios, float64(p.ProducedBlocks)/float64(p.ExpectedBlocks))
+	}
+	if p.ExpectedChunks != 0 {
+		ratios = append(ratios, float64(p.ProducedChunks)/float64(p.ExpectedChunks))
+	}
+	if len(ratios) == 0 {
+		// nothing was expected, so nothing was missed
+		return 1
+	}
+	var sum float64
+	for _, r := range ratios {
+		sum += r
+	}
+	return sum / float64(len(ratios))
+}
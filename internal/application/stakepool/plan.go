@@ -0,0 +1,82 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ResultNone     ResultKind = "none"
+	ResultBool     ResultKind = "bool"
+	ResultCallback ResultKind = "callback"
+)
+
+var callGas = types.DefaultFunctionCallGas * 10
+
+type (
+	// ResultKind describes the value a contract method returns and how it is checked.
+	ResultKind string
+	// Plan is an ordered list of contract calls a job is going to send.
+	Plan struct {
+		Job string `json:"job"`
+		// Phase names the part of a job planned after the previous part is executed.
+		Phase string `json:"phase,omitempty"`
+		// Epochs is the pool state the plan is built for.
+		Epochs EpochHeightRegistry `json:"epochs"`
+		Steps  []Step              `json:"steps"`
+		// Skip explains why the plan has no steps.
+		Skip string `json:"skip,omitempty"`
+		// CatchUp is set if the pool missed several epochs.
+		CatchUp *CatchUp `json:"catch_up,omitempty"`
+	}
+	Step struct {
+		Method    string                 `json:"method"`
+		Args      map[string]interface{} `json:"args,omitempty"`
+		Gas       types.Gas              `json:"gas"`
+		Validator string                 `json:"validator,omitempty"`
+		Amount    decimal.Decimal        `json:"amount"`
+		Reason    string                 `json:"reason"`
+		Expect    ResultKind             `json:"expect"`
+		// EpochHeight is a network epoch height the callback result must report.
+		EpochHeight uint64 `json:"epoch_height,omitempty"`
+	}
+	StepResult struct {
+		Job                string
+		NetworkEpochHeight uint64
+		Step               Step
+		TxHash             string
+		GasBurnt           types.Gas
+		// TokensBurnt is yoctoNEAR paid for the gas.
+		TokensBurnt decimal.Decimal
+		Value       json.RawMessage
+		// Index is the position of the step in the plan.
+		Index int
+	}
+)
+
+func newPlan(job string) Plan {
+	return Plan{Job: job, Steps: []Step{}}
+}
+
+func (p *Plan) add(steps ...Step) {
+	p.Steps = append(p.Steps, steps...)
+}
+
+func (p *Plan) skip(reason string) Plan {
+	p.Skip = reason
+	return *p
+}
+
+func (s Step) args() []byte {
+	if len(s.Args) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(s.Args)
+	return data
+}
+
+// toNear converts yoctoNEAR to NEAR.
+func toNear(amount decimal.Decimal) decimal.Decimal {
+	return amount.Div(decimal.New(1, 24))
+}
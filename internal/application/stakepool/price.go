@@ -0,0 +1,64 @@
+package stakepool
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// priceTTL is how long a queried NEAR price is reused.
+const priceTTL = 5 * time.Minute
+
+type (
+	// PriceProvider returns the USD price of a coin by its CoinGecko id.
+	PriceProvider interface {
+		USDPrice(ctx context.Context, coinID string) (decimal.Decimal, error)
+	}
+	// Valuation is the pool valued in USD at the current NEAR price.
+	Valuation struct {
+		NearPriceUSD      decimal.Decimal `json:"near_price_usd"`
+		TVL               decimal.Decimal `json:"tvl_usd"`
+		AccruedRewardsUSD decimal.Decimal `json:"accrued_rewards_usd"`
+		// OperatorGasSpend is yoctoNEAR burnt by the operator in the network epoch, unknown without the storage.
+		OperatorGasSpend    decimal.NullDecimal `json:"operator_gas_spend"`
+		OperatorGasSpendUSD decimal.NullDecimal `json:"operator_gas_spend_usd"`
+	}
+)
+
+// NearPrice returns the USD price of NEAR, queried at most once per priceTTL.
+func (s *Service) NearPrice() (decimal.Decimal, error) {
+	if s.prices == nil {
+		return decimal.Zero, errors.New("price provider is not configured")
+	}
+	s.priceMu.Lock()
+	price, priceAt := s.price, s.priceAt
+	s.priceMu.Unlock()
+	if !priceAt.IsZero() && time.Since(priceAt) < priceTTL {
+		return price, nil
+	}
+	// the price API is queried without the lock, so a slow one holds up the valuation only
+	price, err := s.prices.USDPrice(s.ctx, coinGeckoID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	s.priceMu.Lock()
+	s.price, s.priceAt = price, time.Now()
+	s.priceMu.Unlock()
+	return price, nil
+}
+
+func (s *Service) getValuation(fund Fund, agg AggInfo) (v Valuation, err error) {
+	v.NearPriceUSD, err = s.NearPrice()
+	if err != nil {
+		return v, errors.Wrap(err, "NearPrice")
+	}
+	v.TVL = ToUSD(fund.CommonBalance, v.NearPriceUSD)
+	v.AccruedRewardsUSD = ToUSD(agg.TotalRewardsFromValidatorsNearAmount, v.NearPriceUSD)
+	return v, nil
+}
+
+// ToUSD values yoctoNEAR amount in USD.
+func ToUSD(amount decimal.Decimal, nearPrice decimal.Decimal) decimal.Decimal {
+	return amount.Div(decimal.New(1, 24)).Mul(nearPrice).Round(2)
+}
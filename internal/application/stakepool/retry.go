@@ -0,0 +1,245 @@
+package stakepool
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/eteu-technologies/near-api-go/pkg/jsonrpc"
+	"github.com/eteu-technologies/near-api-go/pkg/types"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"lido-near-client/internal/config"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// ErrTimeout is a call without a response, a transaction may have been applied.
+	ErrTimeout      ErrorKind = "timeout"
+	ErrInvalidNonce ErrorKind = "invalid nonce"
+	ErrExpiredTx    ErrorKind = "expired transaction"
+	ErrRateLimited  ErrorKind = "rate limited"
+	ErrUnknownBlock ErrorKind = "unknown block"
+	// ErrUnknownTx is a transaction the node has not seen or not finished yet.
+	ErrUnknownTx ErrorKind = "unknown transaction"
+	// ErrUnavailable is a node which could not be reached, the request was not processed.
+	ErrUnavailable   ErrorKind = "node unavailable"
+	ErrContractPanic ErrorKind = "contract panic"
+	// ErrPermanent is any other error, it is not retried.
+	ErrPermanent ErrorKind = "permanent"
+)
+
+type (
+	// ErrorKind classifies RPC and contract errors to decide whether a call is retried.
+	ErrorKind string
+	// ChainError is an error of a chain call with its kind.
+	ChainError struct {
+		Kind ErrorKind
+		Err  error
+	}
+	// retrier repeats calls failed with transient errors with an exponential backoff and jitter.
+	retrier struct {
+		log      *zap.Logger
+		attempts int
+		delay    time.Duration
+		maxDelay time.Duration
+	}
+	// retryClient retries view calls of the client. Transactions are passed through, chainExecutor retries
+	// them since a new nonce has to be signed after an invalid nonce error.
+	retryClient struct {
+		ChainClient
+		retry *retrier
+	}
+)
+
+// errorPatterns are lower-cased fragments of near-api-go and node error messages by kind, checked in order.
+var errorPatterns = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{ErrContractPanic, []string{"smart contract panicked", "guestpanic", "wasm execution failed", "functioncallerror", "methodnotfound"}},
+	{ErrInvalidNonce, []string{"invalidnonce", "invalid nonce"}},
+	{ErrExpiredTx, []string{"expired"}},
+	{ErrRateLimited, []string{"too many requests", "rate limit"}},
+	{ErrUnknownTx, []string{"unknown_transaction", "doesn't exist"}},
+	{ErrUnknownBlock, []string{"unknown_block", "unknown block", "db not found error"}},
+	// a dropped connection or a failed proxy may lose the response of a processed request
+	{ErrTimeout, []string{"timeout_error", "timeout", "timed out", "deadline exceeded", "connection reset", "unexpected eof", "bad gateway"}},
+	{ErrUnavailable, []string{"connection refused", "no such host", "service unavailable"}},
+}
+
+func (e *ChainError) Error() string {
+	if e.Kind == ErrPermanent {
+		return e.Err.Error()
+	}
+	return string(e.Kind) + ": " + e.Err.Error()
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether a call failed with the kind may succeed if it is repeated.
+func (k ErrorKind) Transient() bool {
+	return k != ErrContractPanic && k != ErrPermanent
+}
+
+// ClassifyError returns the kind of an error of a chain call.
+func ClassifyError(err error) ErrorKind {
+	var chainErr *ChainError
+	if errors.As(err, &chainErr) {
+		return chainErr.Kind
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrUnavailable
+	}
+	var rpcErr *jsonrpc.Error
+	if errors.As(err, &rpcErr) && (rpcErr.Code == 429 || rpcErr.Code == -429) {
+		return ErrRateLimited
+	}
+	msg := strings.ToLower(err.Error())
+	for _, p := range errorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(msg, pattern) {
+				return p.kind
+			}
+		}
+	}
+	return ErrPermanent
+}
+
+// classify wraps the error into a ChainError with its kind.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ChainError{Kind: ClassifyError(err), Err: err}
+}
+
+func newRetrier(log *zap.Logger, cfg config.RetryConfig) *retrier {
+	r := &retrier{log: log, attempts: cfg.Attempts, delay: cfg.Delay, maxDelay: cfg.MaxDelay}
+	if r.attempts < 1 {
+		r.attempts = 1
+	}
+	if r.maxDelay < r.delay {
+		r.maxDelay = r.delay
+	}
+	return r
+}
+
+// do calls fn until it succeeds, fails with an error of a kind not accepted by retryable or runs out of
+// attempts. The returned error is a ChainError.
+func (r *retrier) do(ctx context.Context, op string, retryable func(ErrorKind) bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := classify(fn())
+		if err == nil {
+			return nil
+		}
+		kind := ClassifyError(err)
+		if !retryable(kind) {
+			return err
+		}
+		if attempt >= r.attempts {
+			if attempt == 1 {
+				return err
+			}
+			return errors.Wrapf(err, "%s: gave up after %d attempts", op, attempt)
+		}
+		delay := r.backoff(attempt)
+		r.log.Warn(op+": retry", zap.String("kind", string(kind)), zap.Int("attempt", attempt),
+			zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Wrap(err, ctx.Err().Error())
+		}
+	}
+}
+
+// backoff doubles the delay with every attempt up to the max and picks a random delay in its upper half.
+func (r *retrier) backoff(attempt int) time.Duration {
+	d := r.delay
+	for i := 1; i < attempt && d < r.maxDelay; i++ {
+		d *= 2
+	}
+	if d > r.maxDelay {
+		d = r.maxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// isTransient retries view calls on any transient error.
+func isTransient(kind ErrorKind) bool {
+	return kind.Transient()
+}
+
+// isResendable retries transactions on transient errors which guarantee the transaction was not applied.
+// A transaction which timed out may still land, it is not signed again with a new nonce.
+func isResendable(kind ErrorKind) bool {
+	return kind.Transient() && kind != ErrTimeout
+}
+
+func newRetryClient(cli ChainClient, retry *retrier) *retryClient {
+	return &retryClient{ChainClient: cli, retry: retry}
+}
+
+func (c *retryClient) ContractViewCallFunction(ctx context.Context, accountID, methodName, argsBase64 string, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = c.retry.do(ctx, "ContractViewCallFunction("+methodName+")", isTransient, func() (err error) {
+		res, err = c.ChainClient.ContractViewCallFunction(ctx, accountID, methodName, argsBase64, block)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryClient) AccessKeyView(ctx context.Context, accountID types.AccountID, publicKey key.Base58PublicKey, block block.BlockCharacteristic) (res client.AccessKeyView, err error) {
+	err = c.retry.do(ctx, "AccessKeyView", isTransient, func() (err error) {
+		res, err = c.ChainClient.AccessKeyView(ctx, accountID, publicKey, block)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryClient) AccountView(ctx context.Context, accountID types.AccountID, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = c.retry.do(ctx, "AccountView", isTransient, func() (err error) {
+		res, err = c.ChainClient.AccountView(ctx, accountID, block)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryClient) BlockDetails(ctx context.Context, block block.BlockCharacteristic) (res client.BlockView, err error) {
+	err = c.retry.do(ctx, "BlockDetails", isTransient, func() (err error) {
+		res, err = c.ChainClient.BlockDetails(ctx, block)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryClient) GenesisConfig(ctx context.Context) (res jsonrpc.Response, err error) {
+	err = c.retry.do(ctx, "GenesisConfig", isTransient, func() (err error) {
+		res, err = c.ChainClient.GenesisConfig(ctx)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryClient) NetworkStatusValidatorsDetailed(ctx context.Context, block block.BlockCharacteristic) (res jsonrpc.Response, err error) {
+	err = c.retry.do(ctx, "NetworkStatusValidatorsDetailed", isTransient, func() (err error) {
+		res, err = c.ChainClient.NetworkStatusValidatorsDetailed(ctx, block)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,77 @@
+package stakepool
+
+import (
+	"context"
+	"github.com/eteu-technologies/near-api-go/pkg/jsonrpc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"lido-near-client/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{errors.Wrap(context.DeadlineExceeded, "CallRPC"), ErrTimeout},
+		{&jsonrpc.Error{Code: -32000, Message: "Server error", Data: []byte(`"Timeout"`)}, ErrTimeout},
+		{&jsonrpc.Error{Code: -32000, Message: "Server error", Data: []byte(`{"TxExecutionError":{"InvalidTxError":{"InvalidNonce":{"ak_nonce":5,"tx_nonce":5}}}}`)}, ErrInvalidNonce},
+		{&jsonrpc.Error{Code: -32000, Message: "Server error", Data: []byte(`{"TxExecutionError":{"InvalidTxError":"Expired"}}`)}, ErrExpiredTx},
+		{&jsonrpc.Error{Code: -429, Message: "Rate limited"}, ErrRateLimited},
+		{errors.New("429 Too Many Requests"), ErrRateLimited},
+		{errors.New("DB Not Found Error: BLOCK HEIGHT: 100"), ErrUnknownBlock},
+		{errors.New(`dial tcp 127.0.0.1:3030: connect: connection refused`), ErrUnavailable},
+		{errors.New("wasm execution failed with error: FunctionCallError(HostError(GuestPanic { panic_msg: \"not found\" }))"), ErrContractPanic},
+		{errors.New("json: cannot unmarshal string into Go value of type uint64"), ErrPermanent},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	r := newRetrier(zap.NewNop(), config.RetryConfig{Attempts: 3, Delay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+	calls := 0
+	err := r.do(context.Background(), "op", isTransient, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("transient: %d calls, error %v", calls, err)
+	}
+
+	calls = 0
+	err = r.do(context.Background(), "op", isTransient, func() error {
+		calls++
+		return errors.New("Too Many Requests")
+	})
+	if calls != 3 || !strings.Contains(err.Error(), "gave up after 3 attempts") || ClassifyError(err) != ErrRateLimited {
+		t.Errorf("exhausted: %d calls, error %v", calls, err)
+	}
+
+	calls = 0
+	err = r.do(context.Background(), "op", isTransient, func() error {
+		calls++
+		return errors.New("Smart contract panicked: not enough balance")
+	})
+	if calls != 1 || ClassifyError(err) != ErrContractPanic {
+		t.Errorf("permanent: %d calls, error %v", calls, err)
+	}
+
+	calls = 0
+	_ = r.do(context.Background(), "op", isResendable, func() error {
+		calls++
+		return errors.New("timeout")
+	})
+	if calls != 1 {
+		t.Errorf("timed out transaction sent %d times, want once", calls)
+	}
+}
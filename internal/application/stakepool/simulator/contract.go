@@ -0,0 +1,346 @@
+package simulator
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	classicStakeDecreasingType    = "Classic"
+	investmentStakeDecreasingType = "Investment"
+
+	unstakeEpochs = 4
+)
+
+type (
+	// Validator is a validator registry entry of the stake pool contract.
+	Validator struct {
+		AccountID                             string          `json:"account_id"`
+		ClassicStakedBalance                  decimal.Decimal `json:"classic_staked_balance"`
+		InvestmentStakedBalance               decimal.Decimal `json:"investment_staked_balance"`
+		UnstakedBalance                       decimal.Decimal `json:"unstaked_balance"`
+		IsOnlyForInvestment                   bool            `json:"is_only_for_investment"`
+		LastUpdateEpochHeight                 uint64          `json:"last_update_epoch_height"`
+		LastClassicStakeIncreasingEpochHeight *uint64         `json:"last_classic_stake_increasing_epoch_height"`
+
+		// RewardRate is a share of staked balance the validator earns per epoch.
+		RewardRate decimal.Decimal `json:"-"`
+		// Uptime is a share of expected blocks and chunks the validator produces, it is not active if negative.
+		Uptime float64 `json:"-"`
+		// RewardFee is the get_reward_fee_fraction of the validator staking pool.
+		RewardFee Dividing `json:"-"`
+
+		unstakeEpochHeight uint64
+		// failing makes the validator staking pool reject the calls of the stake pool
+		failing bool
+	}
+	Fund struct {
+		ClassicUnstakedBalance  decimal.Decimal `json:"classic_unstaked_balance"`
+		ClassicStakedBalance    decimal.Decimal `json:"classic_staked_balance"`
+		InvestmentStakedBalance decimal.Decimal `json:"investment_staked_balance"`
+		CommonStakedBalance     decimal.Decimal `json:"common_staked_balance"`
+		CommonBalance           decimal.Decimal `json:"common_balance"`
+	}
+	EpochHeightRegistry struct {
+		PoolEpochHeight    uint64 `json:"pool_epoch_height"`
+		NetworkEpochHeight uint64 `json:"network_epoch_height"`
+	}
+	RequestedToWithdrawalFund struct {
+		ClassicNearAmount            decimal.Decimal `json:"classic_near_amount"`
+		InvestmentNearAmount         decimal.Decimal `json:"investment_near_amount"`
+		InvestmentWithdrawalRegistry [][]interface{} `json:"investment_withdrawal_registry"`
+	}
+	Dividing struct {
+		Numerator   uint64 `json:"numerator"`
+		Denominator uint64 `json:"denominator"`
+	}
+	AggInfo struct {
+		UnstakedBalance                      decimal.Decimal `json:"unstaked_balance"`
+		StakedBalance                        decimal.Decimal `json:"staked_balance"`
+		TokenTotalSupply                     decimal.Decimal `json:"token_total_supply"`
+		TokenAccountsQuantity                uint64          `json:"token_accounts_quantity"`
+		TotalRewardsFromValidatorsNearAmount decimal.Decimal `json:"total_rewards_from_validators_near_amount"`
+		RewardFee                            *Dividing       `json:"reward_fee"`
+	}
+	callbackResult struct {
+		IsSuccess          bool   `json:"is_success"`
+		NetworkEpochHeight uint64 `json:"network_epoch_height"`
+	}
+
+	// Contract models the state of the stake pool contract.
+	Contract struct {
+		NetworkEpochHeight uint64
+		PoolEpochHeight    uint64
+		IsStakeDistributed bool
+		Validators         []*Validator
+		ClassicUnstaked    decimal.Decimal
+		RequestedClassic   decimal.Decimal
+		// RequestedInvestment maps validator account id to requested investment amount.
+		RequestedInvestment map[string]decimal.Decimal
+		// Withdrawn is a balance taken back from validators and ready to be paid to users.
+		Withdrawn decimal.Decimal
+
+		TokenTotalSupply decimal.Decimal
+		TokenAccounts    uint64
+		TotalRewards     decimal.Decimal
+		// RewardFee is a share of rewards minted as tokens to the pool owner.
+		RewardFee Dividing
+	}
+)
+
+func (c *Contract) validator(accountID string) (*Validator, error) {
+	for _, v := range c.Validators {
+		if v.AccountID == accountID {
+			return v, nil
+		}
+	}
+	return nil, errors.Errorf("validator %s is not registered", accountID)
+}
+
+func (c *Contract) fund() Fund {
+	var f Fund
+	for _, v := range c.Validators {
+		f.ClassicStakedBalance = f.ClassicStakedBalance.Add(v.ClassicStakedBalance)
+		f.InvestmentStakedBalance = f.InvestmentStakedBalance.Add(v.InvestmentStakedBalance)
+	}
+	f.ClassicUnstakedBalance = c.ClassicUnstaked
+	f.CommonStakedBalance = f.ClassicStakedBalance.Add(f.InvestmentStakedBalance)
+	f.CommonBalance = f.CommonStakedBalance.Add(f.ClassicUnstakedBalance)
+	return f
+}
+
+func (c *Contract) requestedToWithdrawalFund() RequestedToWithdrawalFund {
+	f := RequestedToWithdrawalFund{
+		ClassicNearAmount:            c.RequestedClassic,
+		InvestmentWithdrawalRegistry: [][]interface{}{},
+	}
+	for _, v := range c.Validators {
+		amount, ok := c.RequestedInvestment[v.AccountID]
+		if !ok {
+			continue
+		}
+		f.InvestmentNearAmount = f.InvestmentNearAmount.Add(amount)
+		f.InvestmentWithdrawalRegistry = append(f.InvestmentWithdrawalRegistry, []interface{}{v.AccountID, amount.String()})
+	}
+	return f
+}
+
+func (c *Contract) aggInfo() AggInfo {
+	f := c.fund()
+	fee := c.RewardFee
+	return AggInfo{
+		UnstakedBalance:                      f.ClassicUnstakedBalance,
+		StakedBalance:                        f.ClassicStakedBalance.Sub(c.RequestedClassic),
+		TokenTotalSupply:                     c.TokenTotalSupply,
+		TokenAccountsQuantity:                c.TokenAccounts,
+		TotalRewardsFromValidatorsNearAmount: c.TotalRewards,
+		RewardFee:                            &fee,
+	}
+}
+
+// tokenBalance is the classic balance backing pool tokens.
+func (c *Contract) tokenBalance() decimal.Decimal {
+	f := c.fund()
+	return f.ClassicStakedBalance.Add(f.ClassicUnstakedBalance).Sub(c.RequestedClassic)
+}
+
+// tokensFor converts NEAR amount to pool tokens at the current exchange rate.
+func (c *Contract) tokensFor(amount decimal.Decimal) decimal.Decimal {
+	balance := c.tokenBalance()
+	if c.TokenTotalSupply.IsZero() || balance.IsZero() {
+		return amount
+	}
+	return amount.Mul(c.TokenTotalSupply).Div(balance).Truncate(0)
+}
+
+func (c *Contract) view(method string) (interface{}, error) {
+	switch method {
+	case "get_current_epoch_height":
+		return EpochHeightRegistry{PoolEpochHeight: c.PoolEpochHeight, NetworkEpochHeight: c.NetworkEpochHeight}, nil
+	case "get_validator_registry":
+		return c.Validators, nil
+	case "get_fund":
+		return c.fund(), nil
+	case "get_requested_to_withdrawal_fund":
+		return c.requestedToWithdrawalFund(), nil
+	case "is_stake_distributed":
+		return c.IsStakeDistributed, nil
+	case "get_aggregated_info":
+		return c.aggInfo(), nil
+	}
+	return nil, errors.Errorf("MethodNotFound: %s", method)
+}
+
+type callArgs struct {
+	ValidatorAccountID  string          `json:"validator_account_id"`
+	NearAmount          decimal.Decimal `json:"near_amount"`
+	StakeDecreasingType string          `json:"stake_decreasing_type"`
+}
+
+// call applies a change method and returns its result. A returned error is a contract panic.
+func (c *Contract) call(method string, rawArgs []byte) (interface{}, error) {
+	var args callArgs
+	if len(rawArgs) != 0 {
+		if err := json.Unmarshal(rawArgs, &args); err != nil {
+			return nil, errors.Wrap(err, "invalid args")
+		}
+	}
+	if v, err := c.validator(args.ValidatorAccountID); err == nil && v.failing {
+		// the callback of a rejected cross-contract call reports the failure and leaves the state unchanged
+		switch method {
+		case "increase_validator_stake":
+			return false, nil
+		case "update_validator", "take_unstaked_balance", "requested_decrease_validator_stake":
+			return callbackResult{IsSuccess: false, NetworkEpochHeight: c.NetworkEpochHeight}, nil
+		}
+	}
+	switch method {
+	case "update_validator":
+		return c.updateValidator(args)
+	case "update":
+		return nil, c.update()
+	case "increase_validator_stake":
+		return c.increaseValidatorStake(args)
+	case "confirm_stake_distribution":
+		return nil, c.confirmStakeDistribution()
+	case "requested_decrease_validator_stake":
+		return c.requestedDecreaseValidatorStake(args)
+	case "take_unstaked_balance":
+		return c.takeUnstakedBalance(args)
+	}
+	return nil, errors.Errorf("MethodNotFound: %s", method)
+}
+
+func (c *Contract) updateValidator(args callArgs) (interface{}, error) {
+	v, err := c.validator(args.ValidatorAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if v.LastUpdateEpochHeight >= c.NetworkEpochHeight {
+		return nil, errors.Errorf("validator %s is already updated", v.AccountID)
+	}
+	var rewards decimal.Decimal
+	for e := v.LastUpdateEpochHeight; e < c.NetworkEpochHeight; e++ {
+		classic := v.ClassicStakedBalance.Mul(v.RewardRate).Truncate(0)
+		investment := v.InvestmentStakedBalance.Mul(v.RewardRate).Truncate(0)
+		v.ClassicStakedBalance = v.ClassicStakedBalance.Add(classic)
+		v.InvestmentStakedBalance = v.InvestmentStakedBalance.Add(investment)
+		rewards = rewards.Add(classic)
+		c.TotalRewards = c.TotalRewards.Add(classic).Add(investment)
+	}
+	if rewards.IsPositive() && c.RewardFee.Denominator != 0 {
+		// fee tokens are minted at the rate after rewards, so holders keep (1 - fee) of rewards
+		fee := rewards.Mul(decimal.New(int64(c.RewardFee.Numerator), 0)).Div(decimal.New(int64(c.RewardFee.Denominator), 0))
+		balance := c.tokenBalance()
+		if !c.TokenTotalSupply.IsZero() && balance.GreaterThan(fee) {
+			c.TokenTotalSupply = c.TokenTotalSupply.Add(fee.Mul(c.TokenTotalSupply).Div(balance.Sub(fee)).Truncate(0))
+		}
+	}
+	v.LastUpdateEpochHeight = c.NetworkEpochHeight
+	return callbackResult{IsSuccess: true, NetworkEpochHeight: c.NetworkEpochHeight}, nil
+}
+
+func (c *Contract) update() error {
+	if c.PoolEpochHeight >= c.NetworkEpochHeight {
+		return errors.New("pool is already updated")
+	}
+	for _, v := range c.Validators {
+		if v.LastUpdateEpochHeight != c.NetworkEpochHeight {
+			return errors.Errorf("validator %s is not updated", v.AccountID)
+		}
+	}
+	c.PoolEpochHeight = c.NetworkEpochHeight
+	c.IsStakeDistributed = false
+	return nil
+}
+
+func (c *Contract) increaseValidatorStake(args callArgs) (interface{}, error) {
+	if c.PoolEpochHeight != c.NetworkEpochHeight {
+		return nil, errors.New("pool is not updated")
+	}
+	if c.IsStakeDistributed {
+		return nil, errors.New("stake is already distributed")
+	}
+	v, err := c.validator(args.ValidatorAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsOnlyForInvestment {
+		return nil, errors.Errorf("validator %s is only for investment", v.AccountID)
+	}
+	if v.LastClassicStakeIncreasingEpochHeight != nil && *v.LastClassicStakeIncreasingEpochHeight >= c.PoolEpochHeight {
+		return nil, errors.Errorf("validator %s stake is already increased", v.AccountID)
+	}
+	if !args.NearAmount.IsPositive() || args.NearAmount.GreaterThan(c.ClassicUnstaked) {
+		return nil, errors.Errorf("invalid near amount %s", args.NearAmount)
+	}
+	c.ClassicUnstaked = c.ClassicUnstaked.Sub(args.NearAmount)
+	v.ClassicStakedBalance = v.ClassicStakedBalance.Add(args.NearAmount)
+	epoch := c.PoolEpochHeight
+	v.LastClassicStakeIncreasingEpochHeight = &epoch
+	return true, nil
+}
+
+func (c *Contract) confirmStakeDistribution() error {
+	if c.PoolEpochHeight != c.NetworkEpochHeight {
+		return errors.New("pool is not updated")
+	}
+	if c.IsStakeDistributed {
+		return errors.New("stake is already distributed")
+	}
+	c.IsStakeDistributed = true
+	return nil
+}
+
+func (c *Contract) requestedDecreaseValidatorStake(args callArgs) (interface{}, error) {
+	if c.PoolEpochHeight >= c.NetworkEpochHeight {
+		return nil, errors.New("pool is already updated")
+	}
+	v, err := c.validator(args.ValidatorAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if v.LastUpdateEpochHeight != c.NetworkEpochHeight {
+		return nil, errors.Errorf("validator %s is not updated", v.AccountID)
+	}
+	if !args.NearAmount.IsPositive() {
+		return nil, errors.Errorf("invalid near amount %s", args.NearAmount)
+	}
+	switch args.StakeDecreasingType {
+	case classicStakeDecreasingType:
+		if args.NearAmount.GreaterThan(v.ClassicStakedBalance) || args.NearAmount.GreaterThan(c.RequestedClassic) {
+			return nil, errors.Errorf("invalid near amount %s", args.NearAmount)
+		}
+		v.ClassicStakedBalance = v.ClassicStakedBalance.Sub(args.NearAmount)
+		c.RequestedClassic = c.RequestedClassic.Sub(args.NearAmount)
+	case investmentStakeDecreasingType:
+		requested := c.RequestedInvestment[v.AccountID]
+		if !args.NearAmount.Equal(requested) || args.NearAmount.GreaterThan(v.InvestmentStakedBalance) {
+			return nil, errors.Errorf("invalid near amount %s", args.NearAmount)
+		}
+		v.InvestmentStakedBalance = v.InvestmentStakedBalance.Sub(args.NearAmount)
+		delete(c.RequestedInvestment, v.AccountID)
+	default:
+		return nil, errors.Errorf("unknown stake decreasing type %s", args.StakeDecreasingType)
+	}
+	v.UnstakedBalance = v.UnstakedBalance.Add(args.NearAmount)
+	v.unstakeEpochHeight = c.NetworkEpochHeight
+	return callbackResult{IsSuccess: true, NetworkEpochHeight: c.NetworkEpochHeight}, nil
+}
+
+func (c *Contract) takeUnstakedBalance(args callArgs) (interface{}, error) {
+	v, err := c.validator(args.ValidatorAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !v.UnstakedBalance.IsPositive() {
+		return nil, errors.Errorf("validator %s has no unstaked balance", v.AccountID)
+	}
+	if c.NetworkEpochHeight < v.unstakeEpochHeight+unstakeEpochs {
+		return nil, errors.Errorf("validator %s unstaked balance is locked", v.AccountID)
+	}
+	c.Withdrawn = c.Withdrawn.Add(v.UnstakedBalance)
+	v.UnstakedBalance = decimal.Zero
+	return callbackResult{IsSuccess: true, NetworkEpochHeight: c.NetworkEpochHeight}, nil
+}
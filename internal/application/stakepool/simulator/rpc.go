@@ -0,0 +1,361 @@
+package simulator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/eteu-technologies/near-api-go/pkg/types/action"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"net/http"
+)
+
+type (
+	rpcRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      string          `json:"id"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+	}
+	rpcError struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	rpcResponse struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      string      `json:"id"`
+		Error   *rpcError   `json:"error,omitempty"`
+		Result  interface{} `json:"result,omitempty"`
+	}
+	queryParams struct {
+		RequestType string `json:"request_type"`
+		AccountID   string `json:"account_id"`
+		MethodName  string `json:"method_name"`
+		ArgsBase64  string `json:"args_base64"`
+		PublicKey   string `json:"public_key"`
+	}
+	executionStatus struct {
+		SuccessValue     *string     `json:"SuccessValue,omitempty"`
+		SuccessReceiptID string      `json:"SuccessReceiptId,omitempty"`
+		Failure          interface{} `json:"Failure,omitempty"`
+	}
+)
+
+func (s *Simulator) serveRPC(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests[req.Method]++
+	result, err := s.handle(req.Method, req.Params)
+	s.mu.Unlock()
+	resp := rpcResponse{JSONRPC: req.JSONRPC, ID: req.ID, Result: result}
+	if err != nil {
+		data, _ := json.Marshal(err.Error())
+		resp = rpcResponse{JSONRPC: req.JSONRPC, ID: req.ID, Error: &rpcError{
+			Code:    -32000,
+			Message: "Server error",
+			Data:    data,
+		}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Simulator) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "query":
+		var p queryParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errors.Wrap(err, "params")
+		}
+		return s.query(p)
+	case "block":
+		var p struct {
+			BlockID json.RawMessage `json:"block_id"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errors.Wrap(err, "params")
+		}
+		height, err := s.blockHeight(p.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		prev := height - 1
+		for s.skipped[prev] {
+			prev--
+		}
+		return map[string]interface{}{
+			"author": "simulator",
+			"header": map[string]interface{}{
+				"height":    height,
+				"hash":      s.blockHashAt(height),
+				"prev_hash": s.blockHashAt(prev),
+				"epoch_id":  s.epochID(height),
+			},
+			"chunks": []interface{}{},
+		}, nil
+	case "status":
+		return map[string]interface{}{
+			"chain_id": "simulator",
+			"sync_info": map[string]interface{}{
+				"latest_block_hash":   s.blockHash(),
+				"latest_block_height": s.height,
+				"syncing":             false,
+			},
+		}, nil
+	case "EXPERIMENTAL_genesis_config":
+		return map[string]interface{}{
+			"epoch_length":                s.params.EpochLength,
+			"genesis_height":              s.params.GenesisHeight,
+			"transaction_validity_period": s.params.TxValidityPeriod,
+		}, nil
+	case "validators":
+		var blockIDs []json.RawMessage
+		if err := json.Unmarshal(params, &blockIDs); err != nil || len(blockIDs) != 1 {
+			return nil, errors.New("invalid params")
+		}
+		height, err := s.blockHeight(blockIDs[0])
+		if err != nil {
+			return nil, err
+		}
+		return s.validators(height), nil
+	case "broadcast_tx_commit":
+		var blobs []string
+		if err := json.Unmarshal(params, &blobs); err != nil || len(blobs) != 1 {
+			return nil, errors.New("invalid params")
+		}
+		return s.broadcastTxCommit(blobs[0])
+	case "tx":
+		var p []string
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 2 {
+			return nil, errors.New("invalid params")
+		}
+		if res, ok := s.outcomes[p[0]]; ok {
+			return res, nil
+		}
+		return nil, errors.Errorf("UNKNOWN_TRANSACTION: transaction %s doesn't exist", p[0])
+	}
+	return nil, errors.Errorf("method %s is not supported", method)
+}
+
+func (s *Simulator) query(p queryParams) (interface{}, error) {
+	switch p.RequestType {
+	case "call_function":
+		var (
+			value interface{}
+			err   error
+		)
+		if p.AccountID == s.params.StakePool {
+			value, err = s.contract.view(p.MethodName)
+		} else if v, verr := s.contract.validator(p.AccountID); verr == nil {
+			value, err = validatorView(v, s.contract.NetworkEpochHeight, p.MethodName)
+		} else {
+			return nil, errors.Errorf("account %s does not exist", p.AccountID)
+		}
+		if err != nil {
+			return map[string]interface{}{
+				"error":        err.Error(),
+				"logs":         []string{},
+				"block_height": s.height,
+				"block_hash":   s.blockHash(),
+			}, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		result := make([]int, len(data))
+		for i, b := range data {
+			result[i] = int(b)
+		}
+		return map[string]interface{}{
+			"result":       result,
+			"logs":         []string{},
+			"block_height": s.height,
+			"block_hash":   s.blockHash(),
+		}, nil
+	case "view_account":
+		return map[string]interface{}{
+			"amount":       s.params.OperatorBalance.String(),
+			"locked":       "0",
+			"block_height": s.height,
+			"block_hash":   s.blockHash(),
+		}, nil
+	case "view_access_key":
+		return map[string]interface{}{
+			"nonce":        s.nonces[p.AccountID+p.PublicKey],
+			"permission":   "FullAccess",
+			"block_height": s.height,
+			"block_hash":   s.blockHash(),
+		}, nil
+	}
+	return nil, errors.Errorf("request type %s is not supported", p.RequestType)
+}
+
+func (s *Simulator) broadcastTxCommit(blob string) (interface{}, error) {
+	txn, txHash, err := decodeTransaction(blob)
+	if err != nil {
+		return nil, errors.Wrap(err, "decodeTransaction")
+	}
+	if txn.SignerID != s.params.Operator {
+		return nil, errors.Errorf("signer %s is not the operator", txn.SignerID)
+	}
+	if txn.ReceiverID != s.params.StakePool {
+		return nil, errors.Errorf("receiver %s does not exist", txn.ReceiverID)
+	}
+	// a transaction sent again returns its outcome
+	if res, ok := s.outcomes[txHash.String()]; ok {
+		return res, nil
+	}
+	nonceKey := txn.SignerID + txn.PublicKey.ToBase58PublicKey().String()
+	if txn.Nonce <= s.nonces[nonceKey] {
+		return nil, errors.Errorf("InvalidNonce: %d", txn.Nonce)
+	}
+	s.nonces[nonceKey] = txn.Nonce
+
+	// actions of one transaction are applied atomically by one receipt. A call with a result schedules a
+	// call of its validator and a callback on the pool returning the result, as the contract does.
+	var (
+		snapshot  = s.snapshot()
+		actionID  = receiptID(txHash, "action")
+		status    executionStatus
+		callbacks []interface{}
+		spawned   []hash.CryptoHash
+	)
+	for i, a := range txn.Actions {
+		call, ok := a.UnderlyingValue().(*action.ActionFunctionCall)
+		if !ok {
+			return nil, errors.Errorf("action %d is not a function call", i)
+		}
+		value, err := s.contract.call(call.MethodName, call.Args)
+		s.calls = append(s.calls, Call{Method: call.MethodName, Args: call.Args, Error: err, TxHash: txHash.String(), PublicKey: nonceKey[len(txn.SignerID):]})
+		if err != nil {
+			s.restore(snapshot)
+			status = executionStatus{Failure: map[string]interface{}{
+				"ActionError": map[string]interface{}{
+					"index": i,
+					"kind": map[string]interface{}{
+						"FunctionCallError": map[string]interface{}{
+							"ExecutionError": fmt.Sprintf("Smart contract panicked: %s", err.Error()),
+						},
+					},
+				},
+			}}
+			callbacks, spawned = nil, nil
+			break
+		}
+		var encoded string
+		status = executionStatus{SuccessValue: &encoded}
+		if value == nil {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		encoded = base64.StdEncoding.EncodeToString(data)
+
+		var args struct {
+			ValidatorAccountID string `json:"validator_account_id"`
+		}
+		_ = json.Unmarshal(call.Args, &args)
+		validatorID, callbackID := receiptID(txHash, fmt.Sprintf("%d:validator", i)), receiptID(txHash, fmt.Sprint(i))
+		empty := ""
+		callbacks = append(callbacks,
+			outcome(validatorID, nil, args.ValidatorAccountID, 0, executionStatus{SuccessValue: &empty}),
+			outcome(callbackID, nil, s.params.StakePool, 0, status))
+		spawned = append(spawned, validatorID, callbackID)
+	}
+	actionStatus := status
+	if n := len(spawned); n != 0 && status.SuccessValue != nil && *status.SuccessValue != "" {
+		// the last call returns the promise of its callback
+		actionStatus = executionStatus{SuccessReceiptID: spawned[n-1].String()}
+	}
+	outcomes := append([]interface{}{
+		outcome(actionID, spawned, s.params.StakePool, gasBurntPerCall*uint64(len(txn.Actions)), actionStatus),
+	}, callbacks...)
+	res := map[string]interface{}{
+		"status": status,
+		"transaction": map[string]interface{}{
+			"signer_id":   txn.SignerID,
+			"receiver_id": txn.ReceiverID,
+			"nonce":       txn.Nonce,
+			"hash":        txHash,
+		},
+		"transaction_outcome": outcome(receiptID(txHash, "transaction"), []hash.CryptoHash{actionID}, txn.SignerID, 0, executionStatus{}),
+		"receipts_outcome":    outcomes,
+	}
+	s.outcomes[txHash.String()] = res
+	return res, nil
+}
+
+func receiptID(txHash hash.CryptoHash, name string) hash.CryptoHash {
+	return hash.NewCryptoHash([]byte(fmt.Sprintf("%s:%s", txHash, name)))
+}
+
+func outcome(id hash.CryptoHash, receiptIDs []hash.CryptoHash, executorID string, gasBurnt uint64, status executionStatus) map[string]interface{} {
+	if receiptIDs == nil {
+		receiptIDs = []hash.CryptoHash{}
+	}
+	return map[string]interface{}{
+		"proof":      []interface{}{},
+		"block_hash": id,
+		"id":         id,
+		"outcome": map[string]interface{}{
+			"logs":         []string{},
+			"receipt_ids":  receiptIDs,
+			"gas_burnt":    gasBurnt,
+			"tokens_burnt": decimal.New(int64(gasBurnt), 0).Mul(decimal.New(gasPrice, 0)).String(),
+			"executor_id":  executorID,
+			"status":       status,
+		},
+	}
+}
+
+// validatorView serves view methods of a validator staking pool contract.
+func validatorView(v *Validator, networkEpochHeight uint64, method string) (interface{}, error) {
+	switch method {
+	case "get_reward_fee_fraction":
+		return v.RewardFee, nil
+	case "is_account_unstaked_balance_available":
+		// the stake pool is the only account of the simulated validators
+		return networkEpochHeight >= v.unstakeEpochHeight+unstakeEpochs, nil
+	}
+	return nil, errors.Errorf("MethodNotFound: %s", method)
+}
+
+const (
+	expectedBlocks = 100
+	expectedChunks = 400
+)
+
+// validators reports the epoch of the block, the validator stats are the same in every epoch.
+func (s *Simulator) validators(height uint64) interface{} {
+	epoch := s.epochOf(height)
+	var current []interface{}
+	for _, v := range s.contract.Validators {
+		if v.Uptime < 0 {
+			continue
+		}
+		current = append(current, map[string]interface{}{
+			"account_id":          v.AccountID,
+			"is_slashed":          false,
+			"stake":               v.ClassicStakedBalance.Add(v.InvestmentStakedBalance).String(),
+			"num_expected_blocks": expectedBlocks,
+			"num_produced_blocks": int(v.Uptime * expectedBlocks),
+			"num_expected_chunks": expectedChunks,
+			"num_produced_chunks": int(v.Uptime * expectedChunks),
+		})
+	}
+	return map[string]interface{}{
+		"current_validators": current,
+		"epoch_height":       epoch,
+		"epoch_start_height": s.epochStart(epoch),
+	}
+}
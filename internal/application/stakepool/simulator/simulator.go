@@ -0,0 +1,334 @@
+// Package simulator implements an in-process NEAR JSON-RPC node hosting a model of the stake pool contract.
+package simulator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/eteu-technologies/borsh-go"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
+	"github.com/eteu-technologies/near-api-go/pkg/types/transaction"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+const (
+	DefaultEpochLength   = 43200
+	DefaultGenesisHeight = 9820210
+	// DefaultTxValidityPeriod is the mainnet transaction validity period in blocks.
+	DefaultTxValidityPeriod = 86400
+
+	gasBurntPerCall = 5_000_000_000_000
+	gasPrice        = 100_000_000
+)
+
+type (
+	Params struct {
+		// StakePool is an account id of the stake pool contract.
+		StakePool string
+		// Operator is an account id allowed to send transactions.
+		Operator        string
+		OperatorBalance decimal.Decimal
+		EpochLength     uint64
+		GenesisHeight   uint64
+		// TxValidityPeriod is how many blocks a transaction can be included after its reference block.
+		TxValidityPeriod uint64
+	}
+	// Call is a change method applied to the contract.
+	Call struct {
+		Method string
+		Args   json.RawMessage
+		Error  error
+		// TxHash is the hash of the transaction the call was an action of.
+		TxHash string
+		// PublicKey is the access key which signed the transaction.
+		PublicKey string
+	}
+	Simulator struct {
+		mu       sync.Mutex
+		server   *httptest.Server
+		params   Params
+		contract *Contract
+		height   uint64
+		nonces   map[string]uint64
+		calls    []Call
+		// outcomes are the results of applied transactions by hash
+		outcomes map[string]interface{}
+		// skipped are the heights without a block
+		skipped map[uint64]bool
+		// heights are the heights of the served block hashes
+		heights map[hash.CryptoHash]uint64
+		// requests counts the served RPC requests by method
+		requests map[string]int
+	}
+)
+
+func New(params Params) *Simulator {
+	if params.EpochLength == 0 {
+		params.EpochLength = DefaultEpochLength
+	}
+	if params.GenesisHeight == 0 {
+		params.GenesisHeight = DefaultGenesisHeight
+	}
+	if params.TxValidityPeriod == 0 {
+		params.TxValidityPeriod = DefaultTxValidityPeriod
+	}
+	s := &Simulator{
+		params: params,
+		contract: &Contract{
+			RequestedInvestment: map[string]decimal.Decimal{},
+		},
+		height:   params.GenesisHeight,
+		nonces:   map[string]uint64{},
+		outcomes: map[string]interface{}{},
+		skipped:  map[uint64]bool{},
+		heights:  map[hash.CryptoHash]uint64{},
+		requests: map[string]int{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveRPC))
+	return s
+}
+
+// URL returns address of the JSON-RPC endpoint.
+func (s *Simulator) URL() string {
+	return s.server.URL
+}
+
+func (s *Simulator) Close() {
+	s.server.Close()
+}
+
+// Update runs fn with exclusive access to the contract state.
+func (s *Simulator) Update(fn func(c *Contract)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.contract)
+}
+
+// AddValidator registers a validator with empty balances updated at the current network epoch.
+func (s *Simulator) AddValidator(accountID string, rewardRate decimal.Decimal, onlyForInvestment bool) {
+	s.Update(func(c *Contract) {
+		c.Validators = append(c.Validators, &Validator{
+			AccountID:             accountID,
+			IsOnlyForInvestment:   onlyForInvestment,
+			LastUpdateEpochHeight: c.NetworkEpochHeight,
+			RewardRate:            rewardRate,
+			Uptime:                1,
+			RewardFee:             Dividing{Numerator: 10, Denominator: 100},
+		})
+	})
+}
+
+// SetUptime sets a share of expected blocks and chunks the validator produces, negative makes it inactive.
+func (s *Simulator) SetUptime(accountID string, uptime float64) {
+	s.Update(func(c *Contract) {
+		for _, v := range c.Validators {
+			if v.AccountID == accountID {
+				v.Uptime = uptime
+			}
+		}
+	})
+}
+
+// SetRewardFee sets the reward fee of the validator staking pool.
+func (s *Simulator) SetRewardFee(accountID string, numerator, denominator uint64) {
+	s.Update(func(c *Contract) {
+		for _, v := range c.Validators {
+			if v.AccountID == accountID {
+				v.RewardFee = Dividing{Numerator: numerator, Denominator: denominator}
+			}
+		}
+	})
+}
+
+// FailCalls makes the validator staking pool reject the calls of the stake pool, so their callbacks report
+// a failure.
+func (s *Simulator) FailCalls(accountID string) {
+	s.Update(func(c *Contract) {
+		for _, v := range c.Validators {
+			if v.AccountID == accountID {
+				v.failing = true
+			}
+		}
+	})
+}
+
+// UseNonce advances the nonce of the operator access key, like a transaction signed by another client.
+func (s *Simulator) UseNonce(publicKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonces[s.params.Operator+publicKey]++
+}
+
+// Deposit adds user deposit waiting for classic stake distribution and mints pool tokens for it.
+func (s *Simulator) Deposit(amount decimal.Decimal) {
+	s.Update(func(c *Contract) {
+		c.TokenTotalSupply = c.TokenTotalSupply.Add(c.tokensFor(amount))
+		c.TokenAccounts++
+		c.ClassicUnstaked = c.ClassicUnstaked.Add(amount)
+	})
+}
+
+// RequestWithdrawal adds a classic withdrawal request and burns pool tokens for it.
+func (s *Simulator) RequestWithdrawal(amount decimal.Decimal) {
+	s.Update(func(c *Contract) {
+		c.TokenTotalSupply = c.TokenTotalSupply.Sub(c.tokensFor(amount))
+		c.RequestedClassic = c.RequestedClassic.Add(amount)
+	})
+}
+
+// RequestInvestmentWithdrawal adds an investment withdrawal request from the validator.
+func (s *Simulator) RequestInvestmentWithdrawal(accountID string, amount decimal.Decimal) {
+	s.Update(func(c *Contract) {
+		c.RequestedInvestment[accountID] = c.RequestedInvestment[accountID].Add(amount)
+	})
+}
+
+// AdvanceEpoch moves the network to the next epoch and sets the block height to its progress point.
+func (s *Simulator) AdvanceEpoch(progress float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contract.NetworkEpochHeight++
+	s.setProgress(progress)
+}
+
+// SetEpochProgress moves the block height inside the current epoch, progress is in [0, 1).
+func (s *Simulator) SetEpochProgress(progress float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setProgress(progress)
+}
+
+// SkipBlock makes the height have no block, as the chain does when a block producer misses its slot.
+func (s *Simulator) SkipBlock(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped[height] = true
+}
+
+func (s *Simulator) setProgress(progress float64) {
+	s.height = s.epochStart(s.contract.NetworkEpochHeight) + uint64(float64(s.params.EpochLength)*progress)
+}
+
+// epochStart returns the first height of the epoch. Like on chain, epochs are counted from the genesis and do
+// not start at multiples of the epoch length.
+func (s *Simulator) epochStart(epoch uint64) uint64 {
+	return s.params.GenesisHeight + epoch*s.params.EpochLength
+}
+
+// epochOf returns the epoch of the height.
+func (s *Simulator) epochOf(height uint64) uint64 {
+	if height < s.params.GenesisHeight {
+		return 0
+	}
+	return (height - s.params.GenesisHeight) / s.params.EpochLength
+}
+
+// Contract returns a copy of the contract state.
+func (s *Simulator) Contract() Contract {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// Fund returns the fund as the contract reports it.
+func (s *Simulator) Fund() Fund {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contract.fund()
+}
+
+// Calls returns all change methods applied to the contract.
+func (s *Simulator) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+func (s *Simulator) snapshot() Contract {
+	c := *s.contract
+	c.Validators = make([]*Validator, len(s.contract.Validators))
+	for i, v := range s.contract.Validators {
+		copied := *v
+		c.Validators[i] = &copied
+	}
+	c.RequestedInvestment = make(map[string]decimal.Decimal, len(s.contract.RequestedInvestment))
+	for k, v := range s.contract.RequestedInvestment {
+		c.RequestedInvestment[k] = v
+	}
+	return c
+}
+
+// Requests returns how many times each RPC method was called.
+func (s *Simulator) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make(map[string]int, len(s.requests))
+	for method, n := range s.requests {
+		requests[method] = n
+	}
+	return requests
+}
+
+func (s *Simulator) restore(c Contract) {
+	*s.contract = c
+}
+
+func (s *Simulator) blockHash() hash.CryptoHash {
+	return s.blockHashAt(s.height)
+}
+
+func (s *Simulator) blockHashAt(height uint64) hash.CryptoHash {
+	b, _ := json.Marshal(height)
+	h := hash.NewCryptoHash(b)
+	s.heights[h] = height
+	return h
+}
+
+// epochID identifies the epoch of the height in block headers.
+func (s *Simulator) epochID(height uint64) hash.CryptoHash {
+	b, _ := json.Marshal(map[string]uint64{"epoch": s.epochOf(height)})
+	return hash.NewCryptoHash(b)
+}
+
+// blockHeight resolves a block_id param, a height or a block hash, nil is the latest block.
+func (s *Simulator) blockHeight(blockID json.RawMessage) (uint64, error) {
+	if len(blockID) == 0 || string(blockID) == "null" {
+		return s.height, nil
+	}
+	var height uint64
+	if err := json.Unmarshal(blockID, &height); err != nil {
+		var h hash.CryptoHash
+		if err = json.Unmarshal(blockID, &h); err != nil {
+			return 0, errors.New("invalid block id")
+		}
+		known, ok := s.heights[h]
+		if !ok {
+			return 0, errors.Errorf("UNKNOWN_BLOCK: block %s is not found", h)
+		}
+		height = known
+	}
+	if height > s.height || s.skipped[height] {
+		return 0, errors.Errorf("UNKNOWN_BLOCK: block %d is not found", height)
+	}
+	return height, nil
+}
+
+// decodeTransaction parses a base64 borsh signed transaction, the signature is not verified.
+func decodeTransaction(blob string) (txn transaction.Transaction, txHash hash.CryptoHash, err error) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return txn, txHash, errors.Wrap(err, "base64")
+	}
+	err = borsh.Deserialize(&txn, data)
+	if err != nil {
+		return txn, txHash, errors.Wrap(err, "borsh.Deserialize")
+	}
+	txHash, _, err = txn.Hash()
+	if err != nil {
+		return txn, txHash, errors.Wrap(err, "txn.Hash")
+	}
+	return txn, txHash, nil
+}
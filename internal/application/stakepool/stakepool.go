@@ -3,12 +3,15 @@ package stakepool
 import (
 	"context"
 	"encoding/json"
-	"github.com/eteu-technologies/near-api-go/pkg/client"
 	"github.com/eteu-technologies/near-api-go/pkg/client/block"
-	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/eteu-technologies/near-api-go/pkg/types/hash"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"lido-near-client/internal/config"
+	"lido-near-client/internal/notify"
+	"sync"
+	"time"
 )
 
 const coinGeckoID = "near"
@@ -18,35 +21,153 @@ type (
 		ctx context.Context
 		log *zap.Logger
 		cfg config.Config
-		cli *client.Client
+		cli ChainClient
 
-		keyPair key.KeyPair
+		executor Executor
+		prices   PriceProvider
+		alerter  Alerter
+		strategy AllocationStrategy
+		unstake  UnstakePolicy
+		// journal is nil unless the default executor journals plans to Cfg.JournalDir
+		journal *Journal
+		// nodes is nil if the client is passed in ServiceParam
+		nodes *nodePool
+
+		statsMu sync.Mutex
+		// stats are the final validator stats of the epoch before the network epoch statsEpoch
+		stats      *networkValidators
+		statsEpoch uint64
+
+		chainMu sync.Mutex
+		genesis *GenesisConfig
+		// epochStart is the first height of the epoch epochID
+		epochID    hash.CryptoHash
+		epochStart uint64
+
+		priceMu sync.Mutex
+		price   decimal.Decimal
+		priceAt time.Time
 	}
 	ServiceParam struct {
 		Ctx context.Context
 		Log *zap.Logger
 		Cfg config.Config
+		// Client overrides the default near-api-go clients of Cfg.Node and Cfg.Nodes.
+		Client ChainClient
+		// Executor overrides the default executor sending plans to the chain.
+		Executor Executor
+		// Observers are notified about transactions sent by the default executor.
+		Observers []Observer
+		// Prices values the pool in USD, optional.
+		Prices PriceProvider
+		// Alerter receives operational alerts, optional.
+		Alerter Alerter
+		// Allocation overrides the allocation strategy selected in Cfg.Allocation.
+		Allocation AllocationStrategy
+		// Unstake overrides the unstake policy selected in Cfg.Unstake.
+		Unstake UnstakePolicy
+	}
+	Alerter interface {
+		Alert(alert notify.Alert)
+		Resolve(key string)
 	}
 )
 
 func New(param ServiceParam) (*Service, error) {
-	node, err := client.NewClient(param.Cfg.Node)
-	if err != nil {
-		return nil, errors.Wrap(err, "create client")
+	node := param.Client
+	var nodes *nodePool
+	if node == nil {
+		var err error
+		nodes, err = newNodePool(param.Log, nodeURLs(param.Cfg.Node, param.Cfg.Nodes), param.Cfg.NodeProbe)
+		if err != nil {
+			return nil, errors.Wrap(err, "create client")
+		}
+		if len(nodes.clients) > 1 {
+			nodes.probe(param.Ctx)
+		}
+		go nodes.run(param.Ctx)
+		node = nodes
 	}
-	keyPair, err := key.NewBase58KeyPair(param.Cfg.KeyPair)
+	keyPairs, err := parseKeyPairs(param.Cfg.KeyPair, param.Cfg.ExtraKeyPairs)
 	if err != nil {
-		return nil, errors.Wrap(err, "NewBase58KeyPair")
+		return nil, errors.Wrap(err, "parseKeyPairs")
+	}
+	strategy := param.Allocation
+	if strategy == nil {
+		strategy, err = NewAllocationStrategy(param.Cfg.Allocation)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewAllocationStrategy")
+		}
+	}
+	unstake := param.Unstake
+	if unstake == nil {
+		unstake, err = NewUnstakePolicy(param.Cfg.Unstake, param.Cfg.Allocation)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewUnstakePolicy")
+		}
+	}
+	retry := newRetrier(param.Log, param.Cfg.Retry)
+	var journal *Journal
+	executor := param.Executor
+	if executor == nil && param.Cfg.JournalDir != "" {
+		journal, err = NewJournal(param.Cfg.JournalDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewJournal")
+		}
+	}
+	if executor == nil {
+		concurrency := param.Cfg.MaxConcurrentTxs
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		executor = &chainExecutor{
+			ctx:         param.Ctx,
+			log:         param.Log,
+			cli:         node,
+			keys:        newKeyPool(node, param.Cfg.KeyPairAccountID, keyPairs),
+			signer:      param.Cfg.KeyPairAccountID,
+			pool:        param.Cfg.StakePool,
+			observers:   param.Observers,
+			batch:       param.Cfg.Batch,
+			concurrency: concurrency,
+			retry:       retry,
+		}
 	}
 	return &Service{
-		ctx:     param.Ctx,
-		log:     param.Log,
-		cfg:     param.Cfg,
-		cli:     &node,
-		keyPair: keyPair,
+		ctx:      param.Ctx,
+		log:      param.Log,
+		cfg:      param.Cfg,
+		cli:      newRetryClient(node, retry),
+		executor: executor,
+		prices:   param.Prices,
+		alerter:  param.Alerter,
+		strategy: strategy,
+		unstake:  unstake,
+		journal:  journal,
+		nodes:    nodes,
 	}, nil
 }
 
+// Nodes returns the health of the RPC endpoints, nil if the client is passed in ServiceParam.
+func (s *Service) Nodes() []NodeHealth {
+	if s.nodes == nil {
+		return nil
+	}
+	return s.nodes.Nodes()
+}
+
+func (s *Service) alert(alert notify.Alert) {
+	if s.alerter != nil {
+		s.alerter.Alert(alert)
+	}
+}
+
+func (s *Service) resolve(key string) {
+	if s.alerter != nil {
+		s.alerter.Resolve(key)
+	}
+}
+
 type callContractResponse struct {
 	BlockHash   string `json:"block_hash"`
 	BlockHeight uint64 `json:"block_height"`
@@ -55,9 +176,14 @@ type callContractResponse struct {
 }
 
 func (s *Service) callContract(method string, args string) (result json.RawMessage, err error) {
+	return s.callAccountContract(s.cfg.StakePool, method, args)
+}
+
+// callAccountContract calls a view method of any contract, e.g. a validator staking pool.
+func (s *Service) callAccountContract(accountID, method string, args string) (result json.RawMessage, err error) {
 	resp, err := s.cli.ContractViewCallFunction(
 		context.Background(),
-		s.cfg.StakePool,
+		accountID,
 		method,
 		args,
 		block.FinalityFinal(),
@@ -71,7 +197,7 @@ func (s *Service) callContract(method string, args string) (result json.RawMessa
 		return result, errors.Wrap(err, "json unmarshal")
 	}
 	if r.Error != "" {
-		return result, errors.New(r.Error)
+		return result, classify(errors.New(r.Error))
 	}
 	return r.Result, nil
 }
@@ -0,0 +1,156 @@
+package stakepool
+
+import (
+	"encoding/json"
+	"github.com/eteu-technologies/near-api-go/pkg/client"
+	"github.com/eteu-technologies/near-api-go/pkg/client/block"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type (
+	// Status is a read-only snapshot of the pool.
+	Status struct {
+		Epochs                    EpochHeightRegistry       `json:"epochs"`
+		EpochProgress             EpochProgress             `json:"epoch_progress"`
+		IsStakeDistributed        bool                      `json:"is_stake_distributed"`
+		Fund                      Fund                      `json:"fund"`
+		RequestedToWithdrawalFund RequestedToWithdrawalFund `json:"requested_to_withdrawal_fund"`
+		AggInfo                   AggInfo                   `json:"agg_info"`
+		Validators                []Validator               `json:"validators"`
+		OperatorAccountID         string                    `json:"operator_account_id"`
+		OperatorBalance           decimal.Decimal           `json:"operator_balance"`
+		// Valuation is nil if no price provider is configured or the price is unavailable.
+		Valuation *Valuation `json:"valuation,omitempty"`
+		// Nodes is the health of the RPC endpoints.
+		Nodes []NodeHealth `json:"nodes,omitempty"`
+		// CatchUp is set if the pool missed several epochs.
+		CatchUp *CatchUp `json:"catch_up,omitempty"`
+	}
+	EpochProgress struct {
+		BlockHeight uint64 `json:"block_height"`
+		// EpochStartHeight is the first block of the epoch, epochs do not start at multiples of the length.
+		EpochStartHeight uint64 `json:"epoch_start_height"`
+		EpochLength      uint64 `json:"epoch_length"`
+		// Position is a number of blocks passed since the epoch start.
+		Position uint64  `json:"position"`
+		Progress float64 `json:"progress"`
+		// DistributionWindow is the number of the last blocks of the epoch IncreaseStake runs in.
+		DistributionWindow uint64 `json:"distribution_window"`
+	}
+)
+
+// Status fetches the pool state from the contract and the network.
+func (s *Service) Status() (status Status, err error) {
+	status.Epochs, status.Validators, err = s.getEpochsAndValidators()
+	if err != nil {
+		return status, errors.Wrap(err, "getEpochsAndValidators")
+	}
+	status.CatchUp = newCatchUp(status.Epochs)
+	status.Fund, err = s.GetFund()
+	if err != nil {
+		return status, errors.Wrap(err, "GetFund")
+	}
+	status.RequestedToWithdrawalFund, err = s.GetRequestedToWithdrawalFund()
+	if err != nil {
+		return status, errors.Wrap(err, "GetRequestedToWithdrawalFund")
+	}
+	status.IsStakeDistributed, err = s.IsStakeDistributed()
+	if err != nil {
+		return status, errors.Wrap(err, "IsStakeDistributed")
+	}
+	status.AggInfo, err = s.GetAggInfo()
+	if err != nil {
+		return status, errors.Wrap(err, "GetAggInfo")
+	}
+	status.EpochProgress, err = s.GetEpochProgress()
+	if err != nil {
+		return status, errors.Wrap(err, "GetEpochProgress")
+	}
+	status.OperatorAccountID = s.cfg.KeyPairAccountID
+	status.OperatorBalance, err = s.getOperatorBalance()
+	if err != nil {
+		return status, errors.Wrap(err, "getOperatorBalance")
+	}
+	status.Nodes = s.Nodes()
+	if s.prices != nil {
+		valuation, err := s.getValuation(status.Fund, status.AggInfo)
+		if err != nil {
+			s.log.Warn("Status: no valuation", zap.Error(err))
+			return status, nil
+		}
+		status.Valuation = &valuation
+	}
+	return status, nil
+}
+
+// GetEpochProgress returns the position of the latest final block in its epoch.
+func (s *Service) GetEpochProgress() (progress EpochProgress, err error) {
+	genesis, err := s.getGenesisCfg()
+	if err != nil {
+		return progress, errors.Wrap(err, "getGenesisConfig")
+	}
+	latestBlock, err := s.cli.BlockDetails(s.ctx, block.FinalityFinal())
+	if err != nil {
+		return progress, errors.Wrap(err, "BlockDetails")
+	}
+	epochStart, err := s.getEpochStartHeight(latestBlock.Header)
+	if err != nil {
+		return progress, errors.Wrap(err, "getEpochStartHeight")
+	}
+	progress = EpochProgress{
+		BlockHeight:      latestBlock.Header.Height,
+		EpochStartHeight: epochStart,
+		EpochLength:      genesis.EpochLength,
+	}
+	if progress.BlockHeight > progress.EpochStartHeight {
+		progress.Position = progress.BlockHeight - progress.EpochStartHeight
+	}
+	if progress.Position >= progress.EpochLength {
+		// the epoch runs over its length until the next epoch block is final
+		progress.Position = progress.EpochLength - 1
+	}
+	progress.Progress = float64(progress.Position) / float64(progress.EpochLength)
+	window := s.cfg.Schedule.DistributionWindow
+	if window <= 0 || window > 1 {
+		window = DefaultDistributionWindow
+	}
+	progress.DistributionWindow = uint64(float64(progress.EpochLength) * window)
+	return progress, nil
+}
+
+// getEpochStartHeight returns the first height of the epoch of the block. The network validators are queried
+// at the block once per epoch.
+func (s *Service) getEpochStartHeight(header client.BlockHeaderView) (uint64, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.epochStart != 0 && s.epochID == header.EpochID {
+		return s.epochStart, nil
+	}
+	network, err := s.getNetworkValidators(block.BlockHash(header.Hash))
+	if err != nil {
+		return 0, errors.Wrap(err, "getNetworkValidators")
+	}
+	s.epochID, s.epochStart = header.EpochID, network.EpochStartHeight
+	return s.epochStart, nil
+}
+
+// InDistributionWindow reports whether the block is in the last blocks of the epoch IncreaseStake runs in.
+func (p EpochProgress) InDistributionWindow() bool {
+	return p.Position+p.DistributionWindow >= p.EpochLength
+}
+
+// getOperatorBalance returns balance of the operator account in yoctoNEAR.
+func (s *Service) getOperatorBalance() (decimal.Decimal, error) {
+	accRes, err := s.cli.AccountView(s.ctx, s.cfg.KeyPairAccountID, block.FinalityFinal())
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "AccountView")
+	}
+	var accountView AccountView
+	err = json.Unmarshal(accRes.Result, &accountView)
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "json.Unmarshal(AccountView)")
+	}
+	return accountView.Amount, nil
+}
@@ -28,6 +28,8 @@ type (
 	GenesisConfig struct {
 		EpochLength   uint64 `json:"epoch_length"`
 		GenesisHeight uint64 `json:"genesis_height"`
+		// TransactionValidityPeriod is how many blocks after its reference block a transaction can be included.
+		TransactionValidityPeriod uint64 `json:"transaction_validity_period"`
 	}
 	AggInfo struct {
 		UnstakedBalance                      decimal.Decimal `json:"unstaked_balance"`
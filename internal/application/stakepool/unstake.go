@@ -0,0 +1,298 @@
+package stakepool
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/config"
+	"sort"
+)
+
+const (
+	MostOverweightedPolicy = "most-overweighted"
+	ProportionalPolicy     = "proportional"
+	MinTxPolicy            = "min-tx"
+)
+
+type (
+	// UnstakePolicy selects validators and amounts to cover requested classic withdrawals.
+	UnstakePolicy interface {
+		Name() string
+		// Select returns non-zero unstakes summing exactly to the request amount.
+		Select(req UnstakeRequest) ([]Unstake, error)
+	}
+	UnstakeRequest struct {
+		Amount decimal.Decimal
+		// Validators are the ones holding classic stake.
+		Validators []Validator
+	}
+	Unstake struct {
+		Validator Validator
+		Amount    decimal.Decimal
+		// BelowMinRemaining is set if the validator is left with less than the min remaining stake.
+		BelowMinRemaining bool
+	}
+	// unstakePolicy lowers validator balances, keeping at least minRemaining on every validator while it can.
+	unstakePolicy struct {
+		name string
+		// weights are target weights of most-overweighted, equal if nil
+		weights      map[string]decimal.Decimal
+		minRemaining decimal.Decimal
+	}
+	unstakeCandidate struct {
+		validator Validator
+		weight    decimal.Decimal
+		// capacity is the max unstake amount
+		capacity decimal.Decimal
+	}
+)
+
+// NewUnstakePolicy builds the policy selected in the config. Most-overweighted follows the target weights
+// of the allocation if the target-weights strategy is selected.
+func NewUnstakePolicy(cfg config.UnstakeConfig, allocation config.AllocationConfig) (UnstakePolicy, error) {
+	minRemaining := decimal.NewFromFloat(cfg.MinRemaining).Shift(24)
+	if minRemaining.IsNegative() {
+		return nil, errors.Errorf("negative min remaining stake %s", minRemaining)
+	}
+	switch cfg.Policy {
+	case MostOverweightedPolicy, "":
+		var weights map[string]decimal.Decimal
+		if allocation.Strategy == TargetWeightsStrategy {
+			weights = make(map[string]decimal.Decimal, len(allocation.Weights))
+			for id, w := range allocation.Weights {
+				weights[id] = decimal.NewFromFloat(w)
+			}
+		}
+		return NewMostOverweightedPolicy(weights, minRemaining), nil
+	case ProportionalPolicy:
+		return NewProportionalPolicy(minRemaining), nil
+	case MinTxPolicy:
+		return NewMinTxPolicy(minRemaining), nil
+	}
+	return nil, errors.Errorf("unknown unstake policy %s", cfg.Policy)
+}
+
+// NewMostOverweightedPolicy lowers the highest balances per weight to a common level. Validators without
+// a target weight are unstaked first.
+func NewMostOverweightedPolicy(weights map[string]decimal.Decimal, minRemaining decimal.Decimal) UnstakePolicy {
+	return &unstakePolicy{name: MostOverweightedPolicy, weights: weights, minRemaining: minRemaining}
+}
+
+// NewProportionalPolicy unstakes from every validator in proportion to its classic stake.
+func NewProportionalPolicy(minRemaining decimal.Decimal) UnstakePolicy {
+	return &unstakePolicy{name: ProportionalPolicy, minRemaining: minRemaining}
+}
+
+// NewMinTxPolicy unstakes from the largest validators first, so the fewest transactions are sent.
+func NewMinTxPolicy(minRemaining decimal.Decimal) UnstakePolicy {
+	return &unstakePolicy{name: MinTxPolicy, minRemaining: minRemaining}
+}
+
+func (p *unstakePolicy) Name() string {
+	return p.name
+}
+
+// Select splits the amount within the validator balances above the min remaining stake. If they are not
+// enough, the min is dropped and whole balances can be unstaked.
+func (p *unstakePolicy) Select(req UnstakeRequest) ([]Unstake, error) {
+	total := decimal.Zero
+	for _, v := range req.Validators {
+		total = total.Add(v.ClassicStakedBalance)
+	}
+	if req.Amount.GreaterThan(total) {
+		return nil, errors.Errorf("requested %s exceeds the classic stake %s", req.Amount, total)
+	}
+
+	candidates := make([]unstakeCandidate, 0, len(req.Validators))
+	capacities := decimal.Zero
+	for _, v := range req.Validators {
+		c := unstakeCandidate{
+			validator: v,
+			weight:    decimal.New(1, 0),
+			capacity:  decimal.Max(v.ClassicStakedBalance.Sub(p.minRemaining), decimal.Zero),
+		}
+		if p.weights != nil {
+			c.weight = p.weights[v.AccountID]
+		}
+		capacities = capacities.Add(c.capacity)
+		candidates = append(candidates, c)
+	}
+	if capacities.LessThan(req.Amount) {
+		for i := range candidates {
+			candidates[i].capacity = candidates[i].validator.ClassicStakedBalance
+		}
+	}
+
+	var shares []decimal.Decimal
+	switch p.name {
+	case MostOverweightedPolicy:
+		shares = drainHighest(req.Amount, candidates)
+	case ProportionalPolicy:
+		shares = splitCapped(req.Amount, candidates, func(remains decimal.Decimal, free []int) []decimal.Decimal {
+			balances := decimal.Zero
+			for _, i := range free {
+				balances = balances.Add(candidates[i].validator.ClassicStakedBalance)
+			}
+			split := make([]decimal.Decimal, len(free))
+			for k, i := range free {
+				split[k] = remains.Mul(candidates[i].validator.ClassicStakedBalance).Div(balances).Truncate(0)
+			}
+			return split
+		})
+	default:
+		shares = drainLargest(req.Amount, candidates)
+	}
+
+	var unstakes []Unstake
+	for i, share := range shares {
+		if !share.IsPositive() {
+			continue
+		}
+		remaining := candidates[i].validator.ClassicStakedBalance.Sub(share)
+		unstakes = append(unstakes, Unstake{
+			Validator:         candidates[i].validator,
+			Amount:            share,
+			BelowMinRemaining: remaining.IsPositive() && remaining.LessThan(p.minRemaining),
+		})
+	}
+	return unstakes, nil
+}
+
+// drainHighest unstakes validators without weight first and then lowers the highest balances per weight
+// to a common level.
+func drainHighest(amount decimal.Decimal, candidates []unstakeCandidate) []decimal.Decimal {
+	shares := make([]decimal.Decimal, len(candidates))
+	remains := amount
+	var weighted []int
+	for i, c := range candidates {
+		if c.weight.IsPositive() {
+			weighted = append(weighted, i)
+			continue
+		}
+		shares[i] = decimal.Min(c.capacity, remains)
+		remains = remains.Sub(shares[i])
+	}
+	// sorting (desc) by balance per weight
+	sort.SliceStable(weighted, func(a, b int) bool {
+		return ratio(candidates[weighted[a]]).GreaterThan(ratio(candidates[weighted[b]]))
+	})
+	rest := make([]unstakeCandidate, len(weighted))
+	for k, i := range weighted {
+		rest[k] = candidates[i]
+	}
+	restShares := splitCapped(remains, rest, func(remains decimal.Decimal, free []int) []decimal.Decimal {
+		level := drainLevel(remains, rest, free)
+		split := make([]decimal.Decimal, len(free))
+		for k, i := range free {
+			c := rest[i]
+			split[k] = decimal.Max(c.validator.ClassicStakedBalance.Sub(level.Mul(c.weight)), decimal.Zero).Truncate(0)
+		}
+		return split
+	})
+	for k, i := range weighted {
+		shares[i] = restShares[k]
+	}
+	return shares
+}
+
+// drainLevel returns the balance per weight the free candidates, sorted desc, are lowered to by the amount.
+func drainLevel(amount decimal.Decimal, candidates []unstakeCandidate, free []int) decimal.Decimal {
+	var (
+		balances = decimal.Zero
+		weights  = decimal.Zero
+		level    decimal.Decimal
+	)
+	for k, i := range free {
+		balances = balances.Add(candidates[i].validator.ClassicStakedBalance)
+		weights = weights.Add(candidates[i].weight)
+		level = balances.Sub(amount).Div(weights)
+		if k == len(free)-1 {
+			break
+		}
+		if level.GreaterThanOrEqual(ratio(candidates[free[k+1]])) {
+			break
+		}
+	}
+	return level
+}
+
+func ratio(c unstakeCandidate) decimal.Decimal {
+	return c.validator.ClassicStakedBalance.Div(c.weight)
+}
+
+// drainLargest unstakes whole capacities of the largest validators first.
+func drainLargest(amount decimal.Decimal, candidates []unstakeCandidate) []decimal.Decimal {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return candidates[order[a]].capacity.GreaterThan(candidates[order[b]].capacity)
+	})
+	shares := make([]decimal.Decimal, len(candidates))
+	remains := amount
+	for _, i := range order {
+		shares[i] = decimal.Min(candidates[i].capacity, remains)
+		remains = remains.Sub(shares[i])
+	}
+	return shares
+}
+
+// splitCapped repeats the split of the amount among the candidates until no share exceeds the capacity,
+// the capped candidates are fixed at their capacity. The capacities must cover the amount.
+func splitCapped(amount decimal.Decimal, candidates []unstakeCandidate, split func(remains decimal.Decimal, free []int) []decimal.Decimal) []decimal.Decimal {
+	shares := make([]decimal.Decimal, len(candidates))
+	capped := make([]bool, len(candidates))
+	remains := amount
+	for {
+		var free []int
+		for i := range candidates {
+			if !capped[i] {
+				free = append(free, i)
+			}
+		}
+		if len(free) == 0 {
+			break
+		}
+		if remains.IsZero() {
+			for _, i := range free {
+				shares[i] = decimal.Zero
+			}
+			break
+		}
+		overflow := false
+		for k, share := range split(remains, free) {
+			i := free[k]
+			shares[i] = share
+			if share.GreaterThan(candidates[i].capacity) {
+				shares[i] = candidates[i].capacity
+				capped[i] = true
+				remains = remains.Sub(shares[i])
+				overflow = true
+			}
+		}
+		if !overflow {
+			break
+		}
+	}
+
+	// the rest of the integer division goes to the validators already unstaking, then to anyone with room
+	distributed := decimal.Zero
+	for _, share := range shares {
+		distributed = distributed.Add(share)
+	}
+	rest := amount.Sub(distributed)
+	for _, unstaking := range []bool{true, false} {
+		for i := range shares {
+			if rest.IsZero() {
+				return shares
+			}
+			if shares[i].IsPositive() != unstaking {
+				continue
+			}
+			add := decimal.Min(rest, candidates[i].capacity.Sub(shares[i]))
+			shares[i] = shares[i].Add(add)
+			rest = rest.Sub(add)
+		}
+	}
+	return shares
+}
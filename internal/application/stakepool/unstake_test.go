@@ -0,0 +1,126 @@
+package stakepool
+
+import (
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+func TestUnstakePolicies(t *testing.T) {
+	near := func(amount float64) decimal.Decimal {
+		return decimal.NewFromFloat(amount).Mul(decimal.New(1, 24)).Truncate(0)
+	}
+	validators := func(balances ...float64) (vs []Validator) {
+		for i, b := range balances {
+			vs = append(vs, Validator{AccountID: string(rune('a' + i)), ClassicStakedBalance: near(b)})
+		}
+		return vs
+	}
+	tests := []struct {
+		name       string
+		policy     UnstakePolicy
+		amount     decimal.Decimal
+		validators []Validator
+		want       map[string]decimal.Decimal
+		belowMin   []string
+		wantErr    string
+	}{
+		{
+			name:       "most overweighted lowered to a common level",
+			policy:     NewMostOverweightedPolicy(nil, decimal.Zero),
+			amount:     near(30),
+			validators: validators(100, 80, 90),
+			want:       map[string]decimal.Decimal{"a": near(20), "c": near(10)},
+		},
+		{
+			name:       "validators without a target weight go first",
+			policy:     NewMostOverweightedPolicy(map[string]decimal.Decimal{"a": decimal.NewFromInt(1), "b": decimal.NewFromInt(1)}, decimal.Zero),
+			amount:     near(15),
+			validators: validators(10, 10, 10),
+			want:       map[string]decimal.Decimal{"a": near(2.5), "b": near(2.5), "c": near(10)},
+		},
+		{
+			name:       "proportional",
+			policy:     NewProportionalPolicy(decimal.Zero),
+			amount:     near(20),
+			validators: validators(100, 50, 50),
+			want:       map[string]decimal.Decimal{"a": near(10), "b": near(5), "c": near(5)},
+		},
+		{
+			name:       "proportional keeps the min remaining",
+			policy:     NewProportionalPolicy(near(8)),
+			amount:     near(33),
+			validators: validators(100, 10),
+			want:       map[string]decimal.Decimal{"a": near(31), "b": near(2)},
+		},
+		{
+			name:       "indivisible remainder",
+			policy:     NewProportionalPolicy(decimal.Zero),
+			amount:     decimal.NewFromInt(10),
+			validators: validators(1, 1, 1),
+			want: map[string]decimal.Decimal{
+				"a": decimal.NewFromInt(4), "b": decimal.NewFromInt(3), "c": decimal.NewFromInt(3),
+			},
+		},
+		{
+			name:       "min tx drains the largest first",
+			policy:     NewMinTxPolicy(decimal.Zero),
+			amount:     near(60),
+			validators: validators(10, 50, 30),
+			want:       map[string]decimal.Decimal{"b": near(50), "c": near(10)},
+		},
+		{
+			name:       "min remaining is dropped when it cannot be kept",
+			policy:     NewMinTxPolicy(near(8)),
+			amount:     near(15),
+			validators: validators(10, 10),
+			want:       map[string]decimal.Decimal{"a": near(10), "b": near(5)},
+			belowMin:   []string{"b"},
+		},
+		{
+			name:       "request exceeds the classic stake",
+			policy:     NewMinTxPolicy(decimal.Zero),
+			amount:     near(30),
+			validators: validators(10, 10),
+			wantErr:    "exceeds the classic stake",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unstakes, err := tt.policy.Select(UnstakeRequest{Amount: tt.amount, Validators: tt.validators})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := decimal.Zero
+			got := map[string]decimal.Decimal{}
+			var belowMin []string
+			for _, u := range unstakes {
+				got[u.Validator.AccountID] = u.Amount
+				sum = sum.Add(u.Amount)
+				if u.BelowMinRemaining {
+					belowMin = append(belowMin, u.Validator.AccountID)
+				}
+			}
+			if !sum.Equal(tt.amount) {
+				t.Errorf("unstakes sum %s, want %s", sum, tt.amount)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for id, amount := range tt.want {
+				if !got[id].Equal(amount) {
+					t.Errorf("%s: got %s, want %s", id, got[id], amount)
+				}
+			}
+			if strings.Join(belowMin, ",") != strings.Join(tt.belowMin, ",") {
+				t.Errorf("below min remaining %v, want %v", belowMin, tt.belowMin)
+			}
+		})
+	}
+}
@@ -1,110 +1,208 @@
 package stakepool
 
 import (
-	"encoding/base64"
 	"encoding/json"
-	"github.com/eteu-technologies/near-api-go/pkg/client"
-	"github.com/eteu-technologies/near-api-go/pkg/client/block"
-	"github.com/eteu-technologies/near-api-go/pkg/types"
-	"github.com/eteu-technologies/near-api-go/pkg/types/action"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
-	"sort"
+	"lido-near-client/internal/notify"
 	"time"
 )
 
-var minRebalanceStake = decimal.New(1, 24)
+const (
+	PoolUpdateJob    = "PoolUpdate"
+	IncreaseStakeJob = "IncreaseStake"
 
+	// PoolUnstakePhase is the phase of PoolUpdate planned after the validator updates landed.
+	PoolUnstakePhase = "unstake"
+
+	AlertLowOperatorBalance = "low-operator-balance"
+
+	// DefaultDistributionWindow is the share of the last blocks of an epoch IncreaseStake runs in.
+	DefaultDistributionWindow = 0.15
+)
+
+// MinRebalanceStake is the minimal amount of a single stake increase.
+var MinRebalanceStake = decimal.New(1, 24)
+
+// PoolUpdate updates the validators and the pool to the network epoch. The requested withdrawals are
+// unstaked in a second plan built once the validator updates landed, so the amounts include the rewards
+// of the epoch. If the network epoch moves on while the pool catches up with it, the update is planned
+// again for the new epoch.
 func (s *Service) PoolUpdate() error {
-	err := s.takeUnstakedBalance()
-	if err != nil {
-		return errors.Wrap(err, "takeUnstakedBalance")
+	var results []StepResult
+	for attempt := 1; ; attempt++ {
+		plan, phaseResults, err := s.poolUpdate()
+		results = append(results, phaseResults...)
+		if err != nil && attempt < maxCatchUpPlans && plan.Epochs.NetworkEpochHeight != 0 && s.networkEpochMoved(plan) {
+			s.log.Warn("PoolUpdate: network epoch moved on, plan again", zap.Uint64("network_epoch", plan.Epochs.NetworkEpochHeight),
+				zap.Error(err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
 	}
+	if len(results) != 0 {
+		s.log.Info("Pool updated", zap.Int("transactions", len(results)), zap.String("tx", results[len(results)-1].TxHash))
+	}
+	return nil
+}
 
-	var epochs EpochHeightRegistry
-	err = s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
+// poolUpdate plans and executes the phases of PoolUpdate and returns the last plan.
+func (s *Service) poolUpdate() (plan Plan, results []StepResult, err error) {
+	plan, err = s.planPoolUpdate()
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
+		return plan, nil, errors.Wrap(err, "planPoolUpdate")
 	}
-	if epochs.PoolEpochHeight == epochs.NetworkEpochHeight {
-		s.log.Debug("PoolUpdate: not yet")
-		return nil
+	checked := len(plan.Steps) != 0
+	if checked {
+		err = s.checkOperatorBalance()
+		if err != nil {
+			return plan, nil, errors.Wrap(err, "checkOperatorBalance")
+		}
+	}
+	results, err = s.execute(plan)
+	if err != nil {
+		return plan, results, errors.Wrap(err, "Execute")
+	}
+	if plan.Epochs.PoolEpochHeight >= plan.Epochs.NetworkEpochHeight {
+		return plan, results, nil
 	}
 
-	// check balance
-	accRes, err := s.cli.AccountView(s.ctx, s.cfg.KeyPairAccountID, block.FinalityFinal())
+	plan, err = s.planPoolUnstake()
 	if err != nil {
-		return errors.Wrap(err, "AccountView")
+		return plan, results, errors.Wrap(err, "planPoolUnstake")
 	}
-	var accountView AccountView
-	err = json.Unmarshal(accRes.Result, &accountView)
+	if !checked && len(plan.Steps) != 0 {
+		err = s.checkOperatorBalance()
+		if err != nil {
+			return plan, results, errors.Wrap(err, "checkOperatorBalance")
+		}
+	}
+	unstakeResults, err := s.execute(plan)
+	results = append(results, unstakeResults...)
 	if err != nil {
-		return errors.Wrap(err, "json.Unmarshal(AccountView)")
+		return plan, results, errors.Wrap(err, "Execute")
 	}
-	balance := accountView.Amount.Div(decimal.New(1, 24))
-	if balance.LessThan(decimal.NewFromFloat(0.01)) {
-		// TODO notify
+	return plan, results, nil
+}
+
+// planPoolUpdate plans the first phase of PoolUpdate: taking the withdrawable unstaked balance and updating
+// the validators to the network epoch.
+func (s *Service) planPoolUpdate() (plan Plan, err error) {
+	plan = newPlan(PoolUpdateJob)
+	epochs, validators, err := s.getEpochsAndValidators()
+	if err != nil {
+		return plan, errors.Wrap(err, "getEpochsAndValidators")
+	}
+	plan.Epochs = epochs
+	plan.CatchUp = newCatchUp(epochs)
+	if plan.CatchUp != nil {
+		s.log.Warn("PoolUpdate: catch up", zap.Uint64("pool_epoch", epochs.PoolEpochHeight),
+			zap.Uint64("network_epoch", epochs.NetworkEpochHeight), zap.Uint64("missed_epochs", plan.CatchUp.MissedEpochs),
+			zap.Any("missed_unstake_epochs", plan.CatchUp.MissedUnstakeEpochs))
 	}
 
-	var validators []Validator
-	err = s.callContractWithUnmarshal("get_validator_registry", "", &validators)
+	steps, err := s.planTakeUnstakedBalance(epochs, validators)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
+		return plan, errors.Wrap(err, "planTakeUnstakedBalance")
+	}
+	plan.add(steps...)
+
+	if epochs.PoolEpochHeight == epochs.NetworkEpochHeight {
+		s.log.Debug("PoolUpdate: not yet")
+		if len(plan.Steps) == 0 {
+			return plan.skip(fmt.Sprintf("pool is already updated to epoch %d", epochs.PoolEpochHeight)), nil
+		}
+		return plan, nil
 	}
+
 	for _, v := range validators {
 		if v.LastUpdateEpochHeight == epochs.NetworkEpochHeight {
 			s.log.Warn("PoolUpdate: validator already updated", zap.String("validator", v.AccountID))
 			continue
 		}
-		argsMarshaled, _ := json.Marshal(map[string]string{"validator_account_id": v.AccountID})
-		res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-			action.NewFunctionCall("update_validator", argsMarshaled, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-		}, client.WithLatestBlock(),
-			client.WithKeyPair(s.keyPair),
-		)
-		if err != nil {
-			return errors.Wrapf(err, "TransactionSendAwait[validator:%s]", v.AccountID)
-		}
-		if res.Status.Failure != nil {
-			return errors.New(string(res.Status.Failure))
-		}
-		data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-		var resp CallbackResult
-		err = json.Unmarshal(data, &resp)
-		if err != nil {
-			return errors.Wrap(err, "json.Unmarshal(resp)")
-		}
-		if !resp.IsSuccess {
-			return errors.Errorf("fail result from validator %s", v.AccountID)
-		}
-		if resp.NetworkEpochHeight != epochs.NetworkEpochHeight {
-			return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, epochs.NetworkEpochHeight)
-		}
+		plan.add(updateValidatorStep(v, epochs))
+	}
+	if len(plan.Steps) == 0 {
+		return plan.skip(fmt.Sprintf("validators are already updated to epoch %d", epochs.NetworkEpochHeight)), nil
 	}
+	return plan, nil
+}
 
-	err = s.requestedDecreaseValidatorStake()
+// planPoolUnstake plans the second phase of PoolUpdate: unstaking the requested withdrawals from the
+// registry read after the validator updates and updating the pool. A dry run does not apply the first
+// phase, so its amounts miss the rewards of the epoch.
+func (s *Service) planPoolUnstake() (plan Plan, err error) {
+	plan = newPlan(PoolUpdateJob)
+	plan.Phase = PoolUnstakePhase
+	epochs, validators, err := s.getEpochsAndValidators()
 	if err != nil {
-		return errors.Wrap(err, "requestedDecreaseValidatorStake")
+		return plan, errors.Wrap(err, "getEpochsAndValidators")
+	}
+	plan.Epochs = epochs
+	if epochs.PoolEpochHeight == epochs.NetworkEpochHeight {
+		return plan.skip(fmt.Sprintf("pool is already updated to epoch %d", epochs.PoolEpochHeight)), nil
 	}
 
+	steps, err := s.planRequestedDecreaseValidatorStake(epochs, validators)
+	if err != nil {
+		return plan, errors.Wrap(err, "planRequestedDecreaseValidatorStake")
+	}
+	plan.add(steps...)
+
 	// update stake pool
-	res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-		action.NewFunctionCall("update", nil, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-	}, client.WithLatestBlock(),
-		client.WithKeyPair(s.keyPair),
-	)
+	plan.add(Step{
+		Method: "update",
+		Gas:    callGas,
+		Reason: fmt.Sprintf("pool epoch %d is behind network epoch %d", epochs.PoolEpochHeight, epochs.NetworkEpochHeight),
+		Expect: ResultNone,
+	})
+	return plan, nil
+}
+
+// checkOperatorBalance alerts when the operator is about to run out of gas.
+func (s *Service) checkOperatorBalance() error {
+	amount, err := s.getOperatorBalance()
 	if err != nil {
-		return errors.Wrap(err, "TransactionSendAwait(update)")
+		return errors.Wrap(err, "getOperatorBalance")
 	}
-	if res.Status.Failure != nil {
-		return errors.Errorf("pool update %s", string(res.Status.Failure))
+	balance := toNear(amount)
+	if balance.LessThan(decimal.NewFromFloat(0.01)) {
+		s.alert(notify.Alert{
+			Key:      AlertLowOperatorBalance,
+			Severity: notify.SeverityCritical,
+			Title:    "Operator balance is low",
+			Message:  fmt.Sprintf("%s has %s NEAR left for gas", s.cfg.KeyPairAccountID, balance.StringFixed(4)),
+		})
+	} else {
+		s.resolve(AlertLowOperatorBalance)
 	}
-	s.log.Info("Pool updated", zap.Int("validators", len(validators)), zap.String("tx", res.Transaction.Hash.String()))
 	return nil
 }
 
+func updateValidatorStep(v Validator, epochs EpochHeightRegistry) Step {
+	return Step{
+		Method:      "update_validator",
+		Args:        map[string]interface{}{"validator_account_id": v.AccountID},
+		Gas:         callGas,
+		Validator:   v.AccountID,
+		Reason:      fmt.Sprintf("validator last updated at epoch %d, network epoch is %d", v.LastUpdateEpochHeight, epochs.NetworkEpochHeight),
+		Expect:      ResultCallback,
+		EpochHeight: epochs.NetworkEpochHeight,
+	}
+}
+
+// getGenesisCfg queries the genesis config once, it does not change.
 func (s *Service) getGenesisCfg() (cfg GenesisConfig, err error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.genesis != nil {
+		return *s.genesis, nil
+	}
 	resp, err := s.cli.GenesisConfig(s.ctx)
 	if err != nil {
 		return cfg, errors.Wrap(err, "GenesisConfig")
@@ -113,50 +211,65 @@ func (s *Service) getGenesisCfg() (cfg GenesisConfig, err error) {
 	if err != nil {
 		return cfg, errors.Wrap(err, "json.Unmarshal")
 	}
+	s.genesis = &cfg
 	return cfg, nil
 }
 
 func (s *Service) IncreaseStake() error {
 	t := time.Now()
 
-	genesis, err := s.getGenesisCfg()
+	plan, err := s.planIncreaseStake()
+	if err != nil {
+		return errors.Wrap(err, "planIncreaseStake")
+	}
+	results, err := s.execute(plan)
 	if err != nil {
-		return errors.Wrap(err, "getGenesisConfig")
+		return errors.Wrap(err, "Execute")
 	}
-	latestBlock, err := s.cli.BlockDetails(s.ctx, block.FinalityFinal())
+	if len(results) != 0 {
+		s.log.Info("IncreaseStake: confirmed", zap.Duration("duration", time.Now().Sub(t)))
+	}
+	return nil
+}
+
+func (s *Service) planIncreaseStake() (plan Plan, err error) {
+	plan = newPlan(IncreaseStakeJob)
+	progress, err := s.GetEpochProgress()
 	if err != nil {
-		return errors.Wrap(err, "BlockDetails")
+		return plan, errors.Wrap(err, "GetEpochProgress")
 	}
-	remain := latestBlock.Header.Height % genesis.EpochLength
-	if remain < genesis.EpochLength-6480 { // 6480 it`s 15% of EpochLength
+	if !progress.InDistributionWindow() {
 		s.log.Debug("IncreaseStake: not yet")
-		return nil
+		return plan.skip(fmt.Sprintf("epoch progress %d/%d blocks, distribution window is the last %d blocks",
+			progress.Position, progress.EpochLength, progress.DistributionWindow)), nil
 	}
 
 	var isDistributed bool
 	err = s.callContractWithUnmarshal("is_stake_distributed", "", &isDistributed)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(is_stake_distributed)")
+		return plan, errors.Wrap(err, "callContractWithUnmarshal(is_stake_distributed)")
 	}
 	if isDistributed {
 		s.log.Debug("IncreaseStake: already distributed")
-		return nil
+		return plan.skip("stake is already distributed"), nil
 	}
 
 	var epochs EpochHeightRegistry
 	err = s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
+		return plan, errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
 	}
+	plan.Epochs = epochs
 
 	if epochs.NetworkEpochHeight != epochs.PoolEpochHeight {
-		return errors.Wrap(err, "epochs are different")
+		s.log.Debug("IncreaseStake: epochs are different")
+		return plan.skip(fmt.Sprintf("pool epoch %d is behind network epoch %d", epochs.PoolEpochHeight, epochs.NetworkEpochHeight)), nil
 	}
 
 	var validators []Validator
 	err = s.callContractWithUnmarshal("get_validator_registry", "", &validators)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
+		return plan, errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
 	}
 
 	var filteredValidators []Validator
@@ -172,95 +285,89 @@ func (s *Service) IncreaseStake() error {
 	var fund Fund
 	err = s.callContractWithUnmarshal("get_fund", "", &fund)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_fund)")
+		return plan, errors.Wrap(err, "callContractWithUnmarshal(get_fund)")
 	}
 
 	if fund.ClassicUnstakedBalance.IsZero() {
 		s.log.Info("IncreaseStake: ClassicUnstakedBalance is zero")
-		return nil
+		return plan.skip("classic unstaked balance is zero"), nil
 	}
 
 	if len(filteredValidators) == 0 {
 		s.log.Info("IncreaseStake: not found available validators")
-		return nil
-	}
-
-	type validatorShare struct {
-		validator Validator
-		stake     decimal.Decimal
-	}
-	var shares []validatorShare
-	part := fund.ClassicUnstakedBalance.Div(decimal.New(int64(len(filteredValidators)), 0)).Truncate(0)
-	if part.LessThan(minRebalanceStake) {
-		numberOfValidators := fund.ClassicUnstakedBalance.Div(minRebalanceStake).IntPart()
-		for i := 0; i < int(numberOfValidators); i++ {
-			shares = append(shares, validatorShare{
-				validator: filteredValidators[i],
-				stake:     part,
-			})
-		}
-		modAmount := fund.ClassicUnstakedBalance.Mod(minRebalanceStake)
-		shares[len(shares)-1].stake = shares[len(shares)-1].stake.Add(modAmount)
-	} else {
-		for i := 0; i < len(filteredValidators); i++ {
-			shares = append(shares, validatorShare{
-				validator: filteredValidators[i],
-				stake:     part,
-			})
-		}
-		modAmount := fund.ClassicUnstakedBalance.Mod(decimal.New(int64(len(filteredValidators)), 0))
-		shares[len(shares)-1].stake = shares[len(shares)-1].stake.Add(modAmount)
+		return plan.skip("no validators available for classic stake"), nil
 	}
 
-	// todo make equal sharing via all staking balance
-	for _, share := range shares {
-		argsMarshaled, _ := json.Marshal(map[string]interface{}{
-			"validator_account_id": share.validator.AccountID,
-			"near_amount":          share.stake.Truncate(0).BigInt().String(),
-		})
-		res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-			action.NewFunctionCall("increase_validator_stake", argsMarshaled, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-		}, client.WithLatestBlock(),
-			client.WithKeyPair(s.keyPair),
-		)
+	if s.cfg.Allocation.MinUptime > 0 {
+		filteredValidators, err = s.filterByUptime(filteredValidators)
 		if err != nil {
-			return errors.Wrap(err, "TransactionSendAwait(increase_validator_stake)")
+			return plan, errors.Wrap(err, "filterByUptime")
 		}
-		if res.Status.Failure != nil {
-			return errors.Errorf("increase validator stake %s", string(res.Status.Failure))
+		if len(filteredValidators) == 0 {
+			s.log.Info("IncreaseStake: no validators with enough uptime")
+			return plan.skip(fmt.Sprintf("no validators with uptime above %.2f", s.cfg.Allocation.MinUptime)), nil
 		}
-		data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-		var resp bool
-		err = json.Unmarshal(data, &resp)
-		if err != nil {
-			return errors.Wrap(err, "json.Unmarshal(resp)")
-		}
-		s.log.Info(
-			"IncreaseStake: call increase_validator_stake",
-			zap.String("validator", share.validator.AccountID),
-			zap.String("amount", share.stake.String()),
-			zap.Bool("response", resp),
-			zap.String("tx_hash", res.Transaction.Hash.String()),
-		)
-		if !resp {
-			return errors.New("false result")
+	}
+
+	var rewardFees map[string]decimal.Decimal
+	if s.strategy.NeedsRewardFees() {
+		filteredValidators, rewardFees = s.filterByRewardFee(filteredValidators)
+		if len(filteredValidators) == 0 {
+			s.log.Info("IncreaseStake: no validators with a known reward fee")
+			return plan.skip("no validators with a known reward fee"), nil
 		}
 	}
 
-	res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-		action.NewFunctionCall("confirm_stake_distribution", nil, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-	}, client.WithLatestBlock(),
-		client.WithKeyPair(s.keyPair),
-	)
-	if err != nil {
-		return errors.Wrap(err, "TransactionSendAwait(confirm_stake_distribution)")
+	allocations, err := s.strategy.Allocate(AllocationRequest{
+		Stake:             fund.ClassicUnstakedBalance,
+		TotalClassicStake: fund.ClassicStakedBalance,
+		Validators:        filteredValidators,
+		RewardFees:        rewardFees,
+	})
+	if errors.Is(err, ErrBelowMinAllocation) {
+		s.log.Info("IncreaseStake: ClassicUnstakedBalance is below the minimum", zap.Error(err))
+		return plan.skip(fmt.Sprintf("classic unstaked balance: %s", err)), nil
 	}
-	if res.Status.Failure != nil {
-		return errors.Errorf("confirm stake distribution %s", string(res.Status.Failure))
+	if errors.Is(err, ErrNoValidatorWithinLimits) {
+		// the stake stays undistributed, which is alerted once the distribution window passes
+		s.log.Warn("IncreaseStake: no validator within the allocation limits", zap.Error(err))
+		return plan.skip(err.Error()), nil
+	}
+	if err != nil {
+		return plan, errors.Wrapf(err, "Allocate(%s)", s.strategy.Name())
+	}
+	left := fund.ClassicUnstakedBalance
+	for _, a := range allocations {
+		left = left.Sub(a.Stake)
+	}
+	confirmReason := "classic unstaked balance is distributed"
+	if left.IsPositive() {
+		s.log.Warn("IncreaseStake: max share leaves stake undistributed", zap.String("undistributed", toNear(left).StringFixed(4)))
+		confirmReason = fmt.Sprintf("classic unstaked balance is distributed up to the max share, %s NEAR stays unstaked", toNear(left).StringFixed(4))
+	}
+	for _, a := range allocations {
+		plan.add(Step{
+			Method: "increase_validator_stake",
+			Args: map[string]interface{}{
+				"validator_account_id": a.Validator.AccountID,
+				"near_amount":          a.Stake.BigInt().String(),
+			},
+			Gas:       callGas,
+			Validator: a.Validator.AccountID,
+			Amount:    a.Stake,
+			Reason: fmt.Sprintf("%s: classic stake %s NEAR up to %s NEAR", s.strategy.Name(),
+				toNear(a.Validator.ClassicStakedBalance).StringFixed(2), toNear(a.Validator.ClassicStakedBalance.Add(a.Stake)).StringFixed(2)),
+			Expect: ResultBool,
+		})
 	}
 
-	s.log.Info("IncreaseStake: confirmed", zap.Duration("duration", time.Now().Sub(t)))
-	return nil
+	plan.add(Step{
+		Method: "confirm_stake_distribution",
+		Gas:    callGas,
+		Reason: confirmReason,
+		Expect: ResultNone,
+	})
+	return plan, nil
 }
 
 type (
@@ -271,28 +378,18 @@ type (
 	}
 )
 
-func (s *Service) requestedDecreaseValidatorStake() error {
-	var epochs EpochHeightRegistry
-	err := s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
-	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
-	}
-	if epochs.NetworkEpochHeight%4 != 0 || epochs.PoolEpochHeight >= epochs.NetworkEpochHeight {
+func (s *Service) planRequestedDecreaseValidatorStake(epochs EpochHeightRegistry, validators []Validator) (steps []Step, err error) {
+	if !unstakeDue(epochs) {
 		s.log.Debug("requestedDecreaseValidatorStake: not yet")
-		return nil
+		return nil, nil
 	}
 
 	var requestedToWithdrawalFund RequestedToWithdrawalFund
 	err = s.callContractWithUnmarshal("get_requested_to_withdrawal_fund", "", &requestedToWithdrawalFund)
 	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_requested_to_withdrawal_fund)")
+		return nil, errors.Wrap(err, "callContractWithUnmarshal(get_requested_to_withdrawal_fund)")
 	}
 
-	var validators []Validator
-	err = s.callContractWithUnmarshal("get_validator_registry", "", &validators)
-	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
-	}
 	var filteredValidators []Validator
 	for _, validator := range validators {
 		if !validator.IsOnlyForInvestment && validator.ClassicStakedBalance.GreaterThan(decimal.Zero) {
@@ -301,225 +398,103 @@ func (s *Service) requestedDecreaseValidatorStake() error {
 	}
 
 	nearAmount := requestedToWithdrawalFund.ClassicNearAmount
+	classicStake := decimal.Zero
 	for _, validator := range filteredValidators {
-		if nearAmount.IsZero() {
-			break
+		classicStake = classicStake.Add(validator.ClassicStakedBalance)
+	}
+	if nearAmount.GreaterThan(classicStake) {
+		s.log.Warn("requestedDecreaseValidatorStake: requested classic withdrawal exceeds the classic stake",
+			zap.String("requested", nearAmount.String()), zap.String("classic_stake", classicStake.String()))
+		nearAmount = classicStake
+	}
+	if nearAmount.IsPositive() {
+		unstakes, err := s.unstake.Select(UnstakeRequest{
+			Amount:     nearAmount,
+			Validators: filteredValidators,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Select(%s)", s.unstake.Name())
 		}
-		if nearAmount.GreaterThanOrEqual(validator.ClassicStakedBalance) {
-			args, _ := json.Marshal(map[string]interface{}{
-				"validator_account_id":  validator.AccountID,
-				"near_amount":           validator.ClassicStakedBalance.BigInt().String(),
-				"stake_decreasing_type": ClassicStakeDecreasingType,
-			})
-			res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-				action.NewFunctionCall("requested_decrease_validator_stake", args, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-			}, client.WithLatestBlock(),
-				client.WithKeyPair(s.keyPair),
-			)
-			if err != nil {
-				return errors.Wrap(err, "TransactionSendAwait(requested_decrease_validator_stake)")
-			}
-			if res.Status.Failure != nil {
-				return errors.Errorf("requested decrease validator stake: %s", string(res.Status.Failure))
-			}
-			data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-			var resp CallbackResult
-			err = json.Unmarshal(data, &resp)
-			if err != nil {
-				return errors.Wrap(err, "json.Unmarshal(resp)")
-			}
-			if !resp.IsSuccess {
-				return errors.Errorf("fail result from validator %s", validator.AccountID)
-			}
-			if resp.NetworkEpochHeight != epochs.NetworkEpochHeight {
-				return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, epochs.NetworkEpochHeight)
-			}
-			nearAmount = nearAmount.Sub(validator.ClassicStakedBalance)
-		} else {
-			args, _ := json.Marshal(map[string]interface{}{
-				"validator_account_id":  validator.AccountID,
-				"near_amount":           nearAmount.BigInt().String(),
-				"stake_decreasing_type": ClassicStakeDecreasingType,
-			})
-			res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-				action.NewFunctionCall("requested_decrease_validator_stake", args, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-			}, client.WithLatestBlock(),
-				client.WithKeyPair(s.keyPair),
-			)
-			if err != nil {
-				return errors.Wrap(err, "TransactionSendAwait(requested_decrease_validator_stake)")
-			}
-			if res.Status.Failure != nil {
-				return errors.Errorf("requested decrease validator stake: %s", string(res.Status.Failure))
+		for _, u := range unstakes {
+			reason := fmt.Sprintf("%s: classic stake %s NEAR down to %s NEAR", s.unstake.Name(),
+				toNear(u.Validator.ClassicStakedBalance).StringFixed(2), toNear(u.Validator.ClassicStakedBalance.Sub(u.Amount)).StringFixed(2))
+			if u.BelowMinRemaining {
+				reason += ", below the min remaining stake to cover the request"
 			}
-			data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-			var resp CallbackResult
-			err = json.Unmarshal(data, &resp)
-			if err != nil {
-				return errors.Wrap(err, "json.Unmarshal(resp)")
+			if epochs.NetworkEpochHeight%UnstakeCycle != 0 {
+				reason += ", " + unstakeReason(epochs)
 			}
-			if !resp.IsSuccess {
-				return errors.Errorf("fail result from validator %s", validator.AccountID)
-			}
-			if resp.NetworkEpochHeight != epochs.NetworkEpochHeight {
-				return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, epochs.NetworkEpochHeight)
-			}
-			nearAmount = decimal.Zero
+			steps = append(steps, decreaseStep(u.Validator.AccountID, u.Amount, ClassicStakeDecreasingType, reason, epochs))
 		}
 	}
 
 	for _, v := range requestedToWithdrawalFund.InvestmentWithdrawalRegistry {
 		if len(v) != 2 {
-			return errors.Errorf("unknown format of InvestmentWithdrawalRegistry")
+			return nil, errors.Errorf("unknown format of InvestmentWithdrawalRegistry")
 		}
 		investmentWithdrawalRegistryAccountID, ok := v[0].(string)
 		if !ok {
-			return errors.Errorf("unknown format of InvestmentWithdrawalRegistry(AccountId)")
+			return nil, errors.Errorf("unknown format of InvestmentWithdrawalRegistry(AccountId)")
 		}
 		investmentWithdrawalRegistryAmountStr, ok := v[1].(string)
 		if !ok {
-			return errors.Errorf("unknown format of InvestmentWithdrawalRegistry(Amount)")
+			return nil, errors.Errorf("unknown format of InvestmentWithdrawalRegistry(Amount)")
 		}
 		investmentWithdrawalRegistryAmount, err := decimal.NewFromString(investmentWithdrawalRegistryAmountStr)
 		if err != nil {
-			return errors.Wrap(err, "decimal.NewFromString(investmentWithdrawalRegistryAmountStr)")
-		}
-		args, _ := json.Marshal(map[string]interface{}{
-			"validator_account_id":  investmentWithdrawalRegistryAccountID,
-			"near_amount":           investmentWithdrawalRegistryAmount.BigInt().String(),
-			"stake_decreasing_type": InvestmentStakeDecreasingType,
-		})
-		res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-			action.NewFunctionCall("requested_decrease_validator_stake", args, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-		}, client.WithLatestBlock(),
-			client.WithKeyPair(s.keyPair),
-		)
-		if err != nil {
-			return errors.Wrap(err, "TransactionSendAwait(requested_decrease_validator_stake)")
-		}
-		if res.Status.Failure != nil {
-			return errors.Errorf("requested decrease validator stake: %s", string(res.Status.Failure))
-		}
-		data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-		var resp CallbackResult
-		err = json.Unmarshal(data, &resp)
-		if err != nil {
-			return errors.Wrap(err, "json.Unmarshal(resp)")
-		}
-		if !resp.IsSuccess {
-			return errors.Errorf("fail result from validator %s", investmentWithdrawalRegistryAccountID)
-		}
-		if resp.NetworkEpochHeight != epochs.NetworkEpochHeight {
-			return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, epochs.NetworkEpochHeight)
+			return nil, errors.Wrap(err, "decimal.NewFromString(investmentWithdrawalRegistryAmountStr)")
 		}
+		steps = append(steps, decreaseStep(investmentWithdrawalRegistryAccountID, investmentWithdrawalRegistryAmount,
+			InvestmentStakeDecreasingType, "investment withdrawal request", epochs))
 	}
-	return nil
+	return steps, nil
 }
 
-func (s *Service) takeUnstakedBalance() error {
-	var epochs EpochHeightRegistry
-	err := s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
-	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
+func decreaseStep(validator string, amount decimal.Decimal, decreasingType stakeDecreasingType, reason string, epochs EpochHeightRegistry) Step {
+	return Step{
+		Method: "requested_decrease_validator_stake",
+		Args: map[string]interface{}{
+			"validator_account_id":  validator,
+			"near_amount":           amount.BigInt().String(),
+			"stake_decreasing_type": decreasingType,
+		},
+		Gas:         callGas,
+		Validator:   validator,
+		Amount:      amount,
+		Reason:      reason,
+		Expect:      ResultCallback,
+		EpochHeight: epochs.NetworkEpochHeight,
 	}
-	if epochs.NetworkEpochHeight%4 != 0 || epochs.PoolEpochHeight >= epochs.NetworkEpochHeight {
+}
+
+func (s *Service) planTakeUnstakedBalance(epochs EpochHeightRegistry, validators []Validator) (steps []Step, err error) {
+	if !unstakeDue(epochs) {
 		s.log.Debug("takeUnstakedBalance: not yet")
-		return nil
-	}
-	var validators []Validator
-	err = s.callContractWithUnmarshal("get_validator_registry", "", &validators)
-	if err != nil {
-		return errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
+		return nil, nil
 	}
 	for _, validator := range validators {
-		if validator.LastUpdateEpochHeight == epochs.NetworkEpochHeight {
+		if validator.LastUpdateEpochHeight == epochs.NetworkEpochHeight || !validator.UnstakedBalance.GreaterThan(decimal.Zero) {
 			continue
 		}
-		if validator.UnstakedBalance.GreaterThan(decimal.Zero) {
-			argsMarshaled, _ := json.Marshal(map[string]interface{}{
-				"validator_account_id": validator.AccountID,
-			})
-			res, err := s.cli.TransactionSendAwait(s.ctx, s.cfg.KeyPairAccountID, s.cfg.StakePool, []action.Action{
-				action.NewFunctionCall("take_unstaked_balance", argsMarshaled, types.DefaultFunctionCallGas*10, types.BalanceFromFloat(0)),
-			}, client.WithLatestBlock(),
-				client.WithKeyPair(s.keyPair),
-			)
-			if err != nil {
-				return errors.Wrap(err, "TransactionSendAwait(take_unstaked_balance)")
-			}
-			if res.Status.Failure != nil {
-				return errors.Errorf("decrease validator stake: %s", string(res.Status.Failure))
-			}
-			data, _ := base64.StdEncoding.DecodeString(res.Status.SuccessValue)
-			var resp CallbackResult
-			err = json.Unmarshal(data, &resp)
-			if err != nil {
-				return errors.Wrap(err, "json.Unmarshal(resp)")
-			}
-			if !resp.IsSuccess {
-				return errors.Errorf("fail result from validator %s", validator.AccountID)
-			}
-			if resp.NetworkEpochHeight != epochs.NetworkEpochHeight {
-				return errors.Errorf("mismatch epoch after update %d != %d", resp.NetworkEpochHeight, epochs.NetworkEpochHeight)
-			}
-		}
-	}
-	return nil
-}
-
-func stakeDistribution(stakeRemains decimal.Decimal, shares map[string]decimal.Decimal, validators []Validator) {
-	if len(validators) == 0 {
-		return
-	}
-
-	copiedValidators := make([]Validator, len(validators))
-	copy(copiedValidators, validators)
-
-	// fill had been already distributed shares and find max stake
-	max := copiedValidators[0].ClassicStakedBalance
-	for i, v := range copiedValidators {
-		share, ok := shares[v.AccountID]
-		if ok {
-			copiedValidators[i].ClassicStakedBalance = v.ClassicStakedBalance.Add(share)
-		}
-		if copiedValidators[i].ClassicStakedBalance.GreaterThan(max) {
-			max = copiedValidators[i].ClassicStakedBalance.Copy()
-		}
-	}
-
-	// sorting (asc)
-	sort.Slice(copiedValidators, func(i, j int) bool {
-		return copiedValidators[i].ClassicStakedBalance.GreaterThan(copiedValidators[j].ClassicStakedBalance)
-	})
-
-	if copiedValidators[0].ClassicStakedBalance.Equals(max) { // so all validators stake are equal
-		part := stakeRemains.Div(decimal.New(int64(len(validators)), 0)).Truncate(0)
-		if part.GreaterThan(minRebalanceStake) { // div equal part for everyone
-			for validator, stake := range shares {
-				shares[validator] = stake.Add(part)
-			}
-		} else { // delegate all stake remains for one validator
-			shares[copiedValidators[0].AccountID] = stakeRemains
+		available, err := s.isUnstakedBalanceAvailable(validator.AccountID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "isUnstakedBalanceAvailable(%s)", validator.AccountID)
 		}
-		return
-	}
-
-	for _, v := range copiedValidators {
-		lack := max.Sub(v.ClassicStakedBalance)
-		if stakeRemains.GreaterThanOrEqual(lack) {
-			shares[v.AccountID] = shares[v.AccountID].Add(lack)
-			stakeRemains = stakeRemains.Sub(lack)
-		} else {
-			if stakeRemains.GreaterThan(decimal.Zero) {
-				shares[v.AccountID] = shares[v.AccountID].Add(stakeRemains)
-				stakeRemains = decimal.Zero
-			}
-			return
+		if !available {
+			s.log.Warn("takeUnstakedBalance: unstaked balance is locked", zap.String("validator", validator.AccountID),
+				zap.String("amount", validator.UnstakedBalance.String()))
+			continue
 		}
+		steps = append(steps, Step{
+			Method:      "take_unstaked_balance",
+			Args:        map[string]interface{}{"validator_account_id": validator.AccountID},
+			Gas:         callGas,
+			Validator:   validator.AccountID,
+			Amount:      validator.UnstakedBalance,
+			Reason:      fmt.Sprintf("unstaked balance is withdrawable, %s", unstakeReason(epochs)),
+			Expect:      ResultCallback,
+			EpochHeight: epochs.NetworkEpochHeight,
+		})
 	}
-
-	if stakeRemains.Equal(decimal.Zero) {
-		return
-	}
-	stakeDistribution(stakeRemains, shares, copiedValidators)
+	return steps, nil
 }
@@ -0,0 +1,574 @@
+package stakepool_test
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/application/stakepool/simulator"
+	"lido-near-client/internal/config"
+)
+
+const (
+	testStakePool = "pool.test.near"
+	testOperator  = "operator.test.near"
+)
+
+func near(amount int64) decimal.Decimal {
+	return decimal.New(amount, 24)
+}
+
+func newTestService(t *testing.T, sim *simulator.Simulator, configure ...func(cfg *config.Config)) *stakepool.Service {
+	t.Helper()
+	s, err := stakepool.New(stakepool.ServiceParam{
+		Ctx: context.Background(),
+		Log: zap.NewNop(),
+		Cfg: newTestConfig(t, sim, configure...),
+	})
+	if err != nil {
+		t.Fatalf("stakepool.New: %s", err)
+	}
+	return s
+}
+
+func newTestConfig(t *testing.T, sim *simulator.Simulator, configure ...func(cfg *config.Config)) config.Config {
+	t.Helper()
+	keyPair, err := key.GenerateKeyPair(key.KeyTypeED25519, rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %s", err)
+	}
+	cfg := config.Config{
+		Node:             sim.URL(),
+		StakePool:        testStakePool,
+		KeyPair:          keyPair.PrivateEncoded(),
+		KeyPairAccountID: testOperator,
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+	return cfg
+}
+
+func newTestSimulator(t *testing.T) *simulator.Simulator {
+	t.Helper()
+	sim := simulator.New(simulator.Params{
+		StakePool:       testStakePool,
+		Operator:        testOperator,
+		OperatorBalance: near(10),
+	})
+	t.Cleanup(sim.Close)
+	return sim
+}
+
+// runEpoch moves the simulator to the next epoch and runs the operator jobs the way the daemon does.
+func runEpoch(t *testing.T, sim *simulator.Simulator, s *stakepool.Service) {
+	t.Helper()
+	sim.AdvanceEpoch(0.01)
+	if err := s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+	if err := s.IncreaseStake(); err != nil {
+		t.Fatalf("IncreaseStake (epoch start): %s", err)
+	}
+	sim.SetEpochProgress(0.9)
+	if err := s.IncreaseStake(); err != nil {
+		t.Fatalf("IncreaseStake: %s", err)
+	}
+}
+
+func countCalls(sim *simulator.Simulator, method string) (n int) {
+	for _, c := range sim.Calls() {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEpochWorkflow(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+
+	sim.AddValidator("a.test.near", decimal.NewFromFloat(0.001), false)
+	sim.AddValidator("b.test.near", decimal.NewFromFloat(0.001), false)
+	sim.AddValidator("c.test.near", decimal.NewFromFloat(0.001), false)
+	sim.AddValidator("investment.test.near", decimal.NewFromFloat(0.001), true)
+	sim.Update(func(c *simulator.Contract) {
+		c.Validators[3].InvestmentStakedBalance = near(50)
+	})
+
+	sim.Deposit(near(300))
+	runEpoch(t, sim, s)
+
+	state := sim.Contract()
+	if state.PoolEpochHeight != 1 || !state.IsStakeDistributed {
+		t.Fatalf("epoch 1: pool epoch %d, distributed %t", state.PoolEpochHeight, state.IsStakeDistributed)
+	}
+	for _, v := range state.Validators[:3] {
+		if !v.ClassicStakedBalance.Equal(near(100)) {
+			t.Errorf("epoch 1: validator %s classic stake %s, want %s", v.AccountID, v.ClassicStakedBalance, near(100))
+		}
+	}
+
+	for epoch := 2; epoch <= 8; epoch++ {
+		switch epoch {
+		case 2:
+			sim.Deposit(near(30))
+		case 3:
+			sim.RequestWithdrawal(near(150))
+			sim.RequestInvestmentWithdrawal("investment.test.near", near(20))
+		}
+		runEpoch(t, sim, s)
+	}
+
+	state = sim.Contract()
+	if state.PoolEpochHeight != 8 || state.NetworkEpochHeight != 8 {
+		t.Fatalf("epochs: pool %d, network %d", state.PoolEpochHeight, state.NetworkEpochHeight)
+	}
+	if !state.ClassicUnstaked.IsZero() {
+		t.Errorf("classic unstaked balance %s, want 0", state.ClassicUnstaked)
+	}
+	if !state.RequestedClassic.IsZero() || len(state.RequestedInvestment) != 0 {
+		t.Errorf("requested withdrawals are not processed: classic %s, investment %v", state.RequestedClassic, state.RequestedInvestment)
+	}
+	if !state.Withdrawn.Equal(near(170)) {
+		t.Errorf("withdrawn %s, want %s", state.Withdrawn, near(170))
+	}
+	for _, v := range state.Validators {
+		if v.LastUpdateEpochHeight != 8 {
+			t.Errorf("validator %s updated at %d", v.AccountID, v.LastUpdateEpochHeight)
+		}
+		if !v.UnstakedBalance.IsZero() {
+			t.Errorf("validator %s unstaked balance %s", v.AccountID, v.UnstakedBalance)
+		}
+	}
+	if n := countCalls(sim, "update"); n != 8 {
+		t.Errorf("update called %d times, want 8", n)
+	}
+	if n := countCalls(sim, "confirm_stake_distribution"); n != 2 {
+		t.Errorf("confirm_stake_distribution called %d times, want 2", n)
+	}
+	for _, c := range sim.Calls() {
+		if c.Error != nil {
+			t.Errorf("call %s(%s) failed: %s", c.Method, c.Args, c.Error)
+		}
+	}
+}
+
+func TestEpochProgress(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AdvanceEpoch(0.5)
+	progress, err := s.GetEpochProgress()
+	if err != nil {
+		t.Fatalf("GetEpochProgress: %s", err)
+	}
+	if progress.EpochStartHeight%progress.EpochLength == 0 {
+		t.Fatalf("epoch starts at %d, a multiple of the epoch length", progress.EpochStartHeight)
+	}
+	if progress.EpochStartHeight != simulator.DefaultGenesisHeight+simulator.DefaultEpochLength || progress.Position != progress.EpochLength/2 {
+		t.Errorf("epoch start %d, position %d, want %d and %d", progress.EpochStartHeight, progress.Position,
+			simulator.DefaultGenesisHeight+simulator.DefaultEpochLength, progress.EpochLength/2)
+	}
+}
+
+func TestStatusQueriesEpochOnce(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AdvanceEpoch(0.1)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Status(); err != nil {
+			t.Fatalf("Status: %s", err)
+		}
+	}
+	requests := sim.Requests()
+	if requests["validators"] != 1 || requests["EXPERIMENTAL_genesis_config"] != 1 {
+		t.Errorf("3 status snapshots: %d validators and %d genesis config requests, want 1 each",
+			requests["validators"], requests["EXPERIMENTAL_genesis_config"])
+	}
+
+	sim.AdvanceEpoch(0.1)
+	progress, err := s.GetEpochProgress()
+	if err != nil {
+		t.Fatalf("GetEpochProgress: %s", err)
+	}
+	if want := uint64(simulator.DefaultGenesisHeight + 2*simulator.DefaultEpochLength); progress.EpochStartHeight != want {
+		t.Errorf("next epoch starts at %d, want %d", progress.EpochStartHeight, want)
+	}
+	if n := sim.Requests()["validators"]; n != 2 {
+		t.Errorf("%d validators requests, want 2", n)
+	}
+}
+
+func TestIncreaseStakeOutsideWindow(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Deposit(near(10))
+
+	sim.AdvanceEpoch(0.5)
+	if err := s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+	if err := s.IncreaseStake(); err != nil {
+		t.Fatalf("IncreaseStake: %s", err)
+	}
+	if n := countCalls(sim, "increase_validator_stake"); n != 0 {
+		t.Errorf("increase_validator_stake called %d times before the window", n)
+	}
+	if err := s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate (repeated): %s", err)
+	}
+	if n := countCalls(sim, "update"); n != 1 {
+		t.Errorf("update called %d times, want 1", n)
+	}
+}
+
+func TestIncreaseStakeSkipsLowUptime(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Allocation.MinUptime = 0.9
+	})
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AddValidator("b.test.near", decimal.Zero, false)
+	sim.AddValidator("c.test.near", decimal.Zero, false)
+	sim.SetUptime("b.test.near", 0.5)
+	sim.SetUptime("c.test.near", -1)
+	sim.Deposit(near(30))
+
+	performance, err := s.GetValidatorPerformance()
+	if err != nil {
+		t.Fatalf("GetValidatorPerformance: %s", err)
+	}
+	uptimes := map[string]float64{}
+	for _, p := range performance {
+		uptimes[p.AccountID] = p.Uptime
+	}
+	if uptimes["a.test.near"] != 1 || uptimes["b.test.near"] != 0.5 || uptimes["c.test.near"] != 0 {
+		t.Errorf("uptimes: %v", uptimes)
+	}
+
+	runEpoch(t, sim, s)
+	for _, v := range sim.Contract().Validators {
+		want := decimal.Zero
+		if v.AccountID == "a.test.near" {
+			want = near(30)
+		}
+		if !v.ClassicStakedBalance.Equal(want) {
+			t.Errorf("%s: classic staked %s, want %s", v.AccountID, v.ClassicStakedBalance, want)
+		}
+	}
+
+	// no validator qualifies, the stake waits without failing the job
+	sim.SetUptime("a.test.near", 0.5)
+	sim.Deposit(near(10))
+	runEpoch(t, sim, s)
+	if state := sim.Contract(); !state.ClassicUnstaked.Equal(near(10)) {
+		t.Errorf("classic unstaked %s, want %s", state.ClassicUnstaked, near(10))
+	}
+}
+
+func TestValidatorPerformanceAfterSkippedBlock(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AdvanceEpoch(0.5)
+	// the producer of the last slot of the previous epoch missed it
+	sim.SkipBlock(simulator.DefaultGenesisHeight + simulator.DefaultEpochLength - 1)
+
+	uptime := func() float64 {
+		t.Helper()
+		performance, err := s.GetValidatorPerformance()
+		if err != nil {
+			t.Fatalf("GetValidatorPerformance: %s", err)
+		}
+		if len(performance) != 1 || performance[0].EpochHeight != 0 {
+			t.Fatalf("performance %+v, want a.test.near in epoch 0", performance)
+		}
+		return performance[0].Uptime
+	}
+	if u := uptime(); u != 1 {
+		t.Errorf("uptime %v, want 1", u)
+	}
+	// the stats of a completed epoch are final, they are not queried again in the same epoch
+	sim.SetUptime("a.test.near", 0.5)
+	if u := uptime(); u != 1 {
+		t.Errorf("uptime %v in the same epoch, want the cached 1", u)
+	}
+	sim.AdvanceEpoch(0.5)
+	performance, err := s.GetValidatorPerformance()
+	if err != nil {
+		t.Fatalf("GetValidatorPerformance: %s", err)
+	}
+	if len(performance) != 1 || performance[0].EpochHeight != 1 || performance[0].Uptime != 0.5 {
+		t.Errorf("performance %+v, want uptime 0.5 in epoch 1", performance)
+	}
+}
+
+func TestIncreaseStakeSkipsHighRewardFee(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Allocation.MaxRewardFee = 0.1
+	})
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AddValidator("b.test.near", decimal.Zero, false)
+	sim.AddValidator("c.test.near", decimal.Zero, false)
+	sim.SetRewardFee("b.test.near", 15, 100)
+	// a broken fee of c fails its get_reward_fee_fraction
+	sim.SetRewardFee("c.test.near", 1, 0)
+	sim.Deposit(near(30))
+
+	fees, err := s.GetValidatorFees()
+	if err != nil {
+		t.Fatalf("GetValidatorFees: %s", err)
+	}
+	got := map[string]string{}
+	for _, f := range fees {
+		got[f.AccountID] = f.Fraction().String()
+	}
+	if len(got) != 2 || got["a.test.near"] != "0.1" || got["b.test.near"] != "0.15" {
+		t.Errorf("fees: %v", got)
+	}
+
+	runEpoch(t, sim, s)
+	for _, v := range sim.Contract().Validators {
+		want := decimal.Zero
+		if v.AccountID == "a.test.near" {
+			want = near(30)
+		}
+		if !v.ClassicStakedBalance.Equal(want) {
+			t.Errorf("%s: classic staked %s, want %s", v.AccountID, v.ClassicStakedBalance, want)
+		}
+	}
+}
+
+func TestBatchedCalls(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Batch = config.BatchConfig{Gas: 300, ActionGas: 100}
+	})
+	for _, id := range []string{"a.test.near", "b.test.near", "c.test.near", "d.test.near"} {
+		sim.AddValidator(id, decimal.New(1, -2), false)
+	}
+	sim.Deposit(near(40))
+	runEpoch(t, sim, s)
+
+	txs := map[string]map[string]int{}
+	for _, c := range sim.Calls() {
+		if txs[c.Method] == nil {
+			txs[c.Method] = map[string]int{}
+		}
+		txs[c.Method][c.TxHash]++
+	}
+	// 4 validators in batches of 3 actions
+	if n := len(txs["update_validator"]); n != 2 {
+		t.Errorf("update_validator sent in %d transactions, want 2", n)
+	}
+	if n := len(txs["increase_validator_stake"]); n != 2 {
+		t.Errorf("increase_validator_stake sent in %d transactions, want 2", n)
+	}
+	for method, hashes := range txs {
+		for hash, n := range hashes {
+			if n > 1 && !map[string]bool{"update_validator": true, "increase_validator_stake": true}[method] {
+				t.Errorf("%s batched %d times in %s", method, n, hash)
+			}
+		}
+	}
+	for _, v := range sim.Contract().Validators {
+		if !v.ClassicStakedBalance.Equal(near(10)) {
+			t.Errorf("%s: classic staked %s, want 10 NEAR", v.AccountID, v.ClassicStakedBalance)
+		}
+	}
+}
+
+func TestBatchedCallResults(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Batch = config.BatchConfig{Gas: 300, ActionGas: 100}
+	})
+	for _, id := range []string{"a.test.near", "b.test.near", "c.test.near"} {
+		sim.AddValidator(id, decimal.Zero, false)
+	}
+	sim.Deposit(near(30))
+	sim.AdvanceEpoch(0.01)
+	if err := s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+
+	// the first call of the batch reports a failure, which is not the value of the transaction
+	sim.FailCalls("a.test.near")
+	sim.SetEpochProgress(0.9)
+	err := s.IncreaseStake()
+	if err == nil || !strings.Contains(err.Error(), "validator:a.test.near") || !strings.Contains(err.Error(), "false result") {
+		t.Fatalf("IncreaseStake: %v, want a false result of a.test.near", err)
+	}
+	txs := map[string]bool{}
+	for _, c := range sim.Calls() {
+		if c.Method == "increase_validator_stake" {
+			txs[c.TxHash] = true
+		}
+	}
+	if len(txs) != 1 {
+		t.Errorf("increase_validator_stake sent in %d transactions, want 1", len(txs))
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	sim := newTestSimulator(t)
+	var extra []string
+	for i := 0; i < 2; i++ {
+		keyPair, err := key.GenerateKeyPair(key.KeyTypeED25519, rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKeyPair: %s", err)
+		}
+		extra = append(extra, keyPair.PrivateEncoded())
+	}
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.ExtraKeyPairs = extra
+		cfg.MaxConcurrentTxs = 3
+	})
+	for _, id := range []string{"a.test.near", "b.test.near", "c.test.near", "d.test.near", "e.test.near", "f.test.near"} {
+		sim.AddValidator(id, decimal.Zero, false)
+	}
+	sim.Deposit(near(60))
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			sim.RequestWithdrawal(near(12))
+		}
+		// the simulator rejects update before every validator is updated and increases after the confirmation
+		runEpoch(t, sim, s)
+	}
+
+	keys := map[string]bool{}
+	for _, c := range sim.Calls() {
+		if c.Error != nil {
+			t.Errorf("%s failed: %s", c.Method, c.Error)
+		}
+		keys[c.PublicKey] = true
+	}
+	if len(keys) < 2 {
+		t.Errorf("transactions signed by %d keys, want several", len(keys))
+	}
+	state, fund := sim.Contract(), sim.Fund()
+	if state.PoolEpochHeight != state.NetworkEpochHeight || !state.RequestedClassic.IsZero() || !fund.ClassicStakedBalance.Equal(near(48)) {
+		t.Errorf("pool epoch %d, network epoch %d, requested %s, classic staked %s",
+			state.PoolEpochHeight, state.NetworkEpochHeight, state.RequestedClassic, fund.ClassicStakedBalance)
+	}
+}
+
+func TestRetriesStaleNonce(t *testing.T) {
+	sim := newTestSimulator(t)
+	var encoded string
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Retry = config.RetryConfig{Attempts: 3, Delay: time.Millisecond}
+		encoded = cfg.KeyPair
+	})
+	keyPair, err := key.NewBase58KeyPair(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Deposit(near(10))
+	runEpoch(t, sim, s)
+
+	// another client signs with the key, so the cached nonce is stale
+	sim.UseNonce(keyPair.PublicKey.String())
+	runEpoch(t, sim, s)
+	if state := sim.Contract(); state.PoolEpochHeight != state.NetworkEpochHeight {
+		t.Errorf("pool epoch %d, network epoch %d", state.PoolEpochHeight, state.NetworkEpochHeight)
+	}
+}
+
+func TestFailsOverToHealthyNode(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim, func(cfg *config.Config) {
+		cfg.Node = "http://127.0.0.1:1"
+		cfg.Nodes = []string{sim.URL()}
+	})
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Deposit(near(10))
+	runEpoch(t, sim, s)
+
+	nodes := s.Nodes()
+	if len(nodes) != 2 || nodes[0].Healthy || !nodes[1].Healthy || !nodes[1].Active {
+		t.Errorf("nodes %+v, want the simulator active", nodes)
+	}
+}
+
+func TestCatchUpAfterMissedUnstakeWindow(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.AddValidator("b.test.near", decimal.Zero, false)
+	sim.Deposit(near(200))
+	for epoch := 1; epoch <= 3; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	sim.RequestWithdrawal(near(60))
+
+	// the daemon is down during the unstake window of epoch 4
+	sim.AdvanceEpoch(0.5)
+	sim.AdvanceEpoch(0.01)
+	status, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status: %s", err)
+	}
+	if c := status.CatchUp; c == nil || c.MissedEpochs != 1 || len(c.MissedUnstakeEpochs) != 1 || c.MissedUnstakeEpochs[0] != 4 {
+		t.Fatalf("catch-up %+v, want 1 missed epoch with the unstake window 4", c)
+	}
+	if err = s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate (catch-up): %s", err)
+	}
+	state := sim.Contract()
+	if state.PoolEpochHeight != 5 || !state.RequestedClassic.IsZero() {
+		t.Fatalf("after catch-up: pool epoch %d, requested classic %s", state.PoolEpochHeight, state.RequestedClassic)
+	}
+
+	// the balance unstaked late is still locked in the window of epoch 8
+	for epoch := 6; epoch <= 8; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	if state = sim.Contract(); !state.Withdrawn.IsZero() {
+		t.Errorf("epoch 8: withdrawn %s, want 0", state.Withdrawn)
+	}
+	for epoch := 9; epoch <= 12; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	if state = sim.Contract(); !state.Withdrawn.Equal(near(60)) {
+		t.Errorf("epoch 12: withdrawn %s, want %s", state.Withdrawn, near(60))
+	}
+	for _, c := range sim.Calls() {
+		if c.Error != nil {
+			t.Errorf("call %s(%s) failed: %s", c.Method, c.Args, c.Error)
+		}
+	}
+}
+
+func TestUnstakeIncludesEpochRewards(t *testing.T) {
+	sim := newTestSimulator(t)
+	s := newTestService(t, sim)
+	sim.AddValidator("a.test.near", decimal.NewFromFloat(0.1), false)
+	sim.Deposit(near(100))
+	for epoch := 1; epoch <= 3; epoch++ {
+		runEpoch(t, sim, s)
+	}
+	// the whole stake with the rewards of the unstake window epoch
+	stake := sim.Contract().Validators[0].ClassicStakedBalance
+	requested := stake.Add(stake.Mul(decimal.NewFromFloat(0.1)).Truncate(0))
+	sim.RequestWithdrawal(requested)
+
+	sim.AdvanceEpoch(0.01)
+	if err := s.PoolUpdate(); err != nil {
+		t.Fatalf("PoolUpdate: %s", err)
+	}
+	state := sim.Contract()
+	if !state.RequestedClassic.IsZero() || !state.Validators[0].UnstakedBalance.Equal(requested) {
+		t.Errorf("requested classic %s left, unstaked %s, want %s", state.RequestedClassic, state.Validators[0].UnstakedBalance, requested)
+	}
+}
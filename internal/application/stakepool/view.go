@@ -0,0 +1,65 @@
+package stakepool
+
+import (
+	"github.com/pkg/errors"
+)
+
+func (s *Service) GetEpochHeightRegistry() (epochs EpochHeightRegistry, err error) {
+	err = s.callContractWithUnmarshal("get_current_epoch_height", "", &epochs)
+	if err != nil {
+		return epochs, errors.Wrap(err, "callContractWithUnmarshal(get_current_epoch_height)")
+	}
+	return epochs, nil
+}
+
+func (s *Service) GetValidatorRegistry() (validators []Validator, err error) {
+	err = s.callContractWithUnmarshal("get_validator_registry", "", &validators)
+	if err != nil {
+		return nil, errors.Wrap(err, "callContractWithUnmarshal(get_validator_registry)")
+	}
+	return validators, nil
+}
+
+func (s *Service) GetFund() (fund Fund, err error) {
+	err = s.callContractWithUnmarshal("get_fund", "", &fund)
+	if err != nil {
+		return fund, errors.Wrap(err, "callContractWithUnmarshal(get_fund)")
+	}
+	return fund, nil
+}
+
+func (s *Service) GetRequestedToWithdrawalFund() (fund RequestedToWithdrawalFund, err error) {
+	err = s.callContractWithUnmarshal("get_requested_to_withdrawal_fund", "", &fund)
+	if err != nil {
+		return fund, errors.Wrap(err, "callContractWithUnmarshal(get_requested_to_withdrawal_fund)")
+	}
+	return fund, nil
+}
+
+func (s *Service) GetAggInfo() (info AggInfo, err error) {
+	err = s.callContractWithUnmarshal("get_aggregated_info", "", &info)
+	if err != nil {
+		return info, errors.Wrap(err, "callContractWithUnmarshal(get_aggregated_info)")
+	}
+	return info, nil
+}
+
+func (s *Service) IsStakeDistributed() (isDistributed bool, err error) {
+	err = s.callContractWithUnmarshal("is_stake_distributed", "", &isDistributed)
+	if err != nil {
+		return false, errors.Wrap(err, "callContractWithUnmarshal(is_stake_distributed)")
+	}
+	return isDistributed, nil
+}
+
+func (s *Service) getEpochsAndValidators() (epochs EpochHeightRegistry, validators []Validator, err error) {
+	epochs, err = s.GetEpochHeightRegistry()
+	if err != nil {
+		return epochs, nil, errors.Wrap(err, "GetEpochHeightRegistry")
+	}
+	validators, err = s.GetValidatorRegistry()
+	if err != nil {
+		return epochs, nil, errors.Wrap(err, "GetValidatorRegistry")
+	}
+	return epochs, validators, nil
+}
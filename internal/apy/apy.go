@@ -0,0 +1,138 @@
+// Package apy calculates the realized pool APY from the history of the NEAR-per-token exchange rate.
+package apy
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"math"
+	"time"
+)
+
+const (
+	ratePrecision = 24
+	year          = 365 * 24 * time.Hour
+)
+
+// ErrNoData is returned when no exchange rate is recorded yet.
+var ErrNoData = errors.New("no exchange rate history")
+
+// Periods are the windows the APY is calculated over.
+var Periods = []Period{
+	{Name: "1_epoch", Epochs: 1},
+	{Name: "7_epochs", Epochs: 7},
+	{Name: "30_epochs", Epochs: 30},
+	{Name: "30_days", Duration: 30 * 24 * time.Hour},
+}
+
+type (
+	// Point is the exchange rate observed in a pool epoch.
+	Point struct {
+		PoolEpochHeight uint64              `json:"pool_epoch_height"`
+		Rate            decimal.Decimal     `json:"rate"`
+		RewardFee       *stakepool.Dividing `json:"reward_fee"`
+		ObservedAt      time.Time           `json:"observed_at"`
+	}
+	// Source is the recorded exchange rate history.
+	Source interface {
+		LatestExchangeRate() (Point, bool, error)
+		// ExchangeRateAtEpoch returns the latest point of the epoch or before it.
+		ExchangeRateAtEpoch(poolEpochHeight uint64) (Point, bool, error)
+		// ExchangeRateAt returns the latest point observed at t or before it.
+		ExchangeRateAt(t time.Time) (Point, bool, error)
+	}
+	// Period is either a number of epochs or a duration.
+	Period struct {
+		Name     string
+		Epochs   uint64
+		Duration time.Duration
+	}
+	Report struct {
+		PoolEpochHeight uint64          `json:"pool_epoch_height"`
+		ExchangeRate    decimal.Decimal `json:"exchange_rate"`
+		RewardFee       float64         `json:"reward_fee"`
+		Windows         []Window        `json:"windows"`
+	}
+	// Window is the APY realized over a period, unavailable until the history covers it.
+	Window struct {
+		Period          string  `json:"period"`
+		Available       bool    `json:"available"`
+		FromEpochHeight uint64  `json:"from_epoch_height,omitempty"`
+		Days            float64 `json:"days,omitempty"`
+		GrossAPY        float64 `json:"gross_apy"`
+		NetAPY          float64 `json:"net_apy"`
+	}
+)
+
+// ExchangeRate is NEAR per pool token, false if no tokens are minted.
+func ExchangeRate(agg stakepool.AggInfo) (decimal.Decimal, bool) {
+	if !agg.TokenTotalSupply.IsPositive() {
+		return decimal.Zero, false
+	}
+	return agg.StakedBalance.Add(agg.UnstakedBalance).DivRound(agg.TokenTotalSupply, ratePrecision), true
+}
+
+// FeeFraction returns the reward fee as a fraction of rewards.
+func FeeFraction(fee *stakepool.Dividing) float64 {
+	if fee == nil {
+		return 0
+	}
+	f, _ := fee.GetValue().Float64()
+	return f
+}
+
+// Calculate reports the APY over all Periods ending at the latest recorded rate.
+func Calculate(src Source) (report Report, err error) {
+	latest, ok, err := src.LatestExchangeRate()
+	if err != nil {
+		return report, errors.Wrap(err, "LatestExchangeRate")
+	}
+	if !ok {
+		return report, ErrNoData
+	}
+	report = Report{
+		PoolEpochHeight: latest.PoolEpochHeight,
+		ExchangeRate:    latest.Rate,
+		RewardFee:       FeeFraction(latest.RewardFee),
+	}
+	for _, period := range Periods {
+		var (
+			base Point
+			ok   bool
+		)
+		if period.Epochs != 0 {
+			if latest.PoolEpochHeight >= period.Epochs {
+				base, ok, err = src.ExchangeRateAtEpoch(latest.PoolEpochHeight - period.Epochs)
+			}
+		} else {
+			base, ok, err = src.ExchangeRateAt(latest.ObservedAt.Add(-period.Duration))
+		}
+		if err != nil {
+			return report, errors.Wrapf(err, "base of %s", period.Name)
+		}
+		window := Window{Period: period.Name}
+		if ok {
+			window = calculateWindow(period.Name, base, latest, report.RewardFee)
+		}
+		report.Windows = append(report.Windows, window)
+	}
+	return report, nil
+}
+
+// calculateWindow annualizes the rate growth between the points. Holders keep (1 - fee) of the rewards,
+// so the gross growth is the net growth divided by it.
+func calculateWindow(period string, base, latest Point, fee float64) Window {
+	window := Window{Period: period}
+	elapsed := latest.ObservedAt.Sub(base.ObservedAt)
+	if elapsed <= 0 || !base.Rate.IsPositive() || fee >= 1 {
+		return window
+	}
+	growth, _ := latest.Rate.DivRound(base.Rate, ratePrecision).Sub(decimal.NewFromInt(1)).Float64()
+	years := float64(elapsed) / float64(year)
+	window.Available = true
+	window.FromEpochHeight = base.PoolEpochHeight
+	window.Days = elapsed.Hours() / 24
+	window.NetAPY = math.Pow(1+growth, 1/years) - 1
+	window.GrossAPY = math.Pow(1+growth/(1-fee), 1/years) - 1
+	return window
+}
@@ -0,0 +1,98 @@
+package apy_test
+
+import (
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/apy"
+	"math"
+	"testing"
+	"time"
+)
+
+// memorySource keeps points ordered by epoch and by time.
+type memorySource []apy.Point
+
+func (m memorySource) LatestExchangeRate() (apy.Point, bool, error) {
+	if len(m) == 0 {
+		return apy.Point{}, false, nil
+	}
+	return m[len(m)-1], true, nil
+}
+
+func (m memorySource) ExchangeRateAtEpoch(epoch uint64) (apy.Point, bool, error) {
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].PoolEpochHeight <= epoch {
+			return m[i], true, nil
+		}
+	}
+	return apy.Point{}, false, nil
+}
+
+func (m memorySource) ExchangeRateAt(t time.Time) (apy.Point, bool, error) {
+	for i := len(m) - 1; i >= 0; i-- {
+		if !m[i].ObservedAt.After(t) {
+			return m[i], true, nil
+		}
+	}
+	return apy.Point{}, false, nil
+}
+
+func TestCalculate(t *testing.T) {
+	const (
+		epochs      = 70
+		epochGrowth = 0.0002
+	)
+	var (
+		start = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		fee   = &stakepool.Dividing{Numerator: decimal.NewFromInt(10), Denominator: decimal.NewFromInt(100)}
+		src   memorySource
+	)
+	rate := decimal.NewFromInt(1)
+	for e := uint64(100); e < 100+epochs; e++ {
+		src = append(src, apy.Point{
+			PoolEpochHeight: e,
+			Rate:            rate,
+			RewardFee:       fee,
+			ObservedAt:      start.Add(time.Duration(e-100) * 12 * time.Hour),
+		})
+		rate = rate.Mul(decimal.NewFromFloat(1 + epochGrowth))
+	}
+
+	report, err := apy.Calculate(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PoolEpochHeight != 169 || report.RewardFee != 0.1 {
+		t.Fatalf("report: %+v", report)
+	}
+	wantNet := math.Pow(1+epochGrowth, 2*365) - 1
+	wantGross := math.Pow(1+epochGrowth/0.9, 2*365) - 1
+	for _, w := range report.Windows {
+		if !w.Available {
+			t.Errorf("%s: not available", w.Period)
+			continue
+		}
+		// compounding per window differs slightly from compounding per epoch
+		if math.Abs(w.NetAPY-wantNet) > 0.001 || math.Abs(w.GrossAPY-wantGross) > 0.001 {
+			t.Errorf("%s: got net %f gross %f, want net %f gross %f", w.Period, w.NetAPY, w.GrossAPY, wantNet, wantGross)
+		}
+	}
+	if w := report.Windows[3]; w.FromEpochHeight != 109 || w.Days != 30 {
+		t.Errorf("30 days window: %+v", w)
+	}
+
+	// a short history covers only the short windows
+	report, err = apy.Calculate(src[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range report.Windows {
+		if w.Available != (w.Period == "1_epoch") {
+			t.Errorf("%s: available %t", w.Period, w.Available)
+		}
+	}
+
+	if _, err = apy.Calculate(memorySource{}); err != apy.ErrNoData {
+		t.Errorf("empty history: got %v", err)
+	}
+}
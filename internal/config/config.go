@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
+	"time"
 )
 
 const envPath = "./.env"
@@ -15,6 +16,116 @@ type (
 		StakePool        string `split_words:"true"`
 		KeyPair          string `split_words:"true"`
 		KeyPairAccountID string `split_words:"true"`
+		// Nodes are more RPC endpoints to fail over to, Node is preferred.
+		Nodes []string `split_words:"true"`
+		// NodeProbe checks the health of the RPC endpoints, read with the NODE_PROBE_ prefix.
+		NodeProbe NodeProbeConfig `split_words:"true"`
+		// ExtraKeyPairs are more function call access keys of KeyPairAccountID to send transactions concurrently.
+		ExtraKeyPairs []string `split_words:"true"`
+		// MaxConcurrentTxs limits transactions sent at once, at most one per access key.
+		MaxConcurrentTxs int `split_words:"true" default:"1"`
+		// MetricsAddr is a listen address of the Prometheus endpoint, disabled if empty.
+		MetricsAddr string `split_words:"true"`
+		// HTTPAddr is a listen address of the REST API, disabled if empty.
+		HTTPAddr string `split_words:"true"`
+		// DBDriver is postgres or sqlite.
+		DBDriver string `split_words:"true" default:"postgres"`
+		// DBDSN is a data source name of the database, storage is disabled if empty.
+		DBDSN string `envconfig:"DB_DSN"`
+		// JournalDir keeps the progress of multi-transaction jobs to resume them after a restart, disabled if empty.
+		JournalDir string `split_words:"true"`
+		// PriceURL is a base URL of a CoinGecko compatible API, USD valuation is disabled if empty.
+		PriceURL string      `split_words:"true"`
+		Alert    AlertConfig `split_words:"true"`
+		// Allocation selects how IncreaseStake splits stake among validators, read with the ALLOCATION_ prefix.
+		Allocation AllocationConfig `split_words:"true"`
+		// Batch packs several contract calls into one transaction, read with the BATCH_ prefix.
+		Batch BatchConfig `split_words:"true"`
+		// Schedule times the jobs by the epoch progress, read with the SCHEDULE_ prefix.
+		Schedule ScheduleConfig `split_words:"true"`
+		// Retry repeats chain calls failed with transient errors, read with the RETRY_ prefix.
+		Retry RetryConfig `split_words:"true"`
+		// Unstake selects how classic withdrawals are unstaked from validators, read with the UNSTAKE_ prefix.
+		Unstake UnstakeConfig `split_words:"true"`
+	}
+	AllocationConfig struct {
+		// Strategy is fill-lowest, equal or target-weights.
+		Strategy string `default:"fill-lowest"`
+		// Weights are target weights by validator account, e.g. a.poolv1.near:2,b.poolv1.near:1.
+		Weights map[string]float64
+		// MaxShare caps a validator share of the total classic stake, e.g. 0.2, no cap if zero.
+		MaxShare float64 `split_words:"true"`
+		// MinStake is the smallest stake increase in NEAR.
+		MinStake float64 `split_words:"true" default:"1"`
+		// StakeFloor excludes validators with less classic stake in NEAR.
+		StakeFloor float64 `split_words:"true"`
+		// MinUptime excludes validators with a lower uptime in the last completed epoch, e.g. 0.95, disabled if zero.
+		MinUptime float64 `split_words:"true"`
+		// MaxRewardFee excludes validators charging a higher reward fee, e.g. 0.1, disabled if zero.
+		MaxRewardFee float64 `split_words:"true"`
+		// RewardFeePenalty scales validator weights by the share of rewards left after the fee.
+		RewardFeePenalty bool `split_words:"true"`
+	}
+	BatchConfig struct {
+		// Gas is the gas budget of a batched transaction in Tgas, batching is disabled if zero.
+		Gas uint64
+		// ActionGas is the gas of every function call in a batch in Tgas.
+		ActionGas uint64 `split_words:"true" default:"50"`
+	}
+	NodeProbeConfig struct {
+		// Interval is how often the endpoints are probed, disabled if zero.
+		Interval time.Duration `default:"30s"`
+		// Timeout limits a probe of an endpoint.
+		Timeout time.Duration `default:"5s"`
+		// MaxLag is how many blocks an endpoint may lag the highest one.
+		MaxLag uint64 `split_words:"true" default:"10"`
+	}
+	ScheduleConfig struct {
+		// DistributionWindow is the share of the last blocks of an epoch IncreaseStake runs in.
+		DistributionWindow float64 `split_words:"true" default:"0.15"`
+		// BoundaryDelay delays PoolUpdate after an epoch boundary.
+		BoundaryDelay time.Duration `split_words:"true" default:"30s"`
+		// BlockTime is the expected block time until it is measured.
+		BlockTime time.Duration `split_words:"true" default:"1.2s"`
+		// MaxSleep caps the time between epoch progress checks.
+		MaxSleep time.Duration `split_words:"true" default:"10m"`
+		// RetryDelay is the delay after a failed job, doubled with every failure up to MaxSleep.
+		RetryDelay time.Duration `split_words:"true" default:"30s"`
+		// WindowInterval is how often IncreaseStake runs in the distribution window until the stake is distributed.
+		WindowInterval time.Duration `split_words:"true" default:"5m"`
+		// MonitorInterval is how often the status is exported to metrics and checked for alerts.
+		MonitorInterval time.Duration `split_words:"true" default:"1m"`
+	}
+	RetryConfig struct {
+		// Attempts is the max number of attempts of a call, one disables retries.
+		Attempts int `default:"5"`
+		// Delay is the delay before the first retry, doubled with every attempt.
+		Delay time.Duration `default:"500ms"`
+		// MaxDelay caps the delay between attempts.
+		MaxDelay time.Duration `split_words:"true" default:"10s"`
+	}
+	UnstakeConfig struct {
+		// Policy is most-overweighted, proportional or min-tx.
+		Policy string `default:"most-overweighted"`
+		// MinRemaining is the smallest classic stake in NEAR left on a partially unstaked validator.
+		MinRemaining float64 `split_words:"true"`
+	}
+	// AlertConfig enables notifiers by their settings, read with the ALERT_ prefix.
+	AlertConfig struct {
+		WebhookURL       string   `split_words:"true"`
+		SlackWebhookURL  string   `split_words:"true"`
+		TelegramURL      string   `split_words:"true" default:"https://api.telegram.org"`
+		TelegramBotToken string   `split_words:"true"`
+		TelegramChatID   string   `split_words:"true"`
+		SMTPAddr         string   `envconfig:"SMTP_ADDR"`
+		SMTPUsername     string   `envconfig:"SMTP_USERNAME"`
+		SMTPPassword     string   `envconfig:"SMTP_PASSWORD"`
+		SMTPFrom         string   `envconfig:"SMTP_FROM"`
+		SMTPTo           []string `envconfig:"SMTP_TO"`
+		// RepeatInterval is how often an unresolved alert is repeated.
+		RepeatInterval time.Duration `split_words:"true" default:"6h"`
+		// JobFailures is a number of consecutive job failures to alert on.
+		JobFailures int `split_words:"true" default:"3"`
 	}
 )
 
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"testing"
+)
+
+// TestEnvExample keeps the example env file loadable, envconfig parses every key which is set.
+func TestEnvExample(t *testing.T) {
+	env, err := godotenv.Read("../../.env.example")
+	if err != nil {
+		t.Fatalf("godotenv.Read: %s", err)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	var cfg Config
+	err = envconfig.Process("", &cfg)
+	if err != nil {
+		t.Fatalf("envconfig.Process: %s", err)
+	}
+	if len(cfg.ExtraKeyPairs) != 0 {
+		t.Errorf("extra key pairs %q, want none", cfg.ExtraKeyPairs)
+	}
+}
@@ -0,0 +1,312 @@
+// Package metrics exports the operator state in the Prometheus format.
+package metrics
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/apy"
+	"net/http"
+	"time"
+)
+
+const namespace = "lido_near"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	poolEpochHeight    prometheus.Gauge
+	networkEpochHeight prometheus.Gauge
+	epochLag           prometheus.Gauge
+	epochProgress      prometheus.Gauge
+	validatorBalance   *prometheus.GaugeVec
+	fundBalance        *prometheus.GaugeVec
+	operatorBalance    prometheus.Gauge
+	validatorUptime    *prometheus.GaugeVec
+	validatorFee       *prometheus.GaugeVec
+	nodeHealthy        *prometheus.GaugeVec
+	nodeActive         *prometheus.GaugeVec
+	nodeBlockHeight    *prometheus.GaugeVec
+	exchangeRate       prometheus.Gauge
+	nearPrice          prometheus.Gauge
+	valuation          *prometheus.GaugeVec
+	apy                *prometheus.GaugeVec
+
+	jobRuns     *prometheus.CounterVec
+	jobFailures *prometheus.CounterVec
+	jobSkips    *prometheus.CounterVec
+	jobDuration *prometheus.HistogramVec
+
+	transactions *prometheus.CounterVec
+	gasBurnt     *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		poolEpochHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pool_epoch_height",
+			Help:      "Epoch height the stake pool is updated to.",
+		}),
+		networkEpochHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "network_epoch_height",
+			Help:      "Current network epoch height.",
+		}),
+		epochLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "epoch_lag",
+			Help:      "Number of epochs the stake pool is behind the network.",
+		}),
+		epochProgress: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "epoch_progress_ratio",
+			Help:      "Share of the current epoch passed.",
+		}),
+		validatorBalance: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "validator_balance_near",
+			Help:      "Validator balances in NEAR by type: classic_staked, investment_staked, unstaked.",
+		}, []string{"validator", "type"}),
+		fundBalance: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "fund_balance_near",
+			Help:      "Stake pool fund components in NEAR.",
+		}, []string{"component"}),
+		operatorBalance: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "operator_balance_near",
+			Help:      "Balance of the operator account in NEAR.",
+		}),
+		validatorUptime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "validator_uptime_ratio",
+			Help:      "Validator uptime in the last completed epoch, produced to expected blocks and chunks.",
+		}, []string{"validator"}),
+		validatorFee: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "validator_reward_fee_ratio",
+			Help:      "Reward fee fraction of the validator staking pool.",
+		}, []string{"validator"}),
+		nodeHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpc_node_healthy",
+			Help:      "1 if the RPC endpoint passed the latest health probe.",
+		}, []string{"node"}),
+		nodeActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpc_node_active",
+			Help:      "1 for the RPC endpoint the calls are sent to.",
+		}, []string{"node"}),
+		nodeBlockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpc_node_block_height",
+			Help:      "Latest block height of the RPC endpoint in the latest health probe.",
+		}, []string{"node"}),
+		nearPrice: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "near_price_usd",
+			Help:      "NEAR price in USD.",
+		}),
+		valuation: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "valuation_usd",
+			Help:      "Pool values in USD: tvl, accrued_rewards, operator_epoch_gas_spend.",
+		}, []string{"value"}),
+		exchangeRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exchange_rate",
+			Help:      "NEAR per pool token in the latest updated epoch.",
+		}),
+		apy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "apy_ratio",
+			Help:      "Realized APY over the period, gross or net of the reward fee.",
+		}, []string{"period", "kind"}),
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_runs_total",
+			Help:      "Number of job runs.",
+		}, []string{"job"}),
+		jobFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_failures_total",
+			Help:      "Number of failed job runs.",
+		}, []string{"job"}),
+		jobSkips: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_skips_total",
+			Help:      "Number of job runs skipped because another job was running or a dependency was not met.",
+		}, []string{"job"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Duration of job runs.",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"job"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_total",
+			Help:      "Number of transactions sent by contract method and status.",
+		}, []string{"method", "status"}),
+		gasBurnt: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transaction_gas_burnt_total",
+			Help:      "Gas burnt by transactions by contract method.",
+		}, []string{"method"}),
+	}
+	m.registry.MustRegister(
+		m.poolEpochHeight,
+		m.networkEpochHeight,
+		m.epochLag,
+		m.epochProgress,
+		m.validatorBalance,
+		m.fundBalance,
+		m.operatorBalance,
+		m.validatorUptime,
+		m.validatorFee,
+		m.nodeHealthy,
+		m.nodeActive,
+		m.nodeBlockHeight,
+		m.nearPrice,
+		m.valuation,
+		m.exchangeRate,
+		m.apy,
+		m.jobRuns,
+		m.jobFailures,
+		m.jobSkips,
+		m.jobDuration,
+		m.transactions,
+		m.gasBurnt,
+	)
+	return m
+}
+
+// ObserveStatus updates pool gauges from the status snapshot.
+func (m *Metrics) ObserveStatus(status stakepool.Status) {
+	m.poolEpochHeight.Set(float64(status.Epochs.PoolEpochHeight))
+	m.networkEpochHeight.Set(float64(status.Epochs.NetworkEpochHeight))
+	m.epochLag.Set(float64(status.Epochs.NetworkEpochHeight) - float64(status.Epochs.PoolEpochHeight))
+	m.epochProgress.Set(status.EpochProgress.Progress)
+
+	m.validatorBalance.Reset()
+	for _, v := range status.Validators {
+		m.validatorBalance.WithLabelValues(v.AccountID, "classic_staked").Set(toNear(v.ClassicStakedBalance))
+		m.validatorBalance.WithLabelValues(v.AccountID, "investment_staked").Set(toNear(v.InvestmentStakedBalance))
+		m.validatorBalance.WithLabelValues(v.AccountID, "unstaked").Set(toNear(v.UnstakedBalance))
+	}
+
+	fund := status.Fund
+	m.fundBalance.WithLabelValues("classic_unstaked").Set(toNear(fund.ClassicUnstakedBalance))
+	m.fundBalance.WithLabelValues("classic_staked").Set(toNear(fund.ClassicStakedBalance))
+	m.fundBalance.WithLabelValues("investment_staked").Set(toNear(fund.InvestmentStakedBalance))
+	m.fundBalance.WithLabelValues("common_staked").Set(toNear(fund.CommonStakedBalance))
+	m.fundBalance.WithLabelValues("common").Set(toNear(fund.CommonBalance))
+
+	m.operatorBalance.Set(toNear(status.OperatorBalance))
+
+	for _, n := range status.Nodes {
+		m.nodeHealthy.WithLabelValues(n.URL).Set(boolToFloat(n.Healthy))
+		m.nodeActive.WithLabelValues(n.URL).Set(boolToFloat(n.Active))
+		m.nodeBlockHeight.WithLabelValues(n.URL).Set(float64(n.BlockHeight))
+	}
+
+	m.valuation.Reset()
+	if v := status.Valuation; v != nil {
+		m.nearPrice.Set(v.NearPriceUSD.InexactFloat64())
+		m.valuation.WithLabelValues("tvl").Set(v.TVL.InexactFloat64())
+		m.valuation.WithLabelValues("accrued_rewards").Set(v.AccruedRewardsUSD.InexactFloat64())
+		if v.OperatorGasSpendUSD.Valid {
+			m.valuation.WithLabelValues("operator_epoch_gas_spend").Set(v.OperatorGasSpendUSD.Decimal.InexactFloat64())
+		}
+	}
+}
+
+// ObserveValidatorPerformance updates the uptime gauges.
+func (m *Metrics) ObserveValidatorPerformance(performance []stakepool.ValidatorPerformance) {
+	m.validatorUptime.Reset()
+	for _, p := range performance {
+		m.validatorUptime.WithLabelValues(p.AccountID).Set(p.Uptime)
+	}
+}
+
+// ObserveValidatorFees updates the reward fee gauges.
+func (m *Metrics) ObserveValidatorFees(fees []stakepool.ValidatorFee) {
+	m.validatorFee.Reset()
+	for _, f := range fees {
+		fee, _ := f.Fraction().Float64()
+		m.validatorFee.WithLabelValues(f.AccountID).Set(fee)
+	}
+}
+
+// ObserveAPY updates the exchange rate and APY gauges from the report.
+func (m *Metrics) ObserveAPY(report apy.Report) {
+	rate, _ := report.ExchangeRate.Float64()
+	m.exchangeRate.Set(rate)
+	m.apy.Reset()
+	for _, w := range report.Windows {
+		if !w.Available {
+			continue
+		}
+		m.apy.WithLabelValues(w.Period, "gross").Set(w.GrossAPY)
+		m.apy.WithLabelValues(w.Period, "net").Set(w.NetAPY)
+	}
+}
+
+// ObserveJob runs the job and records its duration and result.
+func (m *Metrics) ObserveJob(job string, run func() error) error {
+	t := time.Now()
+	err := run()
+	m.jobRuns.WithLabelValues(job).Inc()
+	m.jobDuration.WithLabelValues(job).Observe(time.Since(t).Seconds())
+	if err != nil {
+		m.jobFailures.WithLabelValues(job).Inc()
+	}
+	return err
+}
+
+// ObserveJobSkip counts a skipped job run.
+func (m *Metrics) ObserveJobSkip(job string) {
+	m.jobSkips.WithLabelValues(job).Inc()
+}
+
+// ObserveTransaction implements stakepool.Observer.
+func (m *Metrics) ObserveTransaction(result stakepool.StepResult, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	m.transactions.WithLabelValues(result.Step.Method, status).Inc()
+	m.gasBurnt.WithLabelValues(result.Step.Method).Add(float64(result.GasBurnt))
+}
+
+// Serve exposes /metrics on the address until ctx is done.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "ListenAndServe")
+	}
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func toNear(amount decimal.Decimal) float64 {
+	return amount.Div(decimal.New(1, 24)).InexactFloat64()
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/eteu-technologies/near-api-go/pkg/types/key"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/application/stakepool/simulator"
+	"lido-near-client/internal/config"
+	"testing"
+)
+
+func TestObserveStatus(t *testing.T) {
+	sim := simulator.New(simulator.Params{
+		StakePool:       "pool.test.near",
+		Operator:        "operator.test.near",
+		OperatorBalance: decimal.New(5, 24),
+	})
+	defer sim.Close()
+	sim.AddValidator("a.test.near", decimal.Zero, false)
+	sim.Update(func(c *simulator.Contract) {
+		c.Validators[0].ClassicStakedBalance = decimal.New(100, 24)
+	})
+	sim.Deposit(decimal.New(30, 24))
+	sim.AdvanceEpoch(0.5)
+	sim.AdvanceEpoch(0.25)
+
+	keyPair, err := key.GenerateKeyPair(key.KeyTypeED25519, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := stakepool.New(stakepool.ServiceParam{
+		Ctx: context.Background(),
+		Log: zap.NewNop(),
+		Cfg: config.Config{
+			Node:             sim.URL(),
+			StakePool:        "pool.test.near",
+			KeyPair:          keyPair.PrivateEncoded(),
+			KeyPairAccountID: "operator.test.near",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status: %s", err)
+	}
+	m := New()
+	m.ObserveStatus(status)
+
+	for name, tt := range map[string]struct {
+		got, want float64
+	}{
+		"network_epoch_height": {testutil.ToFloat64(m.networkEpochHeight), 2},
+		"epoch_lag":            {testutil.ToFloat64(m.epochLag), 2},
+		"epoch_progress_ratio": {testutil.ToFloat64(m.epochProgress), 0.25},
+		"operator_balance":     {testutil.ToFloat64(m.operatorBalance), 5},
+		"classic_staked":       {testutil.ToFloat64(m.validatorBalance.WithLabelValues("a.test.near", "classic_staked")), 100},
+		"classic_unstaked":     {testutil.ToFloat64(m.fundBalance.WithLabelValues("classic_unstaked")), 30},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: %v, want %v", name, tt.got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(m.validatorBalance); n != 3 {
+		t.Errorf("validator_balance_near: %d series, want 3", n)
+	}
+}
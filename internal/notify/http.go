@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+)
+
+const DefaultTelegramURL = "https://api.telegram.org"
+
+var httpClient = &http.Client{}
+
+type (
+	// Webhook posts the alert as JSON.
+	Webhook struct {
+		URL string
+	}
+	// Slack posts the alert to a Slack compatible incoming webhook.
+	Slack struct {
+		WebhookURL string
+	}
+	// Telegram sends the alert with a bot to a chat of a Telegram compatible Bot API.
+	Telegram struct {
+		URL      string
+		BotToken string
+		ChatID   string
+	}
+)
+
+func (w Webhook) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, w.URL, alert)
+}
+
+func (s Slack) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.WebhookURL, map[string]string{"text": alert.Text()})
+}
+
+func (t Telegram) Notify(ctx context.Context, alert Alert) error {
+	url := strings.TrimSuffix(t.URL, "/") + "/bot" + t.BotToken + "/sendMessage"
+	return postJSON(ctx, url, map[string]string{"chat_id": t.ChatID, "text": alert.Text()})
+}
+
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "NewRequest")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// the url of telegram holds the bot token
+		return errors.New(strings.ReplaceAll(err.Error(), url, "<url>"))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package notify delivers operational alerts to on-call channels.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+const (
+	sendTimeout = 10 * time.Second
+	// queueSize bounds the alerts waiting for the notifiers, alerts are dropped when it is full.
+	queueSize = 64
+)
+
+type (
+	Severity int
+	// Alert is a problem report, alerts with the same Key are deduplicated.
+	Alert struct {
+		Key      string    `json:"key"`
+		Severity Severity  `json:"severity"`
+		Title    string    `json:"title"`
+		Message  string    `json:"message"`
+		Time     time.Time `json:"time"`
+	}
+	Notifier interface {
+		Notify(ctx context.Context, alert Alert) error
+	}
+	// Dispatcher sends alerts to all notifiers in the background, so slow notifiers do not hold up the
+	// caller. An active alert is repeated only after the repeat interval or when its severity rises;
+	// resolving it sends a recovery notice. Close flushes the queued alerts.
+	Dispatcher struct {
+		log       *zap.Logger
+		notifiers []Notifier
+		repeat    time.Duration
+		queue     chan Alert
+		done      chan struct{}
+
+		mu     sync.Mutex
+		active map[string]Alert
+		closed bool
+	}
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Text renders the alert for chat and mail notifiers.
+func (a Alert) Text() string {
+	return fmt.Sprintf("[%s] %s\n%s", strings.ToUpper(a.Severity.String()), a.Title, a.Message)
+}
+
+func NewDispatcher(log *zap.Logger, repeat time.Duration, notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		log:       log,
+		notifiers: notifiers,
+		repeat:    repeat,
+		queue:     make(chan Alert, queueSize),
+		done:      make(chan struct{}),
+		active:    map[string]Alert{},
+	}
+	go d.run()
+	return d
+}
+
+// Close sends the queued alerts and stops the dispatcher, later alerts are dropped.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+	<-d.done
+}
+
+// Alert sends the alert unless the same one was sent recently.
+func (d *Dispatcher) Alert(alert Alert) {
+	if alert.Time.IsZero() {
+		alert.Time = time.Now().UTC()
+	}
+	d.mu.Lock()
+	prev, ok := d.active[alert.Key]
+	if ok && alert.Severity <= prev.Severity && alert.Time.Sub(prev.Time) < d.repeat {
+		d.mu.Unlock()
+		d.log.Debug("alert: duplicate", zap.String("key", alert.Key))
+		return
+	}
+	d.active[alert.Key] = alert
+	d.enqueue(alert)
+	d.mu.Unlock()
+}
+
+// Resolve clears the active alert and sends a recovery notice.
+func (d *Dispatcher) Resolve(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, ok := d.active[key]
+	delete(d.active, key)
+	if !ok {
+		return
+	}
+	d.enqueue(Alert{
+		Key:      key,
+		Severity: SeverityInfo,
+		Title:    "Resolved: " + prev.Title,
+		Message:  fmt.Sprintf("active since %s", prev.Time.Format(time.RFC3339)),
+		Time:     time.Now().UTC(),
+	})
+}
+
+// enqueue hands the alert to the worker, d.mu is held by the caller.
+func (d *Dispatcher) enqueue(alert Alert) {
+	log := d.log.With(zap.String("key", alert.Key), zap.String("title", alert.Title))
+	if d.closed {
+		log.Warn("alert: dispatcher is closed, dropped")
+		return
+	}
+	select {
+	case d.queue <- alert:
+	default:
+		log.Error("alert: queue is full, dropped")
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for alert := range d.queue {
+		d.send(alert)
+	}
+}
+
+func (d *Dispatcher) send(alert Alert) {
+	log := d.log.With(zap.String("key", alert.Key), zap.Stringer("severity", alert.Severity), zap.String("title", alert.Title))
+	log.Warn("alert", zap.String("message", alert.Message))
+	for _, n := range d.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := n.Notify(ctx, alert)
+		cancel()
+		if err != nil {
+			log.Error("alert: notify", zap.String("notifier", fmt.Sprintf("%T", n)), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"lido-near-client/internal/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []notify.Alert
+}
+
+func (r *recorder) Notify(_ context.Context, alert notify.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func TestDispatcherDeduplication(t *testing.T) {
+	r := &recorder{}
+	d := notify.NewDispatcher(zap.NewNop(), time.Hour, r)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	alert := func(severity notify.Severity, after time.Duration) {
+		d.Alert(notify.Alert{Key: "lag", Severity: severity, Title: "lag", Time: start.Add(after)})
+	}
+
+	alert(notify.SeverityWarning, 0)
+	alert(notify.SeverityWarning, 10*time.Minute) // duplicate
+	alert(notify.SeverityCritical, 20*time.Minute)
+	alert(notify.SeverityWarning, 30*time.Minute) // lower than the active one
+	alert(notify.SeverityCritical, 90*time.Minute)
+	d.Resolve("lag")
+	d.Resolve("lag") // nothing active
+	alert(notify.SeverityWarning, 100*time.Minute)
+	d.Close()
+	alert(notify.SeverityCritical, 200*time.Minute) // closed
+
+	var got []string
+	for _, a := range r.alerts {
+		got = append(got, a.Severity.String()+" "+a.Title)
+	}
+	want := []string{"warning lag", "critical lag", "critical lag", "info Resolved: lag", "warning lag"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHTTPNotifiers(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies = map[string]map[string]interface{}{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		bodies[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	alert := notify.Alert{Key: "k", Severity: notify.SeverityCritical, Title: "Operator balance is low", Message: "0.0010 NEAR"}
+	notifiers := []notify.Notifier{
+		notify.Webhook{URL: server.URL + "/hook"},
+		notify.Slack{WebhookURL: server.URL + "/slack"},
+		notify.Telegram{URL: server.URL, BotToken: "token", ChatID: "42"},
+	}
+	for _, n := range notifiers {
+		if err := n.Notify(context.Background(), alert); err != nil {
+			t.Fatalf("%T: %v", n, err)
+		}
+	}
+
+	if bodies["/hook"]["severity"] != "critical" || bodies["/hook"]["key"] != "k" {
+		t.Errorf("webhook: %v", bodies["/hook"])
+	}
+	text := "[CRITICAL] Operator balance is low\n0.0010 NEAR"
+	if bodies["/slack"]["text"] != text {
+		t.Errorf("slack: %v", bodies["/slack"])
+	}
+	if tg := bodies["/bottoken/sendMessage"]; tg["chat_id"] != "42" || tg["text"] != text {
+		t.Errorf("telegram: %v", tg)
+	}
+}
+
+type blockingNotifier chan struct{}
+
+func (b blockingNotifier) Notify(ctx context.Context, _ notify.Alert) error {
+	select {
+	case <-b:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestDispatcherDoesNotBlock(t *testing.T) {
+	r := &recorder{}
+	unblock := make(blockingNotifier)
+	d := notify.NewDispatcher(zap.NewNop(), time.Hour, unblock, r)
+
+	sent := make(chan struct{})
+	go func() {
+		d.Alert(notify.Alert{Key: "a", Title: "a"})
+		d.Alert(notify.Alert{Key: "b", Title: "b"})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Alert waits for the notifiers")
+	}
+	close(unblock)
+	d.Close()
+	if len(r.alerts) != 2 {
+		t.Errorf("%d alerts flushed on close, want 2", len(r.alerts))
+	}
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP mails the alert, authenticating with PLAIN if Username is set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s SMTP) Notify(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return errors.Wrap(err, "SplitHostPort")
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [%s] %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.From, strings.Join(s.To, ", "), strings.ToUpper(alert.Severity.String()), alert.Title,
+		alert.Time.Format(time.RFC1123Z), alert.Message)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(msg))
+	}()
+	select {
+	case err := <-done:
+		return errors.Wrap(err, "SendMail")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+// Package price provides USD prices of coins.
+package price
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const DefaultCoinGeckoURL = "https://api.coingecko.com/api/v3"
+
+var _ stakepool.PriceProvider = (*CoinGecko)(nil)
+
+// CoinGecko reads prices from the CoinGecko simple price API or a compatible one.
+type CoinGecko struct {
+	url  string
+	http *http.Client
+}
+
+func NewCoinGecko(baseURL string) *CoinGecko {
+	return &CoinGecko{
+		url:  baseURL,
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *CoinGecko) USDPrice(ctx context.Context, coinID string) (decimal.Decimal, error) {
+	query := url.Values{"ids": {coinID}, "vs_currencies": {"usd"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/simple/price?"+query.Encode(), nil)
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "NewRequest")
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "Do")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decimal.Zero, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	var prices map[string]map[string]decimal.Decimal
+	err = json.NewDecoder(resp.Body).Decode(&prices)
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "Decode")
+	}
+	usd, ok := prices[coinID]["usd"]
+	if !ok {
+		return decimal.Zero, errors.Errorf("no usd price of %s", coinID)
+	}
+	return usd, nil
+}
@@ -0,0 +1,42 @@
+package price_test
+
+import (
+	"context"
+	"lido-near-client/internal/price"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoinGecko(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/simple/price" || r.URL.Query().Get("vs_currencies") != "usd" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("ids") {
+		case "near":
+			_, _ = w.Write([]byte(`{"near":{"usd":1.93}}`))
+		case "limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	c := price.NewCoinGecko(server.URL + "/api/v3")
+
+	usd, err := c.USDPrice(context.Background(), "near")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usd.String() != "1.93" {
+		t.Errorf("price: got %s, want 1.93", usd)
+	}
+	if _, err = c.USDPrice(context.Background(), "unknown"); err == nil {
+		t.Error("unknown coin: expected error")
+	}
+	if _, err = c.USDPrice(context.Background(), "limited"); err == nil {
+		t.Error("rate limited: expected error")
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/apy"
+	"time"
+)
+
+var _ apy.Source = (*Storage)(nil)
+
+const exchangeRateColumns = `pool_epoch_height, rate, reward_fee_numerator, reward_fee_denominator, observed_at`
+
+// SaveExchangeRate records the first exchange rate observed in the pool epoch.
+func (s *Storage) SaveExchangeRate(p apy.Point) error {
+	var feeNumerator, feeDenominator decimal.NullDecimal
+	if p.RewardFee != nil {
+		feeNumerator = decimal.NullDecimal{Decimal: p.RewardFee.Numerator, Valid: true}
+		feeDenominator = decimal.NullDecimal{Decimal: p.RewardFee.Denominator, Valid: true}
+	}
+	_, err := s.db.Exec(s.rebind(`INSERT INTO exchange_rates (`+exchangeRateColumns+`) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (pool_epoch_height) DO NOTHING`),
+		p.PoolEpochHeight, p.Rate, feeNumerator, feeDenominator, dbTime(p.ObservedAt))
+	if err != nil {
+		return errors.Wrap(err, "insert exchange rate")
+	}
+	return nil
+}
+
+func (s *Storage) LatestExchangeRate() (apy.Point, bool, error) {
+	return s.queryExchangeRate(`SELECT ` + exchangeRateColumns + ` FROM exchange_rates ORDER BY pool_epoch_height DESC LIMIT 1`)
+}
+
+func (s *Storage) ExchangeRateAtEpoch(poolEpochHeight uint64) (apy.Point, bool, error) {
+	return s.queryExchangeRate(`SELECT `+exchangeRateColumns+` FROM exchange_rates WHERE pool_epoch_height <= ?
+		ORDER BY pool_epoch_height DESC LIMIT 1`, poolEpochHeight)
+}
+
+func (s *Storage) ExchangeRateAt(t time.Time) (apy.Point, bool, error) {
+	return s.queryExchangeRate(`SELECT `+exchangeRateColumns+` FROM exchange_rates WHERE observed_at <= ?
+		ORDER BY observed_at DESC LIMIT 1`, dbTime(t))
+}
+
+func (s *Storage) queryExchangeRate(query string, args ...interface{}) (p apy.Point, ok bool, err error) {
+	var feeNumerator, feeDenominator decimal.NullDecimal
+	err = s.db.QueryRow(s.rebind(query), args...).Scan(&p.PoolEpochHeight, &p.Rate, &feeNumerator, &feeDenominator, &p.ObservedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, false, nil
+	}
+	if err != nil {
+		return p, false, errors.Wrap(err, "select exchange rate")
+	}
+	if feeNumerator.Valid && feeDenominator.Valid {
+		p.RewardFee = &stakepool.Dividing{Numerator: feeNumerator.Decimal, Denominator: feeDenominator.Decimal}
+	}
+	return p, true, nil
+}
+
+// dbTime keeps times comparable in sqlite, which stores them as text.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"lido-near-client/internal/application/stakepool"
+)
+
+// SaveValidatorFees stores the fees replacing earlier ones of the same epoch and validator.
+func (s *Storage) SaveValidatorFees(fees []stakepool.ValidatorFee) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, f := range fees {
+			_, err := tx.Exec(s.rebind(`DELETE FROM validator_fees WHERE epoch_height = ? AND account_id = ?`),
+				f.EpochHeight, f.AccountID)
+			if err != nil {
+				return errors.Wrap(err, "delete validator fee")
+			}
+			_, err = tx.Exec(s.rebind(`INSERT INTO validator_fees (epoch_height, account_id, reward_fee_numerator,
+				reward_fee_denominator) VALUES (?, ?, ?, ?)`),
+				f.EpochHeight, f.AccountID, f.RewardFee.Numerator, f.RewardFee.Denominator)
+			if err != nil {
+				return errors.Wrapf(err, "insert validator fee %s", f.AccountID)
+			}
+		}
+		return nil
+	})
+}
+
+// GetValidatorFees returns the fees of the epoch, the latest recorded epoch if epochHeight is zero.
+func (s *Storage) GetValidatorFees(epochHeight uint64) (fees []stakepool.ValidatorFee, err error) {
+	if epochHeight == 0 {
+		var latest sql.NullInt64
+		err = s.db.QueryRow(`SELECT MAX(epoch_height) FROM validator_fees`).Scan(&latest)
+		if err != nil {
+			return nil, errors.Wrap(err, "select latest epoch")
+		}
+		if !latest.Valid {
+			return nil, ErrNotFound
+		}
+		epochHeight = uint64(latest.Int64)
+	}
+	rows, err := s.db.Query(s.rebind(`SELECT epoch_height, account_id, reward_fee_numerator, reward_fee_denominator
+		FROM validator_fees WHERE epoch_height = ? ORDER BY account_id`), epochHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "select validator fees")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f stakepool.ValidatorFee
+		err = rows.Scan(&f.EpochHeight, &f.AccountID, &f.RewardFee.Numerator, &f.RewardFee.Denominator)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan validator fee")
+		}
+		fees = append(fees, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+	if len(fees) == 0 {
+		return nil, ErrNotFound
+	}
+	return fees, nil
+}
@@ -0,0 +1,57 @@
+CREATE TABLE IF NOT EXISTS snapshots
+(
+    pool_epoch_height    BIGINT PRIMARY KEY,
+    network_epoch_height BIGINT      NOT NULL,
+    created_at           TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS validator_snapshots
+(
+    pool_epoch_height                          BIGINT  NOT NULL REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    account_id                                 TEXT    NOT NULL,
+    classic_staked_balance                     NUMERIC NOT NULL,
+    investment_staked_balance                  NUMERIC NOT NULL,
+    unstaked_balance                           NUMERIC NOT NULL,
+    is_only_for_investment                     BOOLEAN NOT NULL,
+    last_update_epoch_height                   BIGINT  NOT NULL,
+    last_classic_stake_increasing_epoch_height BIGINT,
+    PRIMARY KEY (pool_epoch_height, account_id)
+);
+
+CREATE TABLE IF NOT EXISTS fund_snapshots
+(
+    pool_epoch_height         BIGINT PRIMARY KEY REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    classic_unstaked_balance  NUMERIC NOT NULL,
+    classic_staked_balance    NUMERIC NOT NULL,
+    investment_staked_balance NUMERIC NOT NULL,
+    common_staked_balance     NUMERIC NOT NULL,
+    common_balance            NUMERIC NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS agg_info_snapshots
+(
+    pool_epoch_height                         BIGINT PRIMARY KEY REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    unstaked_balance                          NUMERIC NOT NULL,
+    staked_balance                            NUMERIC NOT NULL,
+    token_total_supply                        NUMERIC NOT NULL,
+    token_accounts_quantity                   BIGINT  NOT NULL,
+    total_rewards_from_validators_near_amount NUMERIC NOT NULL,
+    reward_fee_numerator                      NUMERIC,
+    reward_fee_denominator                    NUMERIC
+);
+
+CREATE TABLE IF NOT EXISTS transactions
+(
+    id                   BIGSERIAL PRIMARY KEY,
+    network_epoch_height BIGINT      NOT NULL,
+    job                  TEXT        NOT NULL,
+    method               TEXT        NOT NULL,
+    validator            TEXT        NOT NULL,
+    amount               NUMERIC     NOT NULL,
+    tx_hash              TEXT        NOT NULL,
+    gas_burnt            BIGINT      NOT NULL,
+    error                TEXT        NOT NULL,
+    created_at           TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transactions_network_epoch_height_idx ON transactions (network_epoch_height);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    pool_epoch_height      BIGINT PRIMARY KEY,
+    rate                   NUMERIC     NOT NULL,
+    reward_fee_numerator   NUMERIC,
+    reward_fee_denominator NUMERIC,
+    observed_at            TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS exchange_rates_observed_at_idx ON exchange_rates (observed_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS validator_performance
+(
+    epoch_height    BIGINT           NOT NULL,
+    account_id      TEXT             NOT NULL,
+    active          BOOLEAN          NOT NULL,
+    produced_blocks BIGINT           NOT NULL,
+    expected_blocks BIGINT           NOT NULL,
+    produced_chunks BIGINT           NOT NULL,
+    expected_chunks BIGINT           NOT NULL,
+    uptime          DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (epoch_height, account_id)
+);
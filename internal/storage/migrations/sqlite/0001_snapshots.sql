@@ -0,0 +1,57 @@
+CREATE TABLE IF NOT EXISTS snapshots
+(
+    pool_epoch_height    INTEGER PRIMARY KEY,
+    network_epoch_height INTEGER  NOT NULL,
+    created_at           DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS validator_snapshots
+(
+    pool_epoch_height                          INTEGER NOT NULL REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    account_id                                 TEXT    NOT NULL,
+    classic_staked_balance                     TEXT    NOT NULL,
+    investment_staked_balance                  TEXT    NOT NULL,
+    unstaked_balance                           TEXT    NOT NULL,
+    is_only_for_investment                     BOOLEAN NOT NULL,
+    last_update_epoch_height                   INTEGER NOT NULL,
+    last_classic_stake_increasing_epoch_height INTEGER,
+    PRIMARY KEY (pool_epoch_height, account_id)
+);
+
+CREATE TABLE IF NOT EXISTS fund_snapshots
+(
+    pool_epoch_height         INTEGER PRIMARY KEY REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    classic_unstaked_balance  TEXT NOT NULL,
+    classic_staked_balance    TEXT NOT NULL,
+    investment_staked_balance TEXT NOT NULL,
+    common_staked_balance     TEXT NOT NULL,
+    common_balance            TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS agg_info_snapshots
+(
+    pool_epoch_height                         INTEGER PRIMARY KEY REFERENCES snapshots (pool_epoch_height) ON DELETE CASCADE,
+    unstaked_balance                          TEXT    NOT NULL,
+    staked_balance                            TEXT    NOT NULL,
+    token_total_supply                        TEXT    NOT NULL,
+    token_accounts_quantity                   INTEGER NOT NULL,
+    total_rewards_from_validators_near_amount TEXT    NOT NULL,
+    reward_fee_numerator                      TEXT,
+    reward_fee_denominator                    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS transactions
+(
+    id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+    network_epoch_height INTEGER  NOT NULL,
+    job                  TEXT     NOT NULL,
+    method               TEXT     NOT NULL,
+    validator            TEXT     NOT NULL,
+    amount               TEXT     NOT NULL,
+    tx_hash              TEXT     NOT NULL,
+    gas_burnt            INTEGER  NOT NULL,
+    error                TEXT     NOT NULL,
+    created_at           DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transactions_network_epoch_height_idx ON transactions (network_epoch_height);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    pool_epoch_height      INTEGER PRIMARY KEY,
+    rate                   TEXT     NOT NULL,
+    reward_fee_numerator   TEXT,
+    reward_fee_denominator TEXT,
+    observed_at            DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS exchange_rates_observed_at_idx ON exchange_rates (observed_at);
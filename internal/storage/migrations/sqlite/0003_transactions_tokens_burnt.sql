@@ -0,0 +1 @@
+ALTER TABLE transactions ADD COLUMN tokens_burnt TEXT NOT NULL DEFAULT '0';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS validator_performance
+(
+    epoch_height    INTEGER NOT NULL,
+    account_id      TEXT    NOT NULL,
+    active          BOOLEAN NOT NULL,
+    produced_blocks INTEGER NOT NULL,
+    expected_blocks INTEGER NOT NULL,
+    produced_chunks INTEGER NOT NULL,
+    expected_chunks INTEGER NOT NULL,
+    uptime          REAL    NOT NULL,
+    PRIMARY KEY (epoch_height, account_id)
+);
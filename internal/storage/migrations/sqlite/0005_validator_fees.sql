@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS validator_fees
+(
+    epoch_height           INTEGER NOT NULL,
+    account_id             TEXT    NOT NULL,
+    reward_fee_numerator   TEXT    NOT NULL,
+    reward_fee_denominator TEXT    NOT NULL,
+    PRIMARY KEY (epoch_height, account_id)
+);
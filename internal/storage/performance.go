@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"lido-near-client/internal/application/stakepool"
+)
+
+// SaveValidatorPerformance stores the scores replacing earlier ones of the same epoch and validator.
+func (s *Storage) SaveValidatorPerformance(performance []stakepool.ValidatorPerformance) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, p := range performance {
+			_, err := tx.Exec(s.rebind(`DELETE FROM validator_performance WHERE epoch_height = ? AND account_id = ?`),
+				p.EpochHeight, p.AccountID)
+			if err != nil {
+				return errors.Wrap(err, "delete validator performance")
+			}
+			_, err = tx.Exec(s.rebind(`INSERT INTO validator_performance (epoch_height, account_id, active, produced_blocks,
+				expected_blocks, produced_chunks, expected_chunks, uptime) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+				p.EpochHeight, p.AccountID, p.Active, p.ProducedBlocks, p.ExpectedBlocks, p.ProducedChunks, p.ExpectedChunks, p.Uptime)
+			if err != nil {
+				return errors.Wrapf(err, "insert validator performance %s", p.AccountID)
+			}
+		}
+		return nil
+	})
+}
+
+// GetValidatorPerformance returns the scores of the epoch, the latest scored epoch if epochHeight is zero.
+func (s *Storage) GetValidatorPerformance(epochHeight uint64) (performance []stakepool.ValidatorPerformance, err error) {
+	if epochHeight == 0 {
+		var latest sql.NullInt64
+		err = s.db.QueryRow(`SELECT MAX(epoch_height) FROM validator_performance`).Scan(&latest)
+		if err != nil {
+			return nil, errors.Wrap(err, "select latest epoch")
+		}
+		if !latest.Valid {
+			return nil, ErrNotFound
+		}
+		epochHeight = uint64(latest.Int64)
+	}
+	rows, err := s.db.Query(s.rebind(`SELECT epoch_height, account_id, active, produced_blocks, expected_blocks,
+		produced_chunks, expected_chunks, uptime FROM validator_performance WHERE epoch_height = ? ORDER BY account_id`), epochHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "select validator performance")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p stakepool.ValidatorPerformance
+		err = rows.Scan(&p.EpochHeight, &p.AccountID, &p.Active, &p.ProducedBlocks, &p.ExpectedBlocks,
+			&p.ProducedChunks, &p.ExpectedChunks, &p.Uptime)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan validator performance")
+		}
+		performance = append(performance, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+	if len(performance) == 0 {
+		return nil, ErrNotFound
+	}
+	return performance, nil
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"time"
+)
+
+type (
+	// Snapshot is the pool state observed during a pool epoch.
+	Snapshot struct {
+		Epochs     stakepool.EpochHeightRegistry `json:"epochs"`
+		Validators []stakepool.Validator         `json:"validators"`
+		Fund       stakepool.Fund                `json:"fund"`
+		AggInfo    stakepool.AggInfo             `json:"agg_info"`
+		CreatedAt  time.Time                     `json:"created_at"`
+	}
+)
+
+// SaveSnapshot stores the snapshot of its pool epoch, replacing an earlier one of the same epoch.
+func (s *Storage) SaveSnapshot(snapshot Snapshot) error {
+	epoch := snapshot.Epochs.PoolEpochHeight
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind(`DELETE FROM snapshots WHERE pool_epoch_height = ?`), epoch)
+		if err != nil {
+			return errors.Wrap(err, "delete snapshot")
+		}
+		_, err = tx.Exec(s.rebind(`INSERT INTO snapshots (pool_epoch_height, network_epoch_height, created_at) VALUES (?, ?, ?)`),
+			epoch, snapshot.Epochs.NetworkEpochHeight, snapshot.CreatedAt.UTC())
+		if err != nil {
+			return errors.Wrap(err, "insert snapshot")
+		}
+		for _, v := range snapshot.Validators {
+			var lastIncrease sql.NullInt64
+			if v.LastClassicStakeIncreasingEpochHeight != nil {
+				lastIncrease = sql.NullInt64{Int64: int64(*v.LastClassicStakeIncreasingEpochHeight), Valid: true}
+			}
+			_, err = tx.Exec(s.rebind(`INSERT INTO validator_snapshots (pool_epoch_height, account_id, classic_staked_balance,
+				investment_staked_balance, unstaked_balance, is_only_for_investment, last_update_epoch_height,
+				last_classic_stake_increasing_epoch_height) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+				epoch, v.AccountID, v.ClassicStakedBalance, v.InvestmentStakedBalance, v.UnstakedBalance,
+				v.IsOnlyForInvestment, v.LastUpdateEpochHeight, lastIncrease)
+			if err != nil {
+				return errors.Wrapf(err, "insert validator %s", v.AccountID)
+			}
+		}
+		f := snapshot.Fund
+		_, err = tx.Exec(s.rebind(`INSERT INTO fund_snapshots (pool_epoch_height, classic_unstaked_balance, classic_staked_balance,
+			investment_staked_balance, common_staked_balance, common_balance) VALUES (?, ?, ?, ?, ?, ?)`),
+			epoch, f.ClassicUnstakedBalance, f.ClassicStakedBalance, f.InvestmentStakedBalance, f.CommonStakedBalance, f.CommonBalance)
+		if err != nil {
+			return errors.Wrap(err, "insert fund")
+		}
+		a := snapshot.AggInfo
+		var feeNumerator, feeDenominator decimal.NullDecimal
+		if a.RewardFee != nil {
+			feeNumerator = decimal.NullDecimal{Decimal: a.RewardFee.Numerator, Valid: true}
+			feeDenominator = decimal.NullDecimal{Decimal: a.RewardFee.Denominator, Valid: true}
+		}
+		_, err = tx.Exec(s.rebind(`INSERT INTO agg_info_snapshots (pool_epoch_height, unstaked_balance, staked_balance,
+			token_total_supply, token_accounts_quantity, total_rewards_from_validators_near_amount,
+			reward_fee_numerator, reward_fee_denominator) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+			epoch, a.UnstakedBalance, a.StakedBalance, a.TokenTotalSupply, a.TokenAccountsQuantity,
+			a.TotalRewardsFromValidatorsNearAmount, feeNumerator, feeDenominator)
+		if err != nil {
+			return errors.Wrap(err, "insert agg info")
+		}
+		return nil
+	})
+}
+
+// GetSnapshot returns the snapshot of the pool epoch or ErrNotFound.
+func (s *Storage) GetSnapshot(poolEpochHeight uint64) (snapshot Snapshot, err error) {
+	err = s.db.QueryRow(s.rebind(`SELECT pool_epoch_height, network_epoch_height, created_at FROM snapshots WHERE pool_epoch_height = ?`),
+		poolEpochHeight).Scan(&snapshot.Epochs.PoolEpochHeight, &snapshot.Epochs.NetworkEpochHeight, &snapshot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return snapshot, ErrNotFound
+	}
+	if err != nil {
+		return snapshot, errors.Wrap(err, "select snapshot")
+	}
+
+	rows, err := s.db.Query(s.rebind(`SELECT account_id, classic_staked_balance, investment_staked_balance, unstaked_balance,
+		is_only_for_investment, last_update_epoch_height, last_classic_stake_increasing_epoch_height
+		FROM validator_snapshots WHERE pool_epoch_height = ? ORDER BY account_id`), poolEpochHeight)
+	if err != nil {
+		return snapshot, errors.Wrap(err, "select validators")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			v            stakepool.Validator
+			lastIncrease sql.NullInt64
+		)
+		err = rows.Scan(&v.AccountID, &v.ClassicStakedBalance, &v.InvestmentStakedBalance, &v.UnstakedBalance,
+			&v.IsOnlyForInvestment, &v.LastUpdateEpochHeight, &lastIncrease)
+		if err != nil {
+			return snapshot, errors.Wrap(err, "scan validator")
+		}
+		if lastIncrease.Valid {
+			epoch := uint64(lastIncrease.Int64)
+			v.LastClassicStakeIncreasingEpochHeight = &epoch
+		}
+		snapshot.Validators = append(snapshot.Validators, v)
+	}
+	if err = rows.Err(); err != nil {
+		return snapshot, errors.Wrap(err, "rows")
+	}
+
+	f := &snapshot.Fund
+	err = s.db.QueryRow(s.rebind(`SELECT classic_unstaked_balance, classic_staked_balance, investment_staked_balance,
+		common_staked_balance, common_balance FROM fund_snapshots WHERE pool_epoch_height = ?`), poolEpochHeight).
+		Scan(&f.ClassicUnstakedBalance, &f.ClassicStakedBalance, &f.InvestmentStakedBalance, &f.CommonStakedBalance, &f.CommonBalance)
+	if err != nil {
+		return snapshot, errors.Wrap(err, "select fund")
+	}
+
+	snapshot.AggInfo, err = s.getAggInfo(poolEpochHeight)
+	if err != nil {
+		return snapshot, errors.Wrap(err, "getAggInfo")
+	}
+	return snapshot, nil
+}
+
+func (s *Storage) getAggInfo(poolEpochHeight uint64) (a stakepool.AggInfo, err error) {
+	var feeNumerator, feeDenominator decimal.NullDecimal
+	err = s.db.QueryRow(s.rebind(`SELECT unstaked_balance, staked_balance, token_total_supply, token_accounts_quantity,
+		total_rewards_from_validators_near_amount, reward_fee_numerator, reward_fee_denominator
+		FROM agg_info_snapshots WHERE pool_epoch_height = ?`), poolEpochHeight).
+		Scan(&a.UnstakedBalance, &a.StakedBalance, &a.TokenTotalSupply, &a.TokenAccountsQuantity,
+			&a.TotalRewardsFromValidatorsNearAmount, &feeNumerator, &feeDenominator)
+	if err != nil {
+		return a, errors.Wrap(err, "select agg info")
+	}
+	if feeNumerator.Valid && feeDenominator.Valid {
+		a.RewardFee = &stakepool.Dividing{Numerator: feeNumerator.Decimal, Denominator: feeDenominator.Decimal}
+	}
+	return a, nil
+}
@@ -0,0 +1,169 @@
+// Package storage persists per-epoch snapshots of the stake pool and the operator transactions.
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+var ErrNotFound = errors.New("not found")
+
+//go:embed migrations
+var migrations embed.FS
+
+type Storage struct {
+	db     *sql.DB
+	driver string
+}
+
+// Open connects to the database and applies pending migrations.
+func Open(driver string, dsn string) (*Storage, error) {
+	s, err := connect(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = s.migrate()
+	if err != nil {
+		_ = s.db.Close()
+		return nil, errors.Wrap(err, "migrate")
+	}
+	return s, nil
+}
+
+// OpenReadOnly connects to the database without applying migrations. A sqlite file is opened read-only, so it
+// is not created if it does not exist.
+func OpenReadOnly(driver string, dsn string) (*Storage, error) {
+	if driver == DriverSQLite {
+		if _, err := os.Stat(strings.TrimPrefix(strings.SplitN(dsn, "?", 2)[0], "file:")); err != nil {
+			return nil, errors.Wrap(err, "os.Stat")
+		}
+		if !strings.HasPrefix(dsn, "file:") {
+			dsn = "file:" + dsn
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&mode=ro"
+		} else {
+			dsn += "?mode=ro"
+		}
+	}
+	return connect(driver, dsn)
+}
+
+func connect(driver string, dsn string) (*Storage, error) {
+	if driver != DriverPostgres && driver != DriverSQLite {
+		return nil, errors.Errorf("unknown driver %s", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	s := &Storage{db: db, driver: driver}
+	if driver == DriverSQLite {
+		// sqlite allows one writer at a time, pragmas are per connection
+		db.SetMaxOpenConns(1)
+		_, err = db.Exec("PRAGMA foreign_keys = ON")
+		if err != nil {
+			_ = db.Close()
+			return nil, errors.Wrap(err, "PRAGMA foreign_keys")
+		}
+	}
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "Ping")
+	}
+	return s, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version TEXT PRIMARY KEY, applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+	dir := path.Join("migrations", s.driver)
+	entries, err := migrations.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "ReadDir")
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		var applied int
+		err = s.db.QueryRow(s.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&applied)
+		if err != nil {
+			return errors.Wrap(err, "select schema_migrations")
+		}
+		if applied != 0 {
+			continue
+		}
+		query, err := migrations.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return errors.Wrap(err, "ReadFile")
+		}
+		err = s.inTx(func(tx *sql.Tx) error {
+			_, err := tx.Exec(string(query))
+			if err != nil {
+				return errors.Wrap(err, "exec")
+			}
+			_, err = tx.Exec(s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "migration %s", version)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebind replaces ? placeholders with the driver specific ones.
+func (s *Storage) rebind(query string) string {
+	if s.driver != DriverPostgres {
+		return query
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,202 @@
+package storage_test
+
+import (
+	"github.com/shopspring/decimal"
+	"lido-near-client/internal/application/stakepool"
+	"lido-near-client/internal/apy"
+	"lido-near-client/internal/storage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openSQLite(t *testing.T, path string) *storage.Storage {
+	t.Helper()
+	s, err := storage.Open(storage.DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return s
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lido.db")
+	s := openSQLite(t, path)
+
+	lastIncrease := uint64(11)
+	snapshot := storage.Snapshot{
+		Epochs: stakepool.EpochHeightRegistry{PoolEpochHeight: 12, NetworkEpochHeight: 12},
+		Validators: []stakepool.Validator{{
+			AccountID:                             "a.poolv1.near",
+			ClassicStakedBalance:                  decimal.RequireFromString("100000000000000000000000000"),
+			InvestmentStakedBalance:               decimal.Zero,
+			UnstakedBalance:                       decimal.RequireFromString("5"),
+			LastUpdateEpochHeight:                 12,
+			LastClassicStakeIncreasingEpochHeight: &lastIncrease,
+		}, {
+			AccountID:               "b.poolv1.near",
+			ClassicStakedBalance:    decimal.Zero,
+			InvestmentStakedBalance: decimal.RequireFromString("7"),
+			UnstakedBalance:         decimal.Zero,
+			IsOnlyForInvestment:     true,
+			LastUpdateEpochHeight:   11,
+		}},
+		Fund: stakepool.Fund{
+			ClassicUnstakedBalance:  decimal.Zero,
+			ClassicStakedBalance:    decimal.RequireFromString("100000000000000000000000000"),
+			InvestmentStakedBalance: decimal.RequireFromString("7"),
+			CommonStakedBalance:     decimal.RequireFromString("100000000000000000000000007"),
+			CommonBalance:           decimal.RequireFromString("100000000000000000000000007"),
+		},
+		AggInfo: stakepool.AggInfo{
+			UnstakedBalance:                      decimal.Zero,
+			StakedBalance:                        decimal.RequireFromString("100000000000000000000000007"),
+			TokenTotalSupply:                     decimal.RequireFromString("99000000000000000000000000"),
+			TokenAccountsQuantity:                3,
+			TotalRewardsFromValidatorsNearAmount: decimal.RequireFromString("1000000000000000000000000"),
+			RewardFee:                            &stakepool.Dividing{Numerator: decimal.NewFromInt(1), Denominator: decimal.NewFromInt(10)},
+		},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := s.SaveSnapshot(snapshot); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// the latest state of the epoch replaces the earlier one
+	snapshot.AggInfo.TokenAccountsQuantity = 4
+	if err := s.SaveSnapshot(snapshot); err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+	if err := s.SaveTransaction(storage.Transaction{
+		NetworkEpochHeight: 12, Job: stakepool.PoolUpdateJob, Method: "update", Amount: decimal.Zero,
+		TxHash: "hash", GasBurnt: 5_000_000_000_000, CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("save transaction: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// reopening must not reapply migrations
+	s = openSQLite(t, path)
+	defer s.Close()
+
+	got, err := s.GetSnapshot(12)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if len(got.Validators) != 2 {
+		t.Fatalf("validators: got %d, want 2", len(got.Validators))
+	}
+	if !got.Validators[0].ClassicStakedBalance.Equal(snapshot.Validators[0].ClassicStakedBalance) ||
+		got.Validators[0].LastClassicStakeIncreasingEpochHeight == nil || *got.Validators[0].LastClassicStakeIncreasingEpochHeight != 11 {
+		t.Errorf("validator a: got %+v", got.Validators[0])
+	}
+	if !got.Validators[1].IsOnlyForInvestment || got.Validators[1].LastClassicStakeIncreasingEpochHeight != nil {
+		t.Errorf("validator b: got %+v", got.Validators[1])
+	}
+	if !got.Fund.CommonBalance.Equal(snapshot.Fund.CommonBalance) {
+		t.Errorf("fund: got %s", got.Fund.CommonBalance)
+	}
+	if got.AggInfo.TokenAccountsQuantity != 4 || got.AggInfo.RewardFee == nil || !got.AggInfo.RewardFee.Denominator.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("agg info: got %+v", got.AggInfo)
+	}
+	if _, err = s.GetSnapshot(13); err != storage.ErrNotFound {
+		t.Errorf("missing snapshot: got %v", err)
+	}
+
+	txs, err := s.GetTransactions(12)
+	if err != nil {
+		t.Fatalf("transactions: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Method != "update" || txs[0].GasBurnt != 5_000_000_000_000 {
+		t.Errorf("transactions: got %+v", txs)
+	}
+}
+
+func TestExchangeRates(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), "lido.db"))
+	defer s.Close()
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for e := uint64(10); e < 15; e++ {
+		err := s.SaveExchangeRate(apy.Point{
+			PoolEpochHeight: e,
+			Rate:            decimal.NewFromInt(int64(e)),
+			ObservedAt:      start.Add(time.Duration(e) * 12 * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	// the first observation of the epoch is kept
+	if err := s.SaveExchangeRate(apy.Point{PoolEpochHeight: 14, Rate: decimal.NewFromInt(1), ObservedAt: time.Now()}); err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+
+	latest, ok, err := s.LatestExchangeRate()
+	if err != nil || !ok || latest.PoolEpochHeight != 14 || !latest.Rate.Equal(decimal.NewFromInt(14)) {
+		t.Errorf("latest: %+v %t %v", latest, ok, err)
+	}
+	p, ok, err := s.ExchangeRateAtEpoch(9)
+	if err != nil || ok {
+		t.Errorf("before history: %+v %t %v", p, ok, err)
+	}
+	p, ok, err = s.ExchangeRateAt(start.Add(12*12*time.Hour + time.Hour))
+	if err != nil || !ok || p.PoolEpochHeight != 12 || !p.ObservedAt.Equal(start.Add(12*12*time.Hour)) {
+		t.Errorf("at time: %+v %t %v", p, ok, err)
+	}
+}
+
+func TestValidatorFees(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), "lido.db"))
+	defer s.Close()
+
+	if _, err := s.GetValidatorFees(0); err != storage.ErrNotFound {
+		t.Fatalf("empty: %v", err)
+	}
+	for e := uint64(10); e < 12; e++ {
+		err := s.SaveValidatorFees([]stakepool.ValidatorFee{{
+			AccountID:   "a.poolv1.near",
+			EpochHeight: e,
+			RewardFee:   stakepool.Dividing{Numerator: decimal.NewFromInt(int64(e)), Denominator: decimal.NewFromInt(100)},
+		}})
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	fees, err := s.GetValidatorFees(0)
+	if err != nil || len(fees) != 1 || fees[0].EpochHeight != 11 || !fees[0].Fraction().Equal(decimal.NewFromFloat(0.11)) {
+		t.Errorf("latest: %+v %v", fees, err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lido.db")
+	if _, err := storage.OpenReadOnly(storage.DriverSQLite, path); err == nil {
+		t.Fatal("OpenReadOnly of a missing file: want an error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("missing file is created: %v", err)
+	}
+
+	s := openSQLite(t, path)
+	snapshot := storage.Snapshot{Epochs: stakepool.EpochHeightRegistry{PoolEpochHeight: 3, NetworkEpochHeight: 3}, CreatedAt: time.Now()}
+	if err := s.SaveSnapshot(snapshot); err != nil {
+		t.Fatalf("SaveSnapshot: %s", err)
+	}
+	s.Close()
+
+	ro, err := storage.OpenReadOnly(storage.DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %s", err)
+	}
+	defer ro.Close()
+	if _, err = ro.GetSnapshot(3); err != nil {
+		t.Errorf("GetSnapshot: %s", err)
+	}
+	snapshot.Epochs.PoolEpochHeight = 4
+	if err = ro.SaveSnapshot(snapshot); err == nil {
+		t.Error("SaveSnapshot: want a read-only error")
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type (
+	// Transaction is a contract call sent by the operator.
+	Transaction struct {
+		NetworkEpochHeight uint64          `json:"network_epoch_height"`
+		Job                string          `json:"job"`
+		Method             string          `json:"method"`
+		Validator          string          `json:"validator"`
+		Amount             decimal.Decimal `json:"amount"`
+		TxHash             string          `json:"tx_hash"`
+		GasBurnt           uint64          `json:"gas_burnt"`
+		TokensBurnt        decimal.Decimal `json:"tokens_burnt"`
+		Error              string          `json:"error,omitempty"`
+		CreatedAt          time.Time       `json:"created_at"`
+	}
+)
+
+func (s *Storage) SaveTransaction(tx Transaction) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO transactions (network_epoch_height, job, method, validator, amount, tx_hash,
+		gas_burnt, tokens_burnt, error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		tx.NetworkEpochHeight, tx.Job, tx.Method, tx.Validator, tx.Amount, tx.TxHash, tx.GasBurnt, tx.TokensBurnt, tx.Error,
+		tx.CreatedAt.UTC())
+	if err != nil {
+		return errors.Wrap(err, "insert transaction")
+	}
+	return nil
+}
+
+// GetTransactions returns transactions sent during the network epoch in sending order.
+func (s *Storage) GetTransactions(networkEpochHeight uint64) (txs []Transaction, err error) {
+	rows, err := s.db.Query(s.rebind(`SELECT network_epoch_height, job, method, validator, amount, tx_hash, gas_burnt, tokens_burnt, error,
+		created_at FROM transactions WHERE network_epoch_height = ? ORDER BY id`), networkEpochHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "select transactions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tx Transaction
+		err = rows.Scan(&tx.NetworkEpochHeight, &tx.Job, &tx.Method, &tx.Validator, &tx.Amount, &tx.TxHash,
+			&tx.GasBurnt, &tx.TokensBurnt, &tx.Error, &tx.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan transaction")
+		}
+		txs = append(txs, tx)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+	return txs, nil
+}